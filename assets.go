@@ -0,0 +1,136 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+
+    "github.com/shopspring/decimal"
+)
+
+// 多资产余额
+// 默认资产（DEFAULT_ASSET，见 supply.go）的余额仍然存储在 u_b: 下，其他函数（transfer、withdraw 等）都只处理默认资产。
+// 其他资产的余额存储在 u_a: 下，key 为 [用户名, 资产]，没有记录时为 0；operator 通过 rechargeAsset 充值，
+// 可以通过 transferWithConversion 在资产之间兑换（见 conversion.go）。
+// 其他资产不计息、没有透支额度，也不受发行规则（见 issuance.go）限制。
+
+// 读取 username 在 asset 下的余额，username 未注册时返回错误
+func getAssetBalance(stub shim.ChaincodeStubInterface, arg_name, username, asset string) (decimal.Decimal, error) {
+    if asset == DEFAULT_ASSET {
+        return getStoredBalance(stub, arg_name, username)
+    }
+
+    if err := checkRegistered(stub, arg_name, username); err != nil {
+        return decimal.Zero, err
+    }
+
+    asset_balance_key, err := stub.CreateCompositeKey("u_a:", []string{username, asset})
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("asset is not valid. %s", err.Error())
+    }
+
+    balanceAsBytes, err := stub.GetState(asset_balance_key)
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if balanceAsBytes == nil {
+        return decimal.Zero, nil
+    }
+
+    balance, err := decimal.NewFromString(string(balanceAsBytes))
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("Failed to parse balance stored. %s", err.Error())
+    }
+
+    return balance, nil
+}
+
+// 修改 username 在 asset 下的余额，默认资产通过 setBalance 修改
+func setAssetBalance(stub shim.ChaincodeStubInterface, username, asset string, old_balance, new_balance decimal.Decimal) error {
+    if asset == DEFAULT_ASSET {
+        return setBalance(stub, username, old_balance, new_balance)
+    }
+
+    asset_balance_key, err := stub.CreateCompositeKey("u_a:", []string{username, asset})
+    if err != nil {
+        return fmt.Errorf("asset is not valid. %s", err.Error())
+    }
+
+    err = stub.PutState(asset_balance_key, []byte(new_balance.String()))
+    if err != nil {
+        return fmt.Errorf("Failed to put state. %s", err.Error())
+    }
+
+    return nil
+}
+
+// 充值默认资产以外的资产，只有 operator 可以调用，默认资产通过 recharge 充值
+// 返回值：nil，被制裁名单拦截时返回值见 screening.go
+func (cc *RestrainedTransferCC) rechargeAsset(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 3 {
+        return shim.Error(`parameter error. usage: "{fcn: 'rechargeAsset', args: ['username', 'asset', 'amount']}"`)
+    }
+
+    username := strings.TrimSpace(args[0])
+    asset := strings.TrimSpace(args[1])
+    amount_str := strings.TrimSpace(args[2])
+
+    if len(asset) == 0 {
+        return shim.Error("asset should not be empty.")
+    }
+    if asset == DEFAULT_ASSET {
+        return shim.Error("use recharge for asset " + DEFAULT_ASSET + ".")
+    }
+
+    var err error
+
+    amount, err := validateAmount(stub, asset, "amount", amount_str)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkCallerRole(stub, ROLE_OPERATOR); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    balance, err := getAssetBalance(stub, "username", username, asset)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = screen(stub, "recharge", amount.String(), username); err != nil {
+        return screeningErrorResponse(err)
+    }
+
+    err = setAssetBalance(stub, username, asset, balance, balance.Add(amount))
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    err = adjustTotalSupply(stub, asset, amount)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询 username 在 asset 下的余额
+// 返回值：十进制数 字符串，如 123.456
+func (cc *RestrainedTransferCC) getAssetBalance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getAssetBalance', args: ['username', 'asset']}"`)
+    }
+
+    username := strings.TrimSpace(args[0])
+    asset := strings.TrimSpace(args[1])
+
+    balance, err := getAssetBalance(stub, "username", username, asset)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success([]byte(balance.String()))
+}
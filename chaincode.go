@@ -35,15 +35,39 @@ func (t RestraintType) allow() bool {
 
 type MAP map[string]interface{}
 
+// 检查用户是否已注册
+// arg_name 用于错误信息，如 username_a
+func checkRegistered(stub shim.ChaincodeStubInterface, arg_name, username string) error {
+    user_info_key, err := stub.CreateCompositeKey("u_i:", []string{username})
+    if err != nil {
+        return fmt.Errorf("%s is not valid. %s", arg_name, err.Error())
+    }
+
+    userAsBytes, err := stub.GetState(user_info_key)
+    if err != nil {
+        return fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if userAsBytes == nil {
+        return fmt.Errorf("%s %s is not registered.", arg_name, username)
+    }
+
+    return nil
+}
+
 type RestrainedTransferCC struct {
 }
 
+// 实例化链码的身份成为 admin，见 roles.go
 func (cc *RestrainedTransferCC) Init(stub shim.ChaincodeStubInterface) pb.Response {
-    // do nothing
+    err := initAdmin(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
     return shim.Success(nil)
 }
 
 func (cc *RestrainedTransferCC) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+    stub = newTxStub(stub)
     function, args := stub.GetFunctionAndParameters()
     switch function {
     case "register":
@@ -64,12 +88,222 @@ func (cc *RestrainedTransferCC) Invoke(stub shim.ChaincodeStubInterface) pb.Resp
         return  cc.getRestraintsOfUser(stub, args)
     case "getRestraintBetweenUsers":
         return  cc.getRestraintBetweenUsers(stub, args)
+    case "getEffectiveRestraint":
+        return  cc.getEffectiveRestraint(stub, args)
+    case "createGroup":
+        return  cc.createGroup(stub, args)
+    case "getGroupInfo":
+        return  cc.getGroupInfo(stub, args)
+    case "addGroupMember":
+        return  cc.addGroupMember(stub, args)
+    case "removeGroupMember":
+        return  cc.removeGroupMember(stub, args)
+    case "getGroupMembers":
+        return  cc.getGroupMembers(stub, args)
+    case "getGroupsOfUser":
+        return  cc.getGroupsOfUser(stub, args)
+    case "setGroupRestraint":
+        return  cc.setGroupRestraint(stub, args)
+    case "setUserGroupRestraint":
+        return  cc.setUserGroupRestraint(stub, args)
+    case "getRestraintsOfGroup":
+        return  cc.getRestraintsOfGroup(stub, args)
+    case "freezeAccount":
+        return  cc.freezeAccount(stub, args)
+    case "unfreezeAccount":
+        return  cc.unfreezeAccount(stub, args)
+    case "isAccountFrozen":
+        return  cc.isAccountFrozen(stub, args)
+    case "setRoutingOptIn":
+        return  cc.setRoutingOptIn(stub, args)
+    case "findTransferPath":
+        return  cc.findTransferPath(stub, args)
+    case "routedTransfer":
+        return  cc.routedTransfer(stub, args)
+    case "exportRestraintGraph":
+        return  cc.exportRestraintGraph(stub, args)
+    case "getCallerIdentity":
+        return  cc.getCallerIdentity(stub, args)
+    case "grantRole":
+        return  cc.grantRole(stub, args)
+    case "revokeRole":
+        return  cc.revokeRole(stub, args)
+    case "getRoleMembers":
+        return  cc.getRoleMembers(stub, args)
+    case "setUserOwner":
+        return  cc.setUserOwner(stub, args)
+    case "getUserOwner":
+        return  cc.getUserOwner(stub, args)
+    case "addScreeningEntry":
+        return  cc.addScreeningEntry(stub, args)
+    case "removeScreeningEntry":
+        return  cc.removeScreeningEntry(stub, args)
+    case "getScreeningList":
+        return  cc.getScreeningList(stub, args)
+    case "setScreeningRules":
+        return  cc.setScreeningRules(stub, args)
+    case "getScreeningRules":
+        return  cc.getScreeningRules(stub, args)
+    case "getScreeningAudit":
+        return  cc.getScreeningAudit(stub, args)
+    case "setVelocityLimits":
+        return  cc.setVelocityLimits(stub, args)
+    case "getVelocityUsage":
+        return  cc.getVelocityUsage(stub, args)
+    case "setCreditLimit":
+        return  cc.setCreditLimit(stub, args)
+    case "createInterestProduct":
+        return  cc.createInterestProduct(stub, args)
+    case "getInterestProduct":
+        return  cc.getInterestProduct(stub, args)
+    case "assignInterestProduct":
+        return  cc.assignInterestProduct(stub, args)
+    case "getInterestAccrual":
+        return  cc.getInterestAccrual(stub, args)
+    case "accrueInterest":
+        return  cc.accrueInterest(stub, args)
+    case "grantVesting":
+        return  cc.grantVesting(stub, args)
+    case "getVestingTranches":
+        return  cc.getVestingTranches(stub, args)
+    case "lockTransfer":
+        return  cc.lockTransfer(stub, args)
+    case "claim":
+        return  cc.claim(stub, args)
+    case "refund":
+        return  cc.refund(stub, args)
+    case "getHashedTimelock":
+        return  cc.getHashedTimelock(stub, args)
+    case "getTransfer":
+        return  cc.getTransfer(stub, args)
+    case "requestReversal":
+        return  cc.requestReversal(stub, args)
+    case "approveReversal":
+        return  cc.approveReversal(stub, args)
+    case "rejectReversal":
+        return  cc.rejectReversal(stub, args)
+    case "getReversalRequest":
+        return  cc.getReversalRequest(stub, args)
+    case "confirmWithdrawal":
+        return  cc.confirmWithdrawal(stub, args)
+    case "failWithdrawal":
+        return  cc.failWithdrawal(stub, args)
+    case "getWithdrawal":
+        return  cc.getWithdrawal(stub, args)
+    case "getPendingWithdrawals":
+        return  cc.getPendingWithdrawals(stub, args)
+    case "setDepositRules":
+        return  cc.setDepositRules(stub, args)
+    case "getDepositRules":
+        return  cc.getDepositRules(stub, args)
+    case "approveDeposit":
+        return  cc.approveDeposit(stub, args)
+    case "rejectDeposit":
+        return  cc.rejectDeposit(stub, args)
+    case "getDeposit":
+        return  cc.getDeposit(stub, args)
+    case "getDepositReport":
+        return  cc.getDepositReport(stub, args)
+    case "getTotalSupply":
+        return  cc.getTotalSupply(stub, args)
+    case "auditInvariants":
+        return  cc.auditInvariants(stub, args)
+    case "setIssuanceConfig":
+        return  cc.setIssuanceConfig(stub, args)
+    case "getIssuanceConfig":
+        return  cc.getIssuanceConfig(stub, args)
+    case "attestReserve":
+        return  cc.attestReserve(stub, args)
+    case "getReserve":
+        return  cc.getReserve(stub, args)
+    case "rechargeAsset":
+        return  cc.rechargeAsset(stub, args)
+    case "getAssetBalance":
+        return  cc.getAssetBalance(stub, args)
+    case "setExchangeRate":
+        return  cc.setExchangeRate(stub, args)
+    case "getExchangeRate":
+        return  cc.getExchangeRate(stub, args)
+    case "transferWithConversion":
+        return  cc.transferWithConversion(stub, args)
+    case "setAssetPolicy":
+        return  cc.setAssetPolicy(stub, args)
+    case "getAssetPolicy":
+        return  cc.getAssetPolicy(stub, args)
+    case "updateUserInfo":
+        return  cc.updateUserInfo(stub, args)
+    case "getUserInfoHistory":
+        return  cc.getUserInfoHistory(stub, args)
+    case "setUserInfoSchema":
+        return  cc.setUserInfoSchema(stub, args)
+    case "getUserInfoSchema":
+        return  cc.getUserInfoSchema(stub, args)
+    case "setKycLevel":
+        return  cc.setKycLevel(stub, args)
+    case "setKycPolicy":
+        return  cc.setKycPolicy(stub, args)
+    case "getKycPolicies":
+        return  cc.getKycPolicies(stub, args)
+    case "openSubAccount":
+        return  cc.openSubAccount(stub, args)
+    case "getSubAccounts":
+        return  cc.getSubAccounts(stub, args)
+    case "moveBetweenSubAccounts":
+        return  cc.moveBetweenSubAccounts(stub, args)
+    case "createOrganization":
+        return  cc.createOrganization(stub, args)
+    case "getOrganizationInfo":
+        return  cc.getOrganizationInfo(stub, args)
+    case "setOrganizationAdmin":
+        return  cc.setOrganizationAdmin(stub, args)
+    case "addOrganizationMember":
+        return  cc.addOrganizationMember(stub, args)
+    case "removeOrganizationMember":
+        return  cc.removeOrganizationMember(stub, args)
+    case "getOrganizationUsers":
+        return  cc.getOrganizationUsers(stub, args)
+    case "getOrganizationRestraints":
+        return  cc.getOrganizationRestraints(stub, args)
+    case "getPendingRestraints":
+        return  cc.getPendingRestraints(stub, args)
+    case "setRestraintTemplate":
+        return  cc.setRestraintTemplate(stub, args)
+    case "removeRestraintTemplate":
+        return  cc.removeRestraintTemplate(stub, args)
+    case "getRestraintTemplates":
+        return  cc.getRestraintTemplates(stub, args)
+    case "applyRestraintTemplate":
+        return  cc.applyRestraintTemplate(stub, args)
+    case "getRestraintAudit":
+        return  cc.getRestraintAudit(stub, args)
+    case "getRestraintAuditOfUser":
+        return  cc.getRestraintAuditOfUser(stub, args)
+    case "getPayeesOfUser":
+        return  cc.getPayeesOfUser(stub, args)
+    case "getPayersOfUser":
+        return  cc.getPayersOfUser(stub, args)
+    case "clearRestraint":
+        return  cc.clearRestraint(stub, args)
+    case "createScheduledTransfer":
+        return  cc.createScheduledTransfer(stub, args)
+    case "cancelScheduledTransfer":
+        return  cc.cancelScheduledTransfer(stub, args)
+    case "executeDue":
+        return  cc.executeDue(stub, args)
+    case "getScheduledTransfer":
+        return  cc.getScheduledTransfer(stub, args)
+    case "getScheduledTransfersOfUser":
+        return  cc.getScheduledTransfersOfUser(stub, args)
+    case "getScheduledTransferFailures":
+        return  cc.getScheduledTransferFailures(stub, args)
     default:
         return shim.Error("Error: unkown chaincode function " + function)
     }
 }
 
 // 注册用户
+// 用户名和 extras 的规则见 validation.go，配置了 extras 的格式时见 profile.go
+// 调用者的身份成为用户的所有者，见 roles.go
 // 返回值：nil
 func (cc *RestrainedTransferCC) register(stub shim.ChaincodeStubInterface, args []string) pb.Response {
     if len(args) != 2 {
@@ -79,11 +313,15 @@ func (cc *RestrainedTransferCC) register(stub shim.ChaincodeStubInterface, args
     username := strings.TrimSpace(args[0])
     extras := args[1]
 
-    if len(username) == 0 {
-        return shim.Error("username should not be empty.")
+    var err error
+
+    if err = validateUsername("username", username); err != nil {
+        return shim.Error(err.Error())
     }
 
-    var err error
+    if err = checkExtras(stub, extras); err != nil {
+        return shim.Error(err.Error())
+    }
 
     user_info_key, err := stub.CreateCompositeKey("u_i:", []string{username})
     if err != nil {
@@ -129,6 +367,16 @@ func (cc *RestrainedTransferCC) register(stub shim.ChaincodeStubInterface, args
         return shim.Error("Failed to put state. " + err.Error())
     }
 
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    err = putUserOwner(stub, username, caller)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
     return shim.Success(nil)
 }
 
@@ -164,10 +412,23 @@ func (cc *RestrainedTransferCC) getUserInfo(stub shim.ChaincodeStubInterface, ar
 }
 
 // 查询余额
-// 返回值: 十进制数 字符串，如 123.456
+// 返回值: 十进制数 字符串，如 123.456；有透支额度的账户余额可能为负数
+// 第二个参数为 detail 时返回json字符串
+// {
+//     "balance":"-100",
+//     "credit_limit":"1000",
+//     "credit_used":"100",
+//     "credit_available":"900",
+//     "overdrawn_since":1537776000,
+//     "overdrawn_seconds":3600,
+//     "locked":"0",
+//     "spendable":"900"
+// }
+// overdrawn_since 为本次透支开始的时间，没有透支时为 0；overdrawn_seconds 为累计透支的时长，见 credit.go
+// locked 为交易时间仍然锁定的金额，spendable 为可以转出或提款的金额，见 vesting.go
 func (cc *RestrainedTransferCC) getBalance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-    if len(args) != 1 {
-        return shim.Error(`parameter error. usage: "{fcn: 'getBalance', args: ['username']}"`)
+    if len(args) != 1 && !(len(args) == 2 && strings.TrimSpace(args[1]) == "detail") {
+        return shim.Error(`parameter error. usage: "{fcn: 'getBalance', args: ['username']}" or "{fcn: 'getBalance', args: ['username', 'detail']}"`)
     }
 
     username := strings.TrimSpace(args[0])
@@ -187,19 +448,49 @@ func (cc *RestrainedTransferCC) getBalance(stub shim.ChaincodeStubInterface, arg
         return shim.Error("username " + username + " is not registered.")
     }
 
-    return shim.Success(balanceAsBytes)
+    if len(args) == 1 {
+        return shim.Success(balanceAsBytes)
+    }
+
+    balance, err := decimal.NewFromString(string(balanceAsBytes))
+    if err != nil {
+        return shim.Error("Failed to parse balance stored. " + err.Error())
+    }
+
+    detail, err := getBalanceDetail(stub, username, balance)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    detailAsBytes, err := json.Marshal(detail)
+    if err != nil {
+        return shim.Error("Failed to format balance detail. " + err.Error())
+    }
+
+    return shim.Success(detailAsBytes)
 }
 
 // 充值
-// 返回值: nil
+// reference 和 channel 为外部流水号和渠道，可以省略；同一渠道的流水号不能重复使用
+// 每笔充值都有充值记录，充值 id 为交易 id；开启双人复核时充值为 pending 状态，确认后才计入余额，见 deposit.go
+// 配置了发行规则时只有 operator 可以充值，并且受发行上限和储备的限制，见 issuance.go
+// 充值金额还需要满足 KYC 等级的限制，见 kyc.go
+// 返回值: nil，被制裁名单拦截时返回值见 screening.go
 func (cc *RestrainedTransferCC) recharge(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-    if len(args) != 2 {
-        return shim.Error(`parameter error. usage: "{fcn: 'recharge', args: ['username', 'amount']}"`)
+    if len(args) != 2 && len(args) != 4 {
+        return shim.Error(`parameter error. usage: "{fcn: 'recharge', args: ['username', 'amount']}" or "{fcn: 'recharge', args: ['username', 'amount', 'reference', 'channel']}"`)
     }
 
     username := strings.TrimSpace(args[0])
     amount_str := strings.TrimSpace(args[1])
 
+    reference := ""
+    channel := ""
+    if len(args) == 4 {
+        reference = strings.TrimSpace(args[2])
+        channel = strings.TrimSpace(args[3])
+    }
+
     var err error
 
     user_balance_key, err := stub.CreateCompositeKey("u_b:", []string{username})
@@ -219,27 +510,65 @@ func (cc *RestrainedTransferCC) recharge(stub shim.ChaincodeStubInterface, args
         return shim.Error("Failed to parse balance stored. " + err.Error())
     }
 
-    amount, err := decimal.NewFromString(amount_str)
+    amount, err := validateAmount(stub, DEFAULT_ASSET, "amount", amount_str)
     if err != nil {
-        return shim.Error("Invalid recharge amount, expecting a number.")
+        return shim.Error(err.Error())
     }
-    if amount.LessThanOrEqual(decimal.Zero) {
-        return shim.Error("Invalid recharge amount, expecting a number greater than 0.")
+
+    rules, err := checkDeposit(stub, reference, channel)
+    if err != nil {
+        return shim.Error(err.Error())
     }
 
-    var new_balance = balance.Add(amount)
+    // 需要复核的充值在确认时检查发行限制
+    var issuance *IssuanceUpdate
+    if !rules.DualControl {
+        issuance, err = checkIssuance(stub, amount)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+    }
 
-    err = stub.PutState(user_balance_key, []byte(new_balance.String()))
+    kyc, err := checkKyc(stub, amount, KycCheck{username, KYC_RECHARGE})
     if err != nil {
-        return shim.Error("Failed to put state. " + err.Error())
+        return shim.Error(err.Error())
+    }
+
+    if err = screen(stub, "recharge", amount.String(), username); err != nil {
+        return screeningErrorResponse(err)
+    }
+
+    status := DEPOSIT_CREDITED
+    if rules.DualControl {
+        status = DEPOSIT_PENDING
+    }
+
+    _, err = createDeposit(stub, username, amount, reference, channel, status)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    err = kyc.commit(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if rules.DualControl {
+        return shim.Success(nil)
+    }
+
+    err = mint(stub, username, balance, amount, issuance)
+    if err != nil {
+        return shim.Error(err.Error())
     }
 
     return shim.Success(nil)
 }
 
 // 提款
-// 提款金额需小于等于余额
-// 返回值：nil
+// 提款金额需小于等于余额加透支额度，冻结的账户不能提款，还需要满足风控限额（见 velocity.go）和 KYC 等级的限制（见 kyc.go）
+// 金额从余额中扣除后创建待处理的提款申请，申请 id 为交易 id，由 settlement 角色确认或退回，见 withdrawal.go
+// 返回值：nil，被制裁名单拦截时返回值见 screening.go
 func (cc *RestrainedTransferCC) withdraw(stub shim.ChaincodeStubInterface, args []string) pb.Response {
     if len(args) != 2 {
         return shim.Error(`parameter error. usage: "{fcn: 'withdraw', args: ['username', 'amount']}"`)
@@ -267,23 +596,58 @@ func (cc *RestrainedTransferCC) withdraw(stub shim.ChaincodeStubInterface, args
         return shim.Error("Failed to parse balance stored. " + err.Error())
     }
 
-    amount, err := decimal.NewFromString(amount_str)
+    amount, err := validateAmount(stub, DEFAULT_ASSET, "amount", amount_str)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = screen(stub, "withdraw", amount.String(), username); err != nil {
+        return screeningErrorResponse(err)
+    }
+
+    if err = checkNotFrozen(stub, username); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    velocity, err := checkVelocity(stub, username, "withdraw", amount)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    kyc, err := checkKyc(stub, amount, KycCheck{username, KYC_WITHDRAW})
     if err != nil {
-        return shim.Error("Invalid recharge amount, expecting a number.")
+        return shim.Error(err.Error())
     }
-    if amount.LessThanOrEqual(decimal.Zero) {
-        return shim.Error("Invalid recharge amount, expecting a number greater than 0.")
+
+    spendable, err := getSpendableBalance(stub, username, balance)
+    if err != nil {
+        return shim.Error(err.Error())
     }
 
-    if balance.LessThan(amount) {
+    if spendable.LessThan(amount) {
         return shim.Error("Failed recharge, not enough balance.")
     }
 
     var new_balance = balance.Sub(amount)
 
-    err = stub.PutState(user_balance_key, []byte(new_balance.String()))
+    err = setBalance(stub, username, balance, new_balance)
     if err != nil {
-        return shim.Error("Failed to put state. " + err.Error())
+        return shim.Error(err.Error())
+    }
+
+    err = velocity.commit(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    err = kyc.commit(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    _, err = createWithdrawalRequest(stub, username, amount)
+    if err != nil {
+        return shim.Error(err.Error())
     }
 
     return shim.Success(nil)
@@ -292,19 +656,25 @@ func (cc *RestrainedTransferCC) withdraw(stub shim.ChaincodeStubInterface, args
 // 设置两个用户之间的转账约束
 // 必需先通过 setRestraint 设置转账约束，才能调用 transfer 进行转账。即两个用户之间默认的转账约束是 0 即不允许转账。
 // 转账约束有4个枚举值：
-// 0 : a b 之间相互都不可转账
+// 0 : a b 之间相互都不可转账。设置 0 不再删除约束，而是在 u_r: 下写入一条显式的 0，优先于组约束，通过 clearRestraint 删除
 // 1 : a 可以转给 b, 但 b 不可转给 a
 // 2 : b 可以转给 a, 但 a 不可转给 b
 // 3 : a b 之间可以互转
+// 任一方属于组织时，由 admin 或组织管理员设置，跨组织的约束需要双方组织的管理员都设置相同的值才生效，见 organizations.go
+// reason 为修改原因，可以省略，记录在约束的修改记录中，见 restraint_audit.go
 // 返回值：nil
 func (cc *RestrainedTransferCC) setRestraint(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-    if len(args) != 3 {
-        return shim.Error(`parameter error. usage: "{fcn: 'setRestraint', args: ['username_a', 'username_b', 'restraint_type']}"`)
+    if len(args) != 3 && len(args) != 4 {
+        return shim.Error(`parameter error. usage: "{fcn: 'setRestraint', args: ['username_a', 'username_b', 'restraint_type', 'reason'?]}"`)
     }
 
     username_a := strings.TrimSpace(args[0])
     username_b := strings.TrimSpace(args[1])
     restraint_str := strings.TrimSpace(args[2])
+    reason := ""
+    if len(args) == 4 {
+        reason = args[3]
+    }
 
     if username_a == username_b {
         return shim.Error("username_a and username_b must not be equal")
@@ -318,6 +688,10 @@ func (cc *RestrainedTransferCC) setRestraint(stub shim.ChaincodeStubInterface, a
 
     restraint := RestraintType(restraint_str[0])
 
+    if err = validateRestraintReason(reason); err != nil {
+        return shim.Error(err.Error())
+    }
+
     a_info_key, err := stub.CreateCompositeKey("u_i:", []string{username_a})
     if err != nil {
         return shim.Error("username_a is not valid. " + err.Error())
@@ -344,36 +718,159 @@ func (cc *RestrainedTransferCC) setRestraint(stub shim.ChaincodeStubInterface, a
         return shim.Error("username_b " + username_b + " is not registered.")
     }
 
+    apply, err := checkRestraintAuthority(stub, username_a, username_b, restraint)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if !apply {
+        return shim.Success(nil)
+    }
+
+    err = putRestraint(stub, username_a, username_b, restraint, reason)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询 username_a 到 username_b 的转账约束，没有则返回 NONWAY
+func getRestraint(stub shim.ChaincodeStubInterface, username_a, username_b string) (RestraintType, error) {
+    restraint, _, err := getExplicitRestraint(stub, username_a, username_b)
+    return restraint, err
+}
+
+// 查询 username_a 到 username_b 的 u_r: 约束，第二个返回值表示是否设置过（包括显式的 0）
+func getExplicitRestraint(stub shim.ChaincodeStubInterface, username_a, username_b string) (RestraintType, bool, error) {
     ab_restraint_key, err := stub.CreateCompositeKey("u_r:", []string{username_a, username_b})
     if err != nil {
-        return shim.Error("username_a or username_b is not valid. " + err.Error())
+        return NONWAY, false, fmt.Errorf("username_a or username_b is not valid. %s", err.Error())
+    }
+
+    abRestraintAsBytes, err := stub.GetState(ab_restraint_key)
+    if err != nil {
+        return NONWAY, false, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if abRestraintAsBytes == nil {
+        return NONWAY, false, nil
+    }
+
+    return RestraintType(abRestraintAsBytes[0]), true, nil
+}
+
+// 写入 username_a 和 username_b 之间的转账约束，NONWAY 作为显式的禁止写入；约束有变化时记录修改记录，见 restraint_audit.go
+func putRestraint(stub shim.ChaincodeStubInterface, username_a, username_b string, restraint RestraintType, reason string) error {
+    old, explicit, err := getExplicitRestraint(stub, username_a, username_b)
+    if err != nil {
+        return err
+    }
+    if explicit && old == restraint {
+        return nil
+    }
+
+    old_str := ""
+    if explicit {
+        old_str = string(old)
+    }
+
+    if err = recordRestraintChange(stub, username_a, username_b, old_str, string(restraint), reason); err != nil {
+        return err
+    }
+
+    ab_restraint_key, err := stub.CreateCompositeKey("u_r:", []string{username_a, username_b})
+    if err != nil {
+        return fmt.Errorf("username_a or username_b is not valid. %s", err.Error())
     }
 
     ba_restraint_key, err := stub.CreateCompositeKey("u_r:", []string{username_b, username_a})
     if err != nil {
-        return shim.Error("username_a or username_b is not valid. " + err.Error())
+        return fmt.Errorf("username_a or username_b is not valid. %s", err.Error())
     }
 
-    if restraint == NONWAY {
-        err = stub.DelState(ab_restraint_key)
-        if err != nil {
-            return shim.Error("Failed to del state. " + err.Error())
-        }
+    if err = stub.PutState(ab_restraint_key, []byte{byte(restraint)}); err != nil {
+        return fmt.Errorf("Failed to put state. %s", err.Error())
+    }
+    if err = stub.PutState(ba_restraint_key, []byte{byte(restraint.reverse())}); err != nil {
+        return fmt.Errorf("Failed to put state. %s", err.Error())
+    }
 
-        err = stub.DelState(ba_restraint_key)
-        if err != nil {
-            return shim.Error("Failed to del state. " + err.Error())
-        }
-    } else {
-        err = stub.PutState(ab_restraint_key, []byte{byte(restraint)})
-        if err != nil {
-            return shim.Error("Failed to put state. " + err.Error())
-        }
+    return nil
+}
 
-        err = stub.PutState(ba_restraint_key, []byte{byte(restraint.reverse())})
-        if err != nil {
-            return shim.Error("Failed to put state. " + err.Error())
-        }
+// 删除 username_a 和 username_b 之间的 u_r: 约束，删除后按组约束判断；有变化时记录修改记录
+func delRestraint(stub shim.ChaincodeStubInterface, username_a, username_b string, reason string) error {
+    old, explicit, err := getExplicitRestraint(stub, username_a, username_b)
+    if err != nil {
+        return err
+    }
+    if !explicit {
+        return nil
+    }
+
+    if err = recordRestraintChange(stub, username_a, username_b, string(old), "", reason); err != nil {
+        return err
+    }
+
+    ab_restraint_key, err := stub.CreateCompositeKey("u_r:", []string{username_a, username_b})
+    if err != nil {
+        return fmt.Errorf("username_a or username_b is not valid. %s", err.Error())
+    }
+
+    ba_restraint_key, err := stub.CreateCompositeKey("u_r:", []string{username_b, username_a})
+    if err != nil {
+        return fmt.Errorf("username_a or username_b is not valid. %s", err.Error())
+    }
+
+    if err = stub.DelState(ab_restraint_key); err != nil {
+        return fmt.Errorf("Failed to del state. %s", err.Error())
+    }
+    if err = stub.DelState(ba_restraint_key); err != nil {
+        return fmt.Errorf("Failed to del state. %s", err.Error())
+    }
+
+    return nil
+}
+
+// 删除两个用户之间通过 setRestraint 设置的约束（包括显式的 0），之后按组约束和父账户的约束判断，见 groups.go
+// 权限同 setRestraint，但跨组织的约束只能由 admin 或同时是双方组织管理员的调用者删除
+// 返回值：nil
+func (cc *RestrainedTransferCC) clearRestraint(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 && len(args) != 3 {
+        return shim.Error(`parameter error. usage: "{fcn: 'clearRestraint', args: ['username_a', 'username_b', 'reason'?]}"`)
+    }
+
+    username_a := strings.TrimSpace(args[0])
+    username_b := strings.TrimSpace(args[1])
+    reason := ""
+    if len(args) == 3 {
+        reason = args[2]
+    }
+
+    var err error
+
+    if err = validateRestraintReason(reason); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkRegistered(stub, "username_a", username_a); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkRegistered(stub, "username_b", username_b); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    plan, err := planRestraint(stub, username_a, username_b, NONWAY)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if !plan.Apply || plan.DelPending {
+        return shim.Error("restraint between " + username_a + " and " + username_b + " crosses organizations, only admin can clear it.")
+    }
+
+    err = delRestraint(stub, username_a, username_b, reason)
+    if err != nil {
+        return shim.Error(err.Error())
     }
 
     return shim.Success(nil)
@@ -381,7 +878,8 @@ func (cc *RestrainedTransferCC) setRestraint(stub shim.ChaincodeStubInterface, a
 
 // 查询用户的所有转账约束
 // 两个用户之间如果没有这种转账约束，则默认是 0 即相互都不可转账
-// 枚举值的含义见 setRestraint
+// 通过 setRestraint 显式设置的 0 同样不返回，与没有约束的结果一致
+// 枚举值的含义见 setRestraint；按方向列出可以转账的用户见 getPayeesOfUser 和 getPayersOfUser
 // 返回值：json字符串
 // {
 //     "user_x":"2"
@@ -426,6 +924,10 @@ func (cc *RestrainedTransferCC) getRestraintsOfUser(stub shim.ChaincodeStubInter
         if err != nil {
             return shim.Error("Failed to parse restraint stored. " + err.Error())
         }
+        // 显式的 0 与没有约束一样不返回
+        if RestraintType(kv.Value[0]) == NONWAY {
+            continue
+        }
         userRestraints[compositeKeyParts[1]] = string(kv.Value)
     }
 
@@ -496,8 +998,10 @@ func (cc *RestrainedTransferCC) getRestraintBetweenUsers(stub shim.ChaincodeStub
 }
 
 // 从 username_a 转账给 username_b
-// 如果 getRestraintBetweenUsers(stub, []string{username_a, username_b}) 返回值 不是 1 或 3，则链码返回 ERROR
-// 返回值：nil
+// 如果 getEffectiveRestraint(stub, []string{username_a, username_b}) 返回值 不是 1 或 3，则链码返回 ERROR
+// 没有设置过 u_r: 约束时 getEffectiveRestraint 与 getRestraintBetweenUsers 相同，组约束的优先级见 groups.go
+// 转账 id 为交易 id，可以通过 getTransfer 查询，冲正见 reversal.go
+// 返回值：nil，被制裁名单拦截时返回值见 screening.go
 func (cc *RestrainedTransferCC) transfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
     if len(args) != 3 {
         return shim.Error(`parameter error. usage: "{fcn: 'transfer', args: ['username_a', 'username_b', 'amount']}"`)
@@ -511,87 +1015,171 @@ func (cc *RestrainedTransferCC) transfer(stub shim.ChaincodeStubInterface, args
         return shim.Error("username_a and username_b must not be equal")
     }
 
-    var err error
+    amount, err := validateAmount(stub, DEFAULT_ASSET, "amount", amount_str)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
 
-    a_balance_key, err := stub.CreateCompositeKey("u_b:", []string{username_a})
+    err = doTransfer(stub, username_a, username_b, amount)
     if err != nil {
-        return shim.Error("username_a is not valid. " + err.Error())
+        return screeningErrorResponse(err)
     }
 
-    aAsBytes, err := stub.GetState(a_balance_key)
+    return shim.Success(nil)
+}
+
+// checkTransfer 检查通过后的余额和需要写入的风控计数器
+type TransferCheck struct {
+    BalanceA decimal.Decimal
+    BalanceB decimal.Decimal
+    Velocity *VelocityUpdate
+    Kyc      *KycUpdate
+}
+
+// 检查 username_a 能否转账 amount 给 username_b：制裁名单、转账约束、冻结、风控限额、KYC 等级（见 kyc.go）和余额
+// 被制裁名单拦截时返回 *ScreeningBlocked；除了筛查记录外不修改状态
+func checkTransfer(stub shim.ChaincodeStubInterface, username_a, username_b string, amount decimal.Decimal) (*TransferCheck, error) {
+    balance_a, err := getStoredBalance(stub, "username_a", username_a)
     if err != nil {
-        return shim.Error("Failed to get state. " + err.Error())
+        return nil, err
     }
-    if aAsBytes == nil {
-        return shim.Error("username_a " + username_a + " is not registered.")
+
+    balance_b, err := getStoredBalance(stub, "username_b", username_b)
+    if err != nil {
+        return nil, err
     }
 
-    b_balance_key, err := stub.CreateCompositeKey("u_b:", []string{username_b})
+    if err = checkTransferParties(stub, username_a, username_b, amount); err != nil {
+        return nil, err
+    }
+
+    velocity, err := checkVelocity(stub, username_a, "transfer", amount)
     if err != nil {
-        return shim.Error("username_b is not valid. " + err.Error())
+        return nil, err
     }
 
-    bAsBytes, err := stub.GetState(b_balance_key)
+    kyc, err := checkKyc(stub, amount, KycCheck{username_a, KYC_TRANSFER}, KycCheck{username_b, KYC_RECEIVE})
     if err != nil {
-        return shim.Error("Failed to get state. " + err.Error())
+        return nil, err
     }
-    if bAsBytes == nil {
-        return shim.Error("username_b " + username_b + " is not registered.")
+
+    spendable_a, err := getSpendableBalance(stub, username_a, balance_a)
+    if err != nil {
+        return nil, err
     }
 
-    ab_restraint_key, err := stub.CreateCompositeKey("u_r:", []string{username_a, username_b})
+    if spendable_a.LessThan(amount) {
+        return nil, fmt.Errorf("Failed transfer, not enough balance.")
+    }
+
+    return &TransferCheck{balance_a, balance_b, velocity, kyc}, nil
+}
+
+// 检查转账双方：制裁名单、转账约束和冻结，调用前需要确认双方已注册
+// 被制裁名单拦截时返回 *ScreeningBlocked
+func checkTransferParties(stub shim.ChaincodeStubInterface, username_a, username_b string, amount decimal.Decimal) error {
+    var err error
+
+    if err = screen(stub, "transfer", amount.String(), username_a, username_b); err != nil {
+        return err
+    }
+
+    allowed, err := transferAllowed(stub, username_a, username_b)
     if err != nil {
-        return shim.Error("username_a or username_b is not valid. " + err.Error())
+        return err
     }
 
-    abRestraintAsBytes, err := stub.GetState(ab_restraint_key)
+    if !allowed {
+        return fmt.Errorf("transfer from %s to %s is forbidden.", username_a, username_b)
+    }
+
+    if err = checkNotFrozen(stub, username_a); err != nil {
+        return err
+    }
+
+    return checkNotFrozen(stub, username_b)
+}
+
+// 从 username_a 转账给 username_b，检查见 checkTransfer，转账记录见 journal.go
+// 被制裁名单拦截时返回 *ScreeningBlocked，此时还没有修改余额
+// transfer 以及其他需要转账的函数（如 routedTransfer）都通过它完成转账
+func doTransfer(stub shim.ChaincodeStubInterface, username_a, username_b string, amount decimal.Decimal) error {
+    check, err := checkTransfer(stub, username_a, username_b, amount)
     if err != nil {
-        return shim.Error("Failed to get state. " + err.Error())
+        return err
     }
 
-    restraint := NONWAY
+    return commitTransfer(stub, username_a, username_b, amount, check)
+}
 
-    if abRestraintAsBytes != nil {
-        restraint = RestraintType(abRestraintAsBytes[0])
+// 按 checkTransfer 的结果写入转账，返回的 error 都发生在修改状态之后
+func commitTransfer(stub shim.ChaincodeStubInterface, username_a, username_b string, amount decimal.Decimal, check *TransferCheck) error {
+    err := setBalance(stub, username_a, check.BalanceA, check.BalanceA.Sub(amount))
+    if err != nil {
+        return err
     }
 
-    if !restraint.allow() {
-        return shim.Error(fmt.Sprintf("transfer from %s to %s is forbidden.", username_a, username_b))
+    err = setBalance(stub, username_b, check.BalanceB, check.BalanceB.Add(amount))
+    if err != nil {
+        return err
     }
 
-    balance_a, err := decimal.NewFromString(string(aAsBytes))
+    err = check.Velocity.commit(stub)
     if err != nil {
-        return shim.Error("Failed to parse balance stored. " + err.Error())
+        return err
     }
 
-    balance_b, err := decimal.NewFromString(string(bAsBytes))
+    err = check.Kyc.commit(stub)
     if err != nil {
-        return shim.Error("Failed to parse balance stored. " + err.Error())
+        return err
+    }
+
+    _, err = recordTransfer(stub, username_a, username_b, amount, "")
+
+    return err
+}
+
+// 读取余额
+func getStoredBalance(stub shim.ChaincodeStubInterface, arg_name, username string) (decimal.Decimal, error) {
+    user_balance_key, err := stub.CreateCompositeKey("u_b:", []string{username})
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("%s is not valid. %s", arg_name, err.Error())
     }
 
-    amount, err := decimal.NewFromString(amount_str)
+    balanceAsBytes, err := stub.GetState(user_balance_key)
     if err != nil {
-        return shim.Error("Invalid recharge amount, expecting a number.")
+        return decimal.Zero, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if balanceAsBytes == nil {
+        return decimal.Zero, fmt.Errorf("%s %s is not registered.", arg_name, username)
     }
 
-    if balance_a.LessThan(amount) {
-        return shim.Error("Failed transfer, not enough balance.")
+    balance, err := decimal.NewFromString(string(balanceAsBytes))
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("Failed to parse balance stored. %s", err.Error())
     }
 
-    new_balance_a := balance_a.Sub(amount)
-    new_balance_b := balance_b.Add(amount)
+    return balance, nil
+}
 
-    err = stub.PutState(a_balance_key, []byte(new_balance_a.String()))
+// 修改余额，所有修改 u_b: 的地方都应通过它修改，以便计提利息（见 interest.go）和记录透支（见 credit.go）
+func setBalance(stub shim.ChaincodeStubInterface, username string, old_balance, new_balance decimal.Decimal) error {
+    user_balance_key, err := stub.CreateCompositeKey("u_b:", []string{username})
     if err != nil {
-        return shim.Error("Failed to put state. " + err.Error())
+        return fmt.Errorf("username is not valid. %s", err.Error())
     }
 
-    err = stub.PutState(b_balance_key, []byte(new_balance_b.String()))
+    err = accrueBeforeBalanceChange(stub, username, old_balance)
     if err != nil {
-        return shim.Error("Failed to put state. " + err.Error())
+        return err
     }
 
-    return shim.Success(nil)
+    err = stub.PutState(user_balance_key, []byte(new_balance.String()))
+    if err != nil {
+        return fmt.Errorf("Failed to put state. %s", err.Error())
+    }
+
+    return trackOverdraft(stub, username, old_balance, new_balance)
 }
 
 func main() {
@@ -2,9 +2,92 @@ package main
 
 import (
     "testing"
+    "github.com/golang/protobuf/ptypes/timestamp"
     "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
 )
 
+// MockStub 的调用者身份总是 nil，时间戳总是当前时间
+// testStub 用于以指定的身份、在指定的时间调用链码
+type testStub struct {
+    *shim.MockStub
+    args        [][]byte
+    creator     []byte
+    txTimestamp *timestamp.Timestamp
+}
+
+func (s *testStub) GetArgs() [][]byte {
+    return s.args
+}
+
+func (s *testStub) GetStringArgs() []string {
+    strargs := []string{}
+    for _, arg := range s.args {
+        strargs = append(strargs, string(arg))
+    }
+    return strargs
+}
+
+func (s *testStub) GetFunctionAndParameters() (string, []string) {
+    strargs := s.GetStringArgs()
+    return strargs[0], strargs[1:]
+}
+
+func (s *testStub) GetCreator() ([]byte, error) {
+    return s.creator, nil
+}
+
+func (s *testStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+    if s.txTimestamp == nil {
+        return s.MockStub.GetTxTimestamp()
+    }
+    return s.txTimestamp, nil
+}
+
+// 以 creator 的身份在 unix 时间 tx_time 调用 fcn，tx_time 为 0 时使用当前时间
+func mockInvokeWith(stub *shim.MockStub, creator string, tx_time int64, fcn string, args ...string) pb.Response {
+    s := &testStub{MockStub: stub, args: invokeArgs(fcn, args)}
+    if creator != "" {
+        s.creator = []byte(creator)
+    }
+    if tx_time != 0 {
+        s.txTimestamp = &timestamp.Timestamp{Seconds: tx_time}
+    }
+    stub.MockTransactionStart("1")
+    defer stub.MockTransactionEnd("1")
+    return new(RestrainedTransferCC).Invoke(s)
+}
+
+// 以 creator 的身份调用 fcn 并检查返回值，expected 为 "" 时要求返回 nil
+func testInvokeAs(t *testing.T, stub *shim.MockStub, creator string, expected string, fcn string, args ...string) {
+    testInvokeAt(t, stub, creator, 0, expected, fcn, args...)
+}
+
+func testInvokeAsFail(t *testing.T, stub *shim.MockStub, creator string, fcn string, args ...string) {
+    testInvokeAtFail(t, stub, creator, 0, fcn, args...)
+}
+
+// 以 creator 的身份在 unix 时间 tx_time 调用 fcn 并检查返回值
+func testInvokeAt(t *testing.T, stub *shim.MockStub, creator string, tx_time int64, expected string, fcn string, args ...string) {
+    ret := mockInvokeWith(stub, creator, tx_time, fcn, args...)
+    if ret.Status != shim.OK {
+        t.Fatalf("Invoke %s %v as %s failed. %s", fcn, args, creator, ret.Message)
+    }
+    if expected == "" && ret.Payload != nil {
+        t.Fatalf("Invoke %s %v as %s return %s, expected nil", fcn, args, creator, string(ret.Payload))
+    }
+    if expected != "" && string(ret.Payload) != expected {
+        t.Fatalf("Invoke %s %v as %s return %s, expected %s", fcn, args, creator, string(ret.Payload), expected)
+    }
+}
+
+func testInvokeAtFail(t *testing.T, stub *shim.MockStub, creator string, tx_time int64, fcn string, args ...string) {
+    ret := mockInvokeWith(stub, creator, tx_time, fcn, args...)
+    if ret.Status != shim.ERROR {
+        t.Fatalf("Invoke %s %v as %s should failed.", fcn, args, creator)
+    }
+}
+
 func testInit(t *testing.T, stub *shim.MockStub) {
     ret := stub.MockInit("1", nil)
     if ret.Status != shim.OK {
@@ -15,6 +98,35 @@ func testInit(t *testing.T, stub *shim.MockStub) {
     }
 }
 
+func invokeArgs(fcn string, args []string) [][]byte {
+    bytesArgs := [][]byte{[]byte(fcn)}
+    for _, arg := range args {
+        bytesArgs = append(bytesArgs, []byte(arg))
+    }
+    return bytesArgs
+}
+
+// 调用 fcn 并检查返回值，expected 为 "" 时要求返回 nil
+func testInvoke(t *testing.T, stub *shim.MockStub, expected string, fcn string, args ...string) {
+    ret := stub.MockInvoke("1", invokeArgs(fcn, args))
+    if ret.Status != shim.OK {
+        t.Fatalf("Invoke %s %v failed. %s", fcn, args, ret.Message)
+    }
+    if expected == "" && ret.Payload != nil {
+        t.Fatalf("Invoke %s %v return %s, expected nil", fcn, args, string(ret.Payload))
+    }
+    if expected != "" && string(ret.Payload) != expected {
+        t.Fatalf("Invoke %s %v return %s, expected %s", fcn, args, string(ret.Payload), expected)
+    }
+}
+
+func testInvokeFail(t *testing.T, stub *shim.MockStub, fcn string, args ...string) {
+    ret := stub.MockInvoke("1", invokeArgs(fcn, args))
+    if ret.Status != shim.ERROR {
+        t.Fatalf("Invoke %s %v should failed.", fcn, args)
+    }
+}
+
 func testRegister(t *testing.T, stub *shim.MockStub, username, extras string) {
     ret := stub.MockInvoke("1", [][]byte{[]byte("register"), []byte(username), []byte(extras)})
     if ret.Status != shim.OK {
@@ -236,7 +348,7 @@ func TestInvoke(t *testing.T) {
     testGetRestraintBetweenUsers(t, stub, "user_a", "user_c", "0")
     testGetRestraintBetweenUsers(t, stub, "user_c", "user_a", "0")
 
-    testTransfer(t, stub, "user_a", "user_b", "0")
+    testTransferFail(t, stub, "user_a", "user_b", "0")
 
     testRecharge(t, stub, "user_a", "10000")
     testGetBalance(t, stub, "user_a", "10000")
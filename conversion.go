@@ -0,0 +1,406 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+
+    "github.com/shopspring/decimal"
+)
+
+// 兑换转账
+// oracle 角色通过 setExchangeRate 发布汇率，存储在 fx: 下，key 为 [基础资产, 报价资产]，rate 为 1 个基础资产可以兑换的报价资产数量。
+// 每条汇率带有发布时间和有效期 max_age（秒），交易时间晚于 posted_at + max_age 时汇率过期，不能用于兑换。
+// transferWithConversion 从 username_a 扣除 from_asset，按 [from_asset, to_asset] 的汇率给 username_b 计入 to_asset，
+// 计入金额 = amount × rate，按 CONVERSION_PRECISION 和 to_asset 的 max_scale（见 precision.go）中较小的位数向下取整（零头留在账本中，不会多付），
+// 取整后为 0 时拒绝兑换。扣除的金额按 from_asset 的金额规则检查。
+// 只使用 [from_asset, to_asset] 方向的汇率，不会用反方向的汇率换算。
+// 兑换按 transfer 的规则检查转账约束、冻结和制裁名单；风控限额（见 velocity.go）和透支额度只在 from_asset 为默认资产时适用，
+// KYC 等级的限制（见 kyc.go）只检查默认资产一方：from_asset 为默认资产时检查 username_a 的转出，to_asset 为默认资产时检查 username_b 的转入。
+// 扣除的 from_asset 从总发行量中减去，计入的 to_asset 加到总发行量中（见 supply.go），兑换记录存储在 cv: 下，key 为兑换 id（规则同转账 id）。
+// to_asset 为默认资产时计入的金额视为发行：配置了发行规则时同样受发行上限和储备的限制，并记录从发行账户到 username_b 的转账记录（见 issuance.go）。
+
+// 兑换后金额保留的小数位数
+const CONVERSION_PRECISION = 8
+
+type ExchangeRate struct {
+    Base     string `json:"base"`
+    Quote    string `json:"quote"`
+    Rate     string `json:"rate"`
+    MaxAge   int64  `json:"max_age"`
+    PostedBy string `json:"posted_by"`
+    PostedAt int64  `json:"posted_at"`
+}
+
+type ConversionRecord struct {
+    Id           string `json:"id"`
+    From         string `json:"from"`
+    To           string `json:"to"`
+    FromAsset    string `json:"from_asset"`
+    ToAsset      string `json:"to_asset"`
+    Amount       string `json:"amount"`
+    Rate         string `json:"rate"`
+    RatePostedAt int64  `json:"rate_posted_at"`
+    Credited     string `json:"credited"`
+    Timestamp    int64  `json:"timestamp"`
+}
+
+func getExchangeRate(stub shim.ChaincodeStubInterface, base, quote string) (*ExchangeRate, error) {
+    rate_key, err := stub.CreateCompositeKey("fx:", []string{base, quote})
+    if err != nil {
+        return nil, fmt.Errorf("asset is not valid. %s", err.Error())
+    }
+
+    rateAsBytes, err := stub.GetState(rate_key)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if rateAsBytes == nil {
+        return nil, nil
+    }
+
+    var rate ExchangeRate
+    err = json.Unmarshal(rateAsBytes, &rate)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to parse exchange rate stored. %s", err.Error())
+    }
+
+    return &rate, nil
+}
+
+// 读取 [base, quote] 在交易时间有效的汇率，没有发布或已经过期时返回错误
+func getCurrentExchangeRate(stub shim.ChaincodeStubInterface, base, quote string) (*ExchangeRate, decimal.Decimal, error) {
+    rate, err := getExchangeRate(stub, base, quote)
+    if err != nil {
+        return nil, decimal.Zero, err
+    }
+    if rate == nil {
+        return nil, decimal.Zero, fmt.Errorf("exchange rate from %s to %s is not available.", base, quote)
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return nil, decimal.Zero, err
+    }
+    if now.Unix() > rate.PostedAt + rate.MaxAge {
+        return nil, decimal.Zero, fmt.Errorf("exchange rate from %s to %s posted at %d is stale.", base, quote, rate.PostedAt)
+    }
+
+    value, err := decimal.NewFromString(rate.Rate)
+    if err != nil {
+        return nil, decimal.Zero, fmt.Errorf("Failed to parse exchange rate stored. %s", err.Error())
+    }
+
+    return rate, value, nil
+}
+
+// 发布汇率，只有 oracle 角色可以调用，新的汇率替换旧的汇率
+// rate 为 1 个 base 可以兑换的 quote 数量，max_age 为汇率的有效期（秒）
+// 返回值：nil
+func (cc *RestrainedTransferCC) setExchangeRate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 4 {
+        return shim.Error(`parameter error. usage: "{fcn: 'setExchangeRate', args: ['base', 'quote', 'rate', 'max_age']}"`)
+    }
+
+    base := strings.TrimSpace(args[0])
+    quote := strings.TrimSpace(args[1])
+    rate_str := strings.TrimSpace(args[2])
+    max_age_str := strings.TrimSpace(args[3])
+
+    if len(base) == 0 || len(quote) == 0 {
+        return shim.Error("base and quote should not be empty.")
+    }
+    if base == quote {
+        return shim.Error("base and quote must not be equal")
+    }
+
+    var err error
+
+    rate, err := decimal.NewFromString(rate_str)
+    if err != nil {
+        return shim.Error("Invalid exchange rate, expecting a number.")
+    }
+    if rate.LessThanOrEqual(decimal.Zero) {
+        return shim.Error("Invalid exchange rate, expecting a number greater than 0.")
+    }
+
+    max_age, err := strconv.ParseInt(max_age_str, 10, 64)
+    if err != nil || max_age <= 0 {
+        return shim.Error("max_age got " + max_age_str + ", expected an integer greater than 0")
+    }
+
+    if err = checkCallerRole(stub, ROLE_ORACLE); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    rate_key, err := stub.CreateCompositeKey("fx:", []string{base, quote})
+    if err != nil {
+        return shim.Error("asset is not valid. " + err.Error())
+    }
+
+    rateAsBytes, err := json.Marshal(ExchangeRate{
+        Base: base,
+        Quote: quote,
+        Rate: rate.String(),
+        MaxAge: max_age,
+        PostedBy: caller,
+        PostedAt: now.Unix(),
+    })
+    if err != nil {
+        return shim.Error("Failed to format exchange rate. " + err.Error())
+    }
+
+    err = stub.PutState(rate_key, rateAsBytes)
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询汇率，stale 表示在交易时间已经过期
+// 返回值：json字符串
+// {"base":"usd","quote":"default","rate":"6.9","max_age":3600,"posted_by":"...","posted_at":1537776000,"stale":false}
+func (cc *RestrainedTransferCC) getExchangeRate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getExchangeRate', args: ['base', 'quote']}"`)
+    }
+
+    base := strings.TrimSpace(args[0])
+    quote := strings.TrimSpace(args[1])
+
+    rate, err := getExchangeRate(stub, base, quote)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if rate == nil {
+        return shim.Error("exchange rate from " + base + " to " + quote + " does not exist.")
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    rateAsBytes, err := json.Marshal(MAP{
+        "base": rate.Base,
+        "quote": rate.Quote,
+        "rate": rate.Rate,
+        "max_age": rate.MaxAge,
+        "posted_by": rate.PostedBy,
+        "posted_at": rate.PostedAt,
+        "stale": now.Unix() > rate.PostedAt + rate.MaxAge,
+    })
+    if err != nil {
+        return shim.Error("Failed to format exchange rate. " + err.Error())
+    }
+
+    return shim.Success(rateAsBytes)
+}
+
+// 从 username_a 扣除 amount 个 from_asset，按当前汇率兑换成 to_asset 计入 username_b，调用者必须是 username_a 的所有者
+// username_a 和 username_b 可以相同（兑换自己的资产）
+// 返回值：json字符串，被制裁名单拦截时返回值见 screening.go
+// {
+//     "id":"txid","from":"user_a","to":"user_b","from_asset":"usd","to_asset":"default","amount":"10",
+//     "rate":"6.9","rate_posted_at":1537776000,"credited":"69","timestamp":1537776060
+// }
+func (cc *RestrainedTransferCC) transferWithConversion(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 5 {
+        return shim.Error(`parameter error. usage: "{fcn: 'transferWithConversion', args: ['username_a', 'username_b', 'from_asset', 'to_asset', 'amount']}"`)
+    }
+
+    username_a := strings.TrimSpace(args[0])
+    username_b := strings.TrimSpace(args[1])
+    from_asset := strings.TrimSpace(args[2])
+    to_asset := strings.TrimSpace(args[3])
+    amount_str := strings.TrimSpace(args[4])
+
+    if from_asset == to_asset {
+        return shim.Error("from_asset and to_asset must not be equal")
+    }
+
+    var err error
+
+    amount, err := validateAmount(stub, from_asset, "amount", amount_str)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    balance_a, err := getAssetBalance(stub, "username_a", username_a, from_asset)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    // from_asset 和 to_asset 不同，username_a 和 username_b 相同时两个余额也不会重叠
+    balance_b, err := getAssetBalance(stub, "username_b", username_b, to_asset)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkCallerOwns(stub, username_a); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    rate, rate_value, err := getCurrentExchangeRate(stub, from_asset, to_asset)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    to_policy, err := getAssetPolicy(stub, to_asset)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    scale := int32(CONVERSION_PRECISION)
+    if to_policy.MaxScale < scale {
+        scale = to_policy.MaxScale
+    }
+
+    credited := amount.Mul(rate_value).Truncate(scale)
+    if credited.IsZero() {
+        return shim.Error("Failed transfer, converted amount rounds to 0.")
+    }
+
+    if username_a != username_b {
+        if err = checkTransferParties(stub, username_a, username_b, amount); err != nil {
+            return screeningErrorResponse(err)
+        }
+    } else if err = checkNotFrozen(stub, username_a); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    spendable_a := balance_a
+    var velocity *VelocityUpdate
+    var kyc_a, kyc_b *KycUpdate
+    if from_asset == DEFAULT_ASSET {
+        velocity, err = checkVelocity(stub, username_a, "transfer", amount)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+
+        kyc_a, err = checkKyc(stub, amount, KycCheck{username_a, KYC_TRANSFER})
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+
+        spendable_a, err = getSpendableBalance(stub, username_a, balance_a)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+    }
+
+    // 兑换得到的默认资产视为发行，和 recharge 一样检查发行上限和储备（见 issuance.go）
+    var issuance *IssuanceUpdate
+    if to_asset == DEFAULT_ASSET {
+        kyc_b, err = checkKyc(stub, credited, KycCheck{username_b, KYC_RECEIVE})
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+
+        issuance, err = checkIssuance(stub, credited)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+    }
+
+    if spendable_a.LessThan(amount) {
+        return shim.Error("Failed transfer, not enough balance.")
+    }
+
+    err = setAssetBalance(stub, username_a, from_asset, balance_a, balance_a.Sub(amount))
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    err = setAssetBalance(stub, username_b, to_asset, balance_b, balance_b.Add(credited))
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if velocity != nil {
+        err = velocity.commit(stub)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+    }
+
+    err = kyc_a.commit(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    err = kyc_b.commit(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    err = adjustTotalSupply(stub, from_asset, amount.Neg())
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    err = adjustTotalSupply(stub, to_asset, credited)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    err = issuance.commit(stub, username_b, credited)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    id, err := newTxScopedId(stub, "cv:")
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    record := ConversionRecord{
+        Id: id,
+        From: username_a,
+        To: username_b,
+        FromAsset: from_asset,
+        ToAsset: to_asset,
+        Amount: amount.String(),
+        Rate: rate.Rate,
+        RatePostedAt: rate.PostedAt,
+        Credited: credited.String(),
+        Timestamp: now.Unix(),
+    }
+
+    record_key, err := stub.CreateCompositeKey("cv:", []string{id})
+    if err != nil {
+        return shim.Error("conversion id is not valid. " + err.Error())
+    }
+
+    recordAsBytes, err := json.Marshal(record)
+    if err != nil {
+        return shim.Error("Failed to format conversion record. " + err.Error())
+    }
+
+    err = stub.PutState(record_key, recordAsBytes)
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success(recordAsBytes)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+    "testing"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestTransferWithConversion(t *testing.T) {
+    stub := shim.NewMockStub("TestTransferWithConversion", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "user_a", "")
+    testRegister(t, stub, "user_b", "")
+    testRegister(t, stub, "user_c", "")
+    testSetRestraint(t, stub, "user_a", "user_b", "3")
+
+    // 其他资产的余额
+    testInvokeFail(t, stub, "rechargeAsset", "user_a", "usd", "100")
+    testInvoke(t, stub, "", "grantRole", "operator", mockCallerId)
+    testInvokeFail(t, stub, "rechargeAsset", "user_a", "default", "100")
+    testInvokeFail(t, stub, "rechargeAsset", "user_a", "usd", "0")
+    testInvokeFail(t, stub, "rechargeAsset", "user_x", "usd", "100")
+    testInvoke(t, stub, "", "rechargeAsset", "user_a", "usd", "100")
+    testInvoke(t, stub, "100", "getAssetBalance", "user_a", "usd")
+    testInvoke(t, stub, "0", "getAssetBalance", "user_b", "usd")
+    testInvoke(t, stub, "0", "getAssetBalance", "user_a", "default")
+    testInvokeFail(t, stub, "getAssetBalance", "user_x", "usd")
+    testInvoke(t, stub, "100", "getTotalSupply", "usd")
+
+    day := int64(1537776000)
+
+    // 只有 oracle 可以发布汇率
+    testInvokeAtFail(t, stub, "", day, "setExchangeRate", "usd", "default", "6.9", "3600")
+    testInvoke(t, stub, "", "grantRole", "oracle", mockCallerId)
+    testInvokeAtFail(t, stub, "", day, "setExchangeRate", "usd", "usd", "6.9", "3600")
+    testInvokeAtFail(t, stub, "", day, "setExchangeRate", "usd", "default", "0", "3600")
+    testInvokeAtFail(t, stub, "", day, "setExchangeRate", "usd", "default", "6.9", "0")
+    testInvokeAt(t, stub, "", day, "", "setExchangeRate", "usd", "default", "6.9", "3600")
+    testInvokeAt(t, stub, "", day + 60, `{"base":"usd","max_age":3600,"posted_at":1537776000,"posted_by":"` + mockCallerId + `","quote":"default","rate":"6.9","stale":false}`,
+        "getExchangeRate", "usd", "default")
+    testInvokeFail(t, stub, "getExchangeRate", "default", "usd")
+
+    // 10.123456789 × 6.9 = 69.8518518441，保留 8 位小数向下取整
+    testInvokeAt(t, stub, "", day + 60, `{"id":"1","from":"user_a","to":"user_b","from_asset":"usd","to_asset":"default","amount":"10.123456789",` +
+        `"rate":"6.9","rate_posted_at":1537776000,"credited":"69.85185184","timestamp":1537776060}`,
+        "transferWithConversion", "user_a", "user_b", "usd", "default", "10.123456789")
+    testInvoke(t, stub, "89.876543211", "getAssetBalance", "user_a", "usd")
+    testGetBalance(t, stub, "user_b", "69.85185184")
+    testInvoke(t, stub, "89.876543211", "getTotalSupply", "usd")
+    testInvoke(t, stub, "69.85185184", "getTotalSupply")
+
+    testInvokeAtFail(t, stub, "", day + 60, "transferWithConversion", "user_a", "user_b", "usd", "usd", "10")
+    testInvokeAtFail(t, stub, "", day + 60, "transferWithConversion", "user_a", "user_b", "usd", "default", "0")
+    testInvokeAtFail(t, stub, "", day + 60, "transferWithConversion", "user_a", "user_b", "usd", "default", "100")
+    testInvokeAtFail(t, stub, "", day + 60, "transferWithConversion", "user_a", "user_b", "usd", "default", "0.000000001")
+    testInvokeAtFail(t, stub, "", day + 60, "transferWithConversion", "user_a", "user_b", "usd", "eur", "10")
+    testInvokeAtFail(t, stub, "", day + 60, "transferWithConversion", "user_b", "user_a", "default", "usd", "10")
+    testInvokeAtFail(t, stub, "", day + 60, "transferWithConversion", "user_a", "user_c", "usd", "default", "10")
+    testInvokeAtFail(t, stub, "", day + 60, "transferWithConversion", "user_a", "user_x", "usd", "default", "10")
+    testInvokeAtFail(t, stub, "bob", day + 60, "transferWithConversion", "user_a", "user_b", "usd", "default", "10")
+
+    // 兑换自己的资产
+    testInvokeAt(t, stub, "", day + 120, `{"id":"1.1","from":"user_a","to":"user_a","from_asset":"usd","to_asset":"default","amount":"10",` +
+        `"rate":"6.9","rate_posted_at":1537776000,"credited":"69","timestamp":1537776120}`,
+        "transferWithConversion", "user_a", "user_a", "usd", "default", "10")
+    testGetBalance(t, stub, "user_a", "69")
+    testInvoke(t, stub, "79.876543211", "getAssetBalance", "user_a", "usd")
+
+    // 汇率过期
+    testInvokeAt(t, stub, "", day + 3601, `{"base":"usd","max_age":3600,"posted_at":1537776000,"posted_by":"` + mockCallerId + `","quote":"default","rate":"6.9","stale":true}`,
+        "getExchangeRate", "usd", "default")
+    testInvokeAtFail(t, stub, "", day + 3601, "transferWithConversion", "user_a", "user_b", "usd", "default", "10")
+
+    // 配置发行规则后，兑换为默认资产受储备限制，并记录为发行
+    testRegister(t, stub, "issuer", "")
+    testInvoke(t, stub, "", "setIssuanceConfig", `{"issuer_account":"issuer","period_seconds":86400,"period_cap":"","operator_cap":""}`)
+    testInvoke(t, stub, "", "grantRole", "treasury", mockCallerId)
+    testInvokeAt(t, stub, "", day + 3660, "", "attestReserve", "200", "AUDIT-1")
+    testInvokeAt(t, stub, "", day + 3660, "", "setExchangeRate", "usd", "default", "6.9", "3600")
+    testInvokeAtFail(t, stub, "", day + 3660, "transferWithConversion", "user_a", "user_b", "usd", "default", "10")
+    testGetBalance(t, stub, "user_b", "69.85185184")
+    testInvokeAt(t, stub, "", day + 3660, `{"id":"1.2","from":"user_a","to":"user_b","from_asset":"usd","to_asset":"default","amount":"1",` +
+        `"rate":"6.9","rate_posted_at":1537779660,"credited":"6.9","timestamp":1537779660}`,
+        "transferWithConversion", "user_a", "user_b", "usd", "default", "1")
+    testInvoke(t, stub, "145.75185184", "getTotalSupply")
+    testInvoke(t, stub, `{"id":"1","from":"issuer","to":"user_b","amount":"6.9","timestamp":1537779660,"reversal_of":"","reversed_by":""}`,
+        "getTransfer", "1")
+}
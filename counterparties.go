@@ -0,0 +1,186 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// 收款方和付款方
+// getPayeesOfUser 和 getPayersOfUser 按 u_r: 中的约束分别列出用户可以转账给谁、谁可以转账给用户，调用方不需要再自己解析约束的枚举值。
+// 只包括通过 setRestraint（或 applyRestraintTemplate）直接设置的约束，不包括组约束和子账户继承的父账户约束，
+// 返回值中的 explicit_only 始终为 true 以便调用方区分；实际能否转账见 getEffectiveRestraint。
+//
+// 结果按对方用户名排序并分页：page_size 为每页的数量，默认 DEFAULT_PAGE_SIZE，最多 MAX_PAGE_SIZE；
+// bookmark 为上一页返回的 bookmark（账本分页查询的 bookmark，调用方原样传回即可），第一页为 ""；返回的 bookmark 为 "" 表示没有下一页。
+// include 为逗号分隔的 info、balance，分别在结果中附带对方的用户信息和余额。
+
+const (
+    DEFAULT_PAGE_SIZE = 100
+    MAX_PAGE_SIZE     = 1000
+)
+
+type Counterparty struct {
+    Username string          `json:"username"`
+    // 双方是否可以互转
+    TwoWay   bool            `json:"two_way"`
+    Info     json.RawMessage `json:"info,omitempty"`
+    Balance  string          `json:"balance,omitempty"`
+}
+
+type CounterpartyPage struct {
+    Users        []*Counterparty `json:"users"`
+    Bookmark     string          `json:"bookmark"`
+    // 只列出 u_r: 中的约束
+    ExplicitOnly bool            `json:"explicit_only"`
+}
+
+// 列出 username 的收款方（outbound 为 true）或付款方
+func listCounterparties(stub shim.ChaincodeStubInterface, username string, outbound bool, args []string) (*CounterpartyPage, error) {
+    page_size := DEFAULT_PAGE_SIZE
+    bookmark := ""
+    include_info, include_balance := false, false
+
+    if len(args) > 0 && strings.TrimSpace(args[0]) != "" {
+        page_size_str := strings.TrimSpace(args[0])
+        size, err := strconv.Atoi(page_size_str)
+        if err != nil || size < 1 || size > MAX_PAGE_SIZE {
+            return nil, fmt.Errorf("page_size got %s, expected an integer in [1, %d]", page_size_str, MAX_PAGE_SIZE)
+        }
+        page_size = size
+    }
+
+    if len(args) > 1 {
+        bookmark = strings.TrimSpace(args[1])
+    }
+
+    if len(args) > 2 && strings.TrimSpace(args[2]) != "" {
+        for _, item := range strings.Split(args[2], ",") {
+            switch strings.TrimSpace(item) {
+            case "info":
+                include_info = true
+            case "balance":
+                include_balance = true
+            default:
+                return nil, fmt.Errorf("include got %s, expected info or balance", item)
+            }
+        }
+    }
+
+    if err := checkRegistered(stub, "username", username); err != nil {
+        return nil, err
+    }
+
+    page := &CounterpartyPage{Users: []*Counterparty{}, ExplicitOnly: true}
+
+    // 不允许的约束被过滤掉，每次只取还差的数量，取满一页或没有下一页时结束，返回的 bookmark 总是落在已经读过的记录之后
+    for {
+        itr, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination("u_r:", []string{username}, int32(page_size - len(page.Users)), bookmark)
+        if err != nil {
+            return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+        }
+
+        for itr.HasNext() {
+            kv, err := itr.Next()
+            if err != nil {
+                itr.Close()
+                return nil, fmt.Errorf("Failed to get restraint stored. %s", err.Error())
+            }
+            _, compositeKeyParts, err := stub.SplitCompositeKey(kv.Key)
+            if err != nil {
+                itr.Close()
+                return nil, fmt.Errorf("Failed to parse restraint stored. %s", err.Error())
+            }
+
+            counterpart := compositeKeyParts[1]
+
+            restraint := RestraintType(kv.Value[0])
+            if !outbound {
+                restraint = restraint.reverse()
+            }
+            if !restraint.allow() {
+                continue
+            }
+
+            entry := &Counterparty{
+                Username: counterpart,
+                TwoWay: restraint == TWOWAY,
+            }
+
+            if include_info {
+                user_info_key, err := stub.CreateCompositeKey("u_i:", []string{counterpart})
+                if err != nil {
+                    itr.Close()
+                    return nil, fmt.Errorf("username is not valid. %s", err.Error())
+                }
+
+                userAsBytes, err := stub.GetState(user_info_key)
+                if err != nil {
+                    itr.Close()
+                    return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+                }
+                entry.Info = json.RawMessage(userAsBytes)
+            }
+
+            if include_balance {
+                balance, err := getStoredBalance(stub, "username", counterpart)
+                if err != nil {
+                    itr.Close()
+                    return nil, err
+                }
+                entry.Balance = balance.String()
+            }
+
+            page.Users = append(page.Users, entry)
+        }
+        itr.Close()
+
+        bookmark = metadata.Bookmark
+        if bookmark == "" || metadata.FetchedRecordsCount == 0 || len(page.Users) == page_size {
+            break
+        }
+    }
+
+    page.Bookmark = bookmark
+
+    return page, nil
+}
+
+func counterpartyResponse(stub shim.ChaincodeStubInterface, fcn string, outbound bool, args []string) pb.Response {
+    if len(args) < 1 || len(args) > 4 {
+        return shim.Error(`parameter error. usage: "{fcn: '` + fcn + `', args: ['username', 'page_size'?, 'bookmark'?, 'info,balance'?]}"`)
+    }
+
+    page, err := listCounterparties(stub, strings.TrimSpace(args[0]), outbound, args[1:])
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    pageAsBytes, err := json.Marshal(page)
+    if err != nil {
+        return shim.Error("Failed to format counterparties. " + err.Error())
+    }
+
+    return shim.Success(pageAsBytes)
+}
+
+// 查询用户可以转账给哪些用户
+// 返回值：json字符串
+// {
+//     "users":[{"username":"user_b","two_way":true,"info":{"name":"user_b","extras":""},"balance":"100"}],
+//     "bookmark":"...",
+//     "explicit_only":true
+// }
+// info 和 balance 只在 include 中指定时返回
+func (cc *RestrainedTransferCC) getPayeesOfUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    return counterpartyResponse(stub, "getPayeesOfUser", true, args)
+}
+
+// 查询哪些用户可以转账给用户，返回值同 getPayeesOfUser
+func (cc *RestrainedTransferCC) getPayersOfUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    return counterpartyResponse(stub, "getPayersOfUser", false, args)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+    "testing"
+    "strings"
+    "encoding/json"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// u_r: 中 username 到 counterpart 的 key，即 getPayeesOfUser 和 getPayersOfUser 返回的 bookmark
+func counterpartyBookmark(t *testing.T, stub *shim.MockStub, username, counterpart string) string {
+    key, err := stub.CreateCompositeKey("u_r:", []string{username, counterpart})
+    if err != nil {
+        t.Fatal(err)
+    }
+    return key
+}
+
+// bookmark 在返回的 json 中的形式
+func jsonBookmark(bookmark string) string {
+    bookmarkAsBytes, _ := json.Marshal(bookmark)
+    return string(bookmarkAsBytes)
+}
+
+// 从第一页开始按 page_size 逐页查询，直到返回的 bookmark 为 ""，返回所有页中的用户名
+func walkCounterparties(t *testing.T, stub *shim.MockStub, fcn string, username string, page_size string) []string {
+    usernames := []string{}
+    bookmark := ""
+    for pages := 0; ; pages++ {
+        if pages > 100 {
+            t.Fatalf("Invoke %s %s does not reach the last page", fcn, username)
+        }
+        ret := stub.MockInvoke("1", invokeArgs(fcn, []string{username, page_size, bookmark}))
+        if ret.Status != shim.OK {
+            t.Fatalf("Invoke %s %s %s failed. %s", fcn, username, page_size, ret.Message)
+        }
+        page := &CounterpartyPage{}
+        if err := json.Unmarshal(ret.Payload, page); err != nil {
+            t.Fatal(err)
+        }
+        for _, user := range page.Users {
+            usernames = append(usernames, user.Username)
+        }
+        if page.Bookmark == "" {
+            return usernames
+        }
+        bookmark = page.Bookmark
+    }
+}
+
+func TestCounterparties(t *testing.T) {
+    stub := shim.NewMockStub("TestCounterparties", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    for _, username := range []string{"user_a", "user_b", "user_c", "user_d", "user_e"} {
+        testRegister(t, stub, username, "")
+    }
+    testSetRestraint(t, stub, "user_a", "user_b", "1")
+    testSetRestraint(t, stub, "user_a", "user_c", "3")
+    testSetRestraint(t, stub, "user_d", "user_a", "1")
+    testSetRestraint(t, stub, "user_a", "user_e", "3")
+    testRecharge(t, stub, "user_c", "100")
+
+    testInvoke(t, stub, `{"users":[{"username":"user_b","two_way":false},{"username":"user_c","two_way":true},{"username":"user_e","two_way":true}],"bookmark":"","explicit_only":true}`,
+        "getPayeesOfUser", "user_a")
+    testInvoke(t, stub, `{"users":[{"username":"user_c","two_way":true},{"username":"user_d","two_way":false},{"username":"user_e","two_way":true}],"bookmark":"","explicit_only":true}`,
+        "getPayersOfUser", "user_a")
+    testInvoke(t, stub, `{"users":[{"username":"user_a","two_way":false}],"bookmark":"","explicit_only":true}`, "getPayersOfUser", "user_b")
+    testInvoke(t, stub, `{"users":[],"bookmark":"","explicit_only":true}`, "getPayeesOfUser", "user_b")
+
+    // 分页，bookmark 为下一页第一条记录的 key
+    ad := jsonBookmark(counterpartyBookmark(t, stub, "user_a", "user_d"))
+    ae := jsonBookmark(counterpartyBookmark(t, stub, "user_a", "user_e"))
+    testInvoke(t, stub, `{"users":[{"username":"user_b","two_way":false},{"username":"user_c","two_way":true}],"bookmark":` + ad + `,"explicit_only":true}`,
+        "getPayeesOfUser", "user_a", "2")
+    testInvoke(t, stub, `{"users":[{"username":"user_e","two_way":true}],"bookmark":"","explicit_only":true}`,
+        "getPayeesOfUser", "user_a", "2", counterpartyBookmark(t, stub, "user_a", "user_d"))
+    // user_b 不能转给 user_a，跳过后继续取满一页
+    testInvoke(t, stub, `{"users":[{"username":"user_c","two_way":true},{"username":"user_d","two_way":false}],"bookmark":` + ae + `,"explicit_only":true}`,
+        "getPayersOfUser", "user_a", "2", "", "")
+    testInvoke(t, stub, `{"users":[{"username":"user_e","two_way":true}],"bookmark":"","explicit_only":true}`,
+        "getPayersOfUser", "user_a", "2", counterpartyBookmark(t, stub, "user_a", "user_e"))
+    testInvoke(t, stub, `{"users":[{"username":"user_c","two_way":true}],"bookmark":` + ad + `,"explicit_only":true}`,
+        "getPayersOfUser", "user_a", "1")
+
+    // bookmark 不是已有的 key 时从它之后开始
+    testInvoke(t, stub, `{"users":[{"username":"user_c","two_way":true}],"bookmark":` + ad + `,"explicit_only":true}`,
+        "getPayeesOfUser", "user_a", "1", counterpartyBookmark(t, stub, "user_a", "user_b0"))
+
+    // 逐页查询到最后一页，结果与不分页时相同
+    for _, page_size := range []string{"1", "2", "3", "4"} {
+        if payees := walkCounterparties(t, stub, "getPayeesOfUser", "user_a", page_size); strings.Join(payees, ",") != "user_b,user_c,user_e" {
+            t.Fatalf("getPayeesOfUser user_a with page_size %s got %v", page_size, payees)
+        }
+        if payers := walkCounterparties(t, stub, "getPayersOfUser", "user_a", page_size); strings.Join(payers, ",") != "user_c,user_d,user_e" {
+            t.Fatalf("getPayersOfUser user_a with page_size %s got %v", page_size, payers)
+        }
+    }
+
+    // 只列出 u_r: 中的约束，组约束不在其中，显式的 0 也不列出
+    testInvoke(t, stub, "", "createGroup", "dept", "")
+    testInvoke(t, stub, "", "addGroupMember", "dept", "user_e")
+    testInvoke(t, stub, "", "setUserGroupRestraint", "user_b", "dept", "1")
+    testSetRestraint(t, stub, "user_b", "user_d", "0")
+    testInvoke(t, stub, "1", "getEffectiveRestraint", "user_b", "user_e")
+    testInvoke(t, stub, `{"users":[],"bookmark":"","explicit_only":true}`, "getPayeesOfUser", "user_b")
+
+    // 附带用户信息和余额
+    testInvoke(t, stub, `{"users":[{"username":"user_c","two_way":true,"info":{"extras":"","name":"user_c"},"balance":"100"}],"bookmark":` + ad + `,"explicit_only":true}`,
+        "getPayersOfUser", "user_a", "1", "", "info,balance")
+    testInvoke(t, stub, `{"users":[{"username":"user_c","two_way":true,"balance":"100"}],"bookmark":` + ad + `,"explicit_only":true}`,
+        "getPayersOfUser", "user_a", "1", "", "balance")
+
+    testInvokeFail(t, stub, "getPayeesOfUser", "user_x")
+    testInvokeFail(t, stub, "getPayeesOfUser", "user_a", "0")
+    testInvokeFail(t, stub, "getPayeesOfUser", "user_a", "1001")
+    testInvokeFail(t, stub, "getPayeesOfUser", "user_a", "10", "", "extras")
+    testInvokeFail(t, stub, "getPayeesOfUser")
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+
+    "github.com/shopspring/decimal"
+)
+
+// 透支额度
+// admin 可以给账户设置透支额度，存储在 c_l: 下。有额度的账户余额可以为负数，最低为 -limit。
+// 透支不计利息，只记录透支的时长：余额由非负变为负数时记录 overdrawn_since，
+// 恢复为非负数时把这段时长累加到 overdrawn_seconds。
+
+type CreditLine struct {
+    Limit            string `json:"limit"`
+    // 本次透支开始的时间，没有透支时为 0
+    OverdrawnSince   int64  `json:"overdrawn_since"`
+    // 已经结束的透支的总时长
+    OverdrawnSeconds int64  `json:"overdrawn_seconds"`
+}
+
+func getCreditLine(stub shim.ChaincodeStubInterface, username string) (CreditLine, error) {
+    credit_key, err := stub.CreateCompositeKey("c_l:", []string{username})
+    if err != nil {
+        return CreditLine{}, fmt.Errorf("username is not valid. %s", err.Error())
+    }
+
+    creditAsBytes, err := stub.GetState(credit_key)
+    if err != nil {
+        return CreditLine{}, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if creditAsBytes == nil {
+        return CreditLine{Limit: "0"}, nil
+    }
+
+    var credit CreditLine
+    err = json.Unmarshal(creditAsBytes, &credit)
+    if err != nil {
+        return CreditLine{}, fmt.Errorf("Failed to parse credit line stored. %s", err.Error())
+    }
+
+    return credit, nil
+}
+
+func putCreditLine(stub shim.ChaincodeStubInterface, username string, credit CreditLine) error {
+    credit_key, err := stub.CreateCompositeKey("c_l:", []string{username})
+    if err != nil {
+        return fmt.Errorf("username is not valid. %s", err.Error())
+    }
+
+    creditAsBytes, err := json.Marshal(credit)
+    if err != nil {
+        return fmt.Errorf("Failed to format credit line. %s", err.Error())
+    }
+
+    err = stub.PutState(credit_key, creditAsBytes)
+    if err != nil {
+        return fmt.Errorf("Failed to put state. %s", err.Error())
+    }
+
+    return nil
+}
+
+// 查询账户的透支额度
+func getCreditLimit(stub shim.ChaincodeStubInterface, username string) (decimal.Decimal, error) {
+    credit, err := getCreditLine(stub, username)
+    if err != nil {
+        return decimal.Zero, err
+    }
+
+    limit, err := decimal.NewFromString(credit.Limit)
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("Failed to parse credit line stored. %s", err.Error())
+    }
+
+    return limit, nil
+}
+
+// 余额在 0 两侧变化时记录透支的开始和结束
+func trackOverdraft(stub shim.ChaincodeStubInterface, username string, old_balance, new_balance decimal.Decimal) error {
+    was_overdrawn := old_balance.LessThan(decimal.Zero)
+    is_overdrawn := new_balance.LessThan(decimal.Zero)
+
+    if was_overdrawn == is_overdrawn {
+        return nil
+    }
+
+    credit, err := getCreditLine(stub, username)
+    if err != nil {
+        return err
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return err
+    }
+
+    if is_overdrawn {
+        credit.OverdrawnSince = now.Unix()
+    } else {
+        if credit.OverdrawnSince > 0 {
+            credit.OverdrawnSeconds += now.Unix() - credit.OverdrawnSince
+        }
+        credit.OverdrawnSince = 0
+    }
+
+    return putCreditLine(stub, username, credit)
+}
+
+// 余额的详细信息，见 getBalance
+func getBalanceDetail(stub shim.ChaincodeStubInterface, username string, balance decimal.Decimal) (MAP, error) {
+    credit, err := getCreditLine(stub, username)
+    if err != nil {
+        return nil, err
+    }
+
+    limit, err := decimal.NewFromString(credit.Limit)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to parse credit line stored. %s", err.Error())
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return nil, err
+    }
+
+    credit_used := decimal.Zero
+    if balance.LessThan(decimal.Zero) {
+        credit_used = balance.Neg()
+    }
+
+    credit_available := limit.Sub(credit_used)
+    if credit_available.LessThan(decimal.Zero) {
+        credit_available = decimal.Zero
+    }
+
+    locked, err := getLockedBalance(stub, username)
+    if err != nil {
+        return nil, err
+    }
+
+    spendable, err := getSpendableBalance(stub, username, balance)
+    if err != nil {
+        return nil, err
+    }
+
+    overdrawn_seconds := credit.OverdrawnSeconds
+    if credit.OverdrawnSince > 0 {
+        overdrawn_seconds += now.Unix() - credit.OverdrawnSince
+    }
+
+    return MAP{
+        "balance": balance.String(),
+        "credit_limit": limit.String(),
+        "credit_used": credit_used.String(),
+        "credit_available": credit_available.String(),
+        "overdrawn_since": credit.OverdrawnSince,
+        "overdrawn_seconds": overdrawn_seconds,
+        "locked": locked.String(),
+        "spendable": spendable.String(),
+    }, nil
+}
+
+// 设置账户的透支额度，只有 admin 可以调用
+// limit 为 0 表示取消透支额度；额度可以低于已透支的金额，此时账户不能再转出或提款
+// 返回值：nil
+func (cc *RestrainedTransferCC) setCreditLimit(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'setCreditLimit', args: ['username', 'limit']}"`)
+    }
+
+    username := strings.TrimSpace(args[0])
+    limit_str := strings.TrimSpace(args[1])
+
+    var err error
+
+    limit, err := validateNonNegativeAmount("limit", limit_str)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkCallerRole(stub, ROLE_ADMIN); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkRegistered(stub, "username", username); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    credit, err := getCreditLine(stub, username)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    credit.Limit = limit.String()
+
+    err = putCreditLine(stub, username, credit)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(nil)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+    "testing"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestCreditLimit(t *testing.T) {
+    stub := shim.NewMockStub("TestCreditLimit", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "user_a", "")
+    testRegister(t, stub, "user_b", "")
+    testSetRestraint(t, stub, "user_a", "user_b", "3")
+
+    day := int64(1537776000)
+
+    testInvokeAt(t, stub, "", day, `{"balance":"0","credit_available":"0","credit_limit":"0","credit_used":"0","locked":"0","overdrawn_seconds":0,"overdrawn_since":0,"spendable":"0"}`, "getBalance", "user_a", "detail")
+    testInvokeFail(t, stub, "getBalance", "user_a", "summary")
+    testTransferFail(t, stub, "user_a", "user_b", "1")
+
+    testInvokeFail(t, stub, "setCreditLimit", "user_a", "-1")
+    testInvokeFail(t, stub, "setCreditLimit", "user_a", "abc")
+    testInvokeFail(t, stub, "setCreditLimit", "user_x", "100")
+    testInvokeAsFail(t, stub, "bob", "setCreditLimit", "user_a", "1000")
+    testInvoke(t, stub, "", "setCreditLimit", "user_a", "1000")
+
+    testInvokeAt(t, stub, "", day, "", "transfer", "user_a", "user_b", "600")
+    testGetBalance(t, stub, "user_a", "-600")
+    testInvokeAt(t, stub, "", day + 60, "", "withdraw", "user_a", "300")
+    testInvokeAtFail(t, stub, "", day + 60, "withdraw", "user_a", "100.01")
+    testInvokeAtFail(t, stub, "", day + 60, "transfer", "user_a", "user_b", "100.01")
+    testInvokeAt(t, stub, "", day + 3600, `{"balance":"-900","credit_available":"100","credit_limit":"1000","credit_used":"900","locked":"0","overdrawn_seconds":3600,"overdrawn_since":1537776000,"spendable":"100"}`, "getBalance", "user_a", "detail")
+
+    // 转入的钱先偿还透支
+    testInvokeAt(t, stub, "", day + 3600, "", "transfer", "user_b", "user_a", "500")
+    testInvokeAt(t, stub, "", day + 7200, "", "recharge", "user_a", "500")
+    testGetBalance(t, stub, "user_a", "100")
+    testInvokeAt(t, stub, "", day + 9000, `{"balance":"100","credit_available":"1000","credit_limit":"1000","credit_used":"0","locked":"0","overdrawn_seconds":7200,"overdrawn_since":0,"spendable":"1100"}`, "getBalance", "user_a", "detail")
+
+    // 降低额度后不能再透支，但不影响已有的余额
+    testInvokeAt(t, stub, "", day + 9000, "", "withdraw", "user_a", "600")
+    testInvoke(t, stub, "", "setCreditLimit", "user_a", "100")
+    testInvokeAtFail(t, stub, "", day + 9000, "withdraw", "user_a", "1")
+    testInvokeAt(t, stub, "", day + 9600, `{"balance":"-500","credit_available":"0","credit_limit":"100","credit_used":"500","locked":"0","overdrawn_seconds":7800,"overdrawn_since":1537785000,"spendable":"0"}`, "getBalance", "user_a", "detail")
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+
+    "github.com/shopspring/decimal"
+)
+
+// 充值记录
+// 每一笔 recharge 都记录在 d_p: 下，key 为充值 id（规则同转账 id，见 newTxScopedId），
+// 可以带上外部流水号 reference 和渠道 channel（如银行名称），同一渠道的流水号不能重复使用，索引在 d_r: 下，key 为 [渠道, 流水号]。
+// 充值规则由 admin 设置，存储在 d_c: 下：
+// require_reference 为 true 时充值必须带流水号；
+// dual_control 为 true 时只有 operator 角色可以充值，充值后为 pending 状态，需要另一个 operator 通过 approveDeposit 确认后才计入余额。
+// 按时间的索引存储在 d_t: 下，key 为 [补零的创建时间, 充值 id]，用于对账报表 getDepositReport。
+
+const (
+    DEPOSIT_PENDING  = "pending"
+    DEPOSIT_CREDITED = "credited"
+    DEPOSIT_REJECTED = "rejected"
+)
+
+type DepositRules struct {
+    RequireReference bool `json:"require_reference"`
+    DualControl      bool `json:"dual_control"`
+}
+
+type Deposit struct {
+    Id         string `json:"id"`
+    Username   string `json:"username"`
+    Amount     string `json:"amount"`
+    Reference  string `json:"reference"`
+    Channel    string `json:"channel"`
+    Status     string `json:"status"`
+    CreatedBy  string `json:"created_by"`
+    CreatedAt  int64  `json:"created_at"`
+    ApprovedBy string `json:"approved_by"`
+    ApprovedAt int64  `json:"approved_at"`
+    Reason     string `json:"reason"`
+}
+
+func getDepositRules(stub shim.ChaincodeStubInterface) (DepositRules, error) {
+    rules_key, err := stub.CreateCompositeKey("d_c:", []string{})
+    if err != nil {
+        return DepositRules{}, fmt.Errorf("Failed to create key. %s", err.Error())
+    }
+
+    rulesAsBytes, err := stub.GetState(rules_key)
+    if err != nil {
+        return DepositRules{}, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+
+    var rules DepositRules
+    if rulesAsBytes == nil {
+        return rules, nil
+    }
+
+    err = json.Unmarshal(rulesAsBytes, &rules)
+    if err != nil {
+        return DepositRules{}, fmt.Errorf("Failed to parse deposit rules stored. %s", err.Error())
+    }
+
+    return rules, nil
+}
+
+func getDeposit(stub shim.ChaincodeStubInterface, id string) (*Deposit, error) {
+    deposit_key, err := stub.CreateCompositeKey("d_p:", []string{id})
+    if err != nil {
+        return nil, fmt.Errorf("deposit id is not valid. %s", err.Error())
+    }
+
+    depositAsBytes, err := stub.GetState(deposit_key)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if depositAsBytes == nil {
+        return nil, nil
+    }
+
+    var deposit Deposit
+    err = json.Unmarshal(depositAsBytes, &deposit)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to parse deposit stored. %s", err.Error())
+    }
+
+    return &deposit, nil
+}
+
+func putDeposit(stub shim.ChaincodeStubInterface, deposit *Deposit) error {
+    deposit_key, err := stub.CreateCompositeKey("d_p:", []string{deposit.Id})
+    if err != nil {
+        return fmt.Errorf("deposit id is not valid. %s", err.Error())
+    }
+
+    depositAsBytes, err := json.Marshal(deposit)
+    if err != nil {
+        return fmt.Errorf("Failed to format deposit. %s", err.Error())
+    }
+
+    err = stub.PutState(deposit_key, depositAsBytes)
+    if err != nil {
+        return fmt.Errorf("Failed to put state. %s", err.Error())
+    }
+
+    return nil
+}
+
+func depositReferenceKey(stub shim.ChaincodeStubInterface, channel, reference string) (string, error) {
+    reference_key, err := stub.CreateCompositeKey("d_r:", []string{channel, reference})
+    if err != nil {
+        return "", fmt.Errorf("reference or channel is not valid. %s", err.Error())
+    }
+    return reference_key, nil
+}
+
+// 按充值规则检查调用者和流水号，返回充值规则
+// 开启双人复核或配置了发行规则（见 issuance.go）时调用者必须是 operator
+func checkDeposit(stub shim.ChaincodeStubInterface, reference, channel string) (DepositRules, error) {
+    rules, err := getDepositRules(stub)
+    if err != nil {
+        return DepositRules{}, err
+    }
+
+    config, err := getIssuanceConfig(stub)
+    if err != nil {
+        return DepositRules{}, err
+    }
+
+    if rules.DualControl || config != nil {
+        if err = checkCallerRole(stub, ROLE_OPERATOR); err != nil {
+            return DepositRules{}, err
+        }
+    }
+
+    if reference == "" {
+        if rules.RequireReference {
+            return DepositRules{}, fmt.Errorf("reference should not be empty.")
+        }
+        return rules, nil
+    }
+
+    reference_key, err := depositReferenceKey(stub, channel, reference)
+    if err != nil {
+        return DepositRules{}, err
+    }
+
+    existingAsBytes, err := stub.GetState(reference_key)
+    if err != nil {
+        return DepositRules{}, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if existingAsBytes != nil {
+        return DepositRules{}, fmt.Errorf("reference %s of channel %s is already used by deposit %s.", reference, channel, string(existingAsBytes))
+    }
+
+    return rules, nil
+}
+
+// 创建充值记录，status 为 pending 或 credited，余额由调用方修改
+func createDeposit(stub shim.ChaincodeStubInterface, username string, amount decimal.Decimal, reference, channel, status string) (*Deposit, error) {
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return nil, err
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return nil, err
+    }
+
+    id, err := newTxScopedId(stub, "d_p:")
+    if err != nil {
+        return nil, err
+    }
+
+    deposit := &Deposit{
+        Id: id,
+        Username: username,
+        Amount: amount.String(),
+        Reference: reference,
+        Channel: channel,
+        Status: status,
+        CreatedBy: caller,
+        CreatedAt: now.Unix(),
+    }
+    if status == DEPOSIT_CREDITED {
+        deposit.ApprovedBy = caller
+        deposit.ApprovedAt = now.Unix()
+    }
+
+    err = putDeposit(stub, deposit)
+    if err != nil {
+        return nil, err
+    }
+
+    if reference != "" {
+        reference_key, err := depositReferenceKey(stub, channel, reference)
+        if err != nil {
+            return nil, err
+        }
+
+        err = stub.PutState(reference_key, []byte(id))
+        if err != nil {
+            return nil, fmt.Errorf("Failed to put state. %s", err.Error())
+        }
+    }
+
+    time_key, err := stub.CreateCompositeKey("d_t:", []string{fmt.Sprintf("%020d", now.Unix()), id})
+    if err != nil {
+        return nil, fmt.Errorf("deposit id is not valid. %s", err.Error())
+    }
+
+    err = stub.PutState(time_key, []byte{0x00})
+    if err != nil {
+        return nil, fmt.Errorf("Failed to put state. %s", err.Error())
+    }
+
+    return deposit, nil
+}
+
+// 读取 pending 状态的充值，检查调用者是 operator 角色并且不是创建者
+func getPendingDeposit(stub shim.ChaincodeStubInterface, id string) (*Deposit, error) {
+    if err := checkCallerRole(stub, ROLE_OPERATOR); err != nil {
+        return nil, err
+    }
+
+    deposit, err := getDeposit(stub, id)
+    if err != nil {
+        return nil, err
+    }
+    if deposit == nil {
+        return nil, fmt.Errorf("deposit %s does not exist.", id)
+    }
+    if deposit.Status != DEPOSIT_PENDING {
+        return nil, fmt.Errorf("deposit %s is %s.", id, deposit.Status)
+    }
+
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return nil, err
+    }
+    if caller == deposit.CreatedBy {
+        return nil, fmt.Errorf("deposit %s must be decided by another operator.", id)
+    }
+
+    return deposit, nil
+}
+
+// 设置充值规则，只有 admin 可以调用
+// rules 为json字符串，字段含义见 deposit.go
+// {"require_reference":true,"dual_control":true}
+// 返回值：nil
+func (cc *RestrainedTransferCC) setDepositRules(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'setDepositRules', args: ['rules']}"`)
+    }
+
+    var err error
+
+    var rules DepositRules
+    err = json.Unmarshal([]byte(args[0]), &rules)
+    if err != nil {
+        return shim.Error("Invalid deposit rules, expecting json. " + err.Error())
+    }
+
+    if err = checkCallerRole(stub, ROLE_ADMIN); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    rules_key, err := stub.CreateCompositeKey("d_c:", []string{})
+    if err != nil {
+        return shim.Error("Failed to create key. " + err.Error())
+    }
+
+    rulesAsBytes, err := json.Marshal(rules)
+    if err != nil {
+        return shim.Error("Failed to format deposit rules. " + err.Error())
+    }
+
+    err = stub.PutState(rules_key, rulesAsBytes)
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询充值规则
+// 返回值：json字符串，格式同 setDepositRules
+func (cc *RestrainedTransferCC) getDepositRules(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 0 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getDepositRules', args: []}"`)
+    }
+
+    rules, err := getDepositRules(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    rulesAsBytes, err := json.Marshal(rules)
+    if err != nil {
+        return shim.Error("Failed to format deposit rules. " + err.Error())
+    }
+
+    return shim.Success(rulesAsBytes)
+}
+
+// 确认 pending 状态的充值并计入余额，只有 operator 角色可以调用，并且不能是充值的创建者
+// 返回值：nil，被制裁名单拦截时返回值见 screening.go
+func (cc *RestrainedTransferCC) approveDeposit(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'approveDeposit', args: ['deposit_id']}"`)
+    }
+
+    id := strings.TrimSpace(args[0])
+
+    deposit, err := getPendingDeposit(stub, id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    amount, err := decimal.NewFromString(deposit.Amount)
+    if err != nil {
+        return shim.Error("Failed to parse deposit stored. " + err.Error())
+    }
+
+    balance, err := getStoredBalance(stub, "username", deposit.Username)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    issuance, err := checkIssuance(stub, amount)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = screen(stub, "recharge", amount.String(), deposit.Username); err != nil {
+        return screeningErrorResponse(err)
+    }
+
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    err = mint(stub, deposit.Username, balance, amount, issuance)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    deposit.Status = DEPOSIT_CREDITED
+    deposit.ApprovedBy = caller
+    deposit.ApprovedAt = now.Unix()
+
+    err = putDeposit(stub, deposit)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 拒绝 pending 状态的充值，只有 operator 角色可以调用，并且不能是充值的创建者
+// 被拒绝的充值的流水号可以再次使用
+// 返回值：nil
+func (cc *RestrainedTransferCC) rejectDeposit(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'rejectDeposit', args: ['deposit_id', 'reason']}"`)
+    }
+
+    id := strings.TrimSpace(args[0])
+    reason := strings.TrimSpace(args[1])
+
+    deposit, err := getPendingDeposit(stub, id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if deposit.Reference != "" {
+        reference_key, err := depositReferenceKey(stub, deposit.Channel, deposit.Reference)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+
+        err = stub.DelState(reference_key)
+        if err != nil {
+            return shim.Error("Failed to del state. " + err.Error())
+        }
+    }
+
+    deposit.Status = DEPOSIT_REJECTED
+    deposit.ApprovedBy = caller
+    deposit.ApprovedAt = now.Unix()
+    deposit.Reason = reason
+
+    err = putDeposit(stub, deposit)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询充值记录
+// 返回值：json字符串
+// {
+//     "id":"txid","username":"user_a","amount":"100","reference":"BANK-001","channel":"icbc","status":"credited",
+//     "created_by":"e3b0c442...","created_at":1537776000,"approved_by":"81b637d8...","approved_at":1537776060,"reason":""
+// }
+// 不需要确认的充值 approved_by、approved_at 与 created_by、created_at 相同；被拒绝的充值 approved_by 为拒绝人
+func (cc *RestrainedTransferCC) getDeposit(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getDeposit', args: ['deposit_id']}"`)
+    }
+
+    id := strings.TrimSpace(args[0])
+
+    deposit, err := getDeposit(stub, id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if deposit == nil {
+        return shim.Error("deposit " + id + " does not exist.")
+    }
+
+    depositAsBytes, err := json.Marshal(deposit)
+    if err != nil {
+        return shim.Error("Failed to format deposit. " + err.Error())
+    }
+
+    return shim.Success(depositAsBytes)
+}
+
+// 对账报表：创建时间在 [start_time, end_time] 之间的充值，channel 不为 "" 时只包含该渠道的充值
+// 返回值：json字符串，deposits 格式同 getDeposit 的数组，按创建时间排序
+// {"deposits":[...],"count":2,"total_credited":"150","total_pending":"50","total_rejected":"0"}
+func (cc *RestrainedTransferCC) getDepositReport(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 3 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getDepositReport', args: ['start_time', 'end_time', 'channel']}"`)
+    }
+
+    channel := strings.TrimSpace(args[2])
+
+    var err error
+
+    start_time, err := parseScheduleTime("start_time", strings.TrimSpace(args[0]))
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    end_time, err := parseScheduleTime("end_time", strings.TrimSpace(args[1]))
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    itr, err := stub.GetStateByPartialCompositeKey("d_t:", []string{})
+    if err != nil {
+        return shim.Error("Failed to get state. " + err.Error())
+    }
+    defer itr.Close()
+
+    deposits := []*Deposit{}
+    totals := map[string]decimal.Decimal{
+        DEPOSIT_PENDING: decimal.Zero,
+        DEPOSIT_CREDITED: decimal.Zero,
+        DEPOSIT_REJECTED: decimal.Zero,
+    }
+
+    for itr.HasNext() {
+        kv, err := itr.Next()
+        if err != nil {
+            return shim.Error("Failed to get deposit stored. " + err.Error())
+        }
+        _, compositeKeyParts, err := stub.SplitCompositeKey(kv.Key)
+        if err != nil {
+            return shim.Error("Failed to parse deposit stored. " + err.Error())
+        }
+
+        deposit, err := getDeposit(stub, compositeKeyParts[1])
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        if deposit == nil || deposit.CreatedAt < start_time {
+            continue
+        }
+        if deposit.CreatedAt > end_time {
+            break
+        }
+        if channel != "" && deposit.Channel != channel {
+            continue
+        }
+
+        amount, err := decimal.NewFromString(deposit.Amount)
+        if err != nil {
+            return shim.Error("Failed to parse deposit stored. " + err.Error())
+        }
+
+        totals[deposit.Status] = totals[deposit.Status].Add(amount)
+        deposits = append(deposits, deposit)
+    }
+
+    reportAsBytes, err := json.Marshal(MAP{
+        "deposits": deposits,
+        "count": len(deposits),
+        "total_credited": totals[DEPOSIT_CREDITED].String(),
+        "total_pending": totals[DEPOSIT_PENDING].String(),
+        "total_rejected": totals[DEPOSIT_REJECTED].String(),
+    })
+    if err != nil {
+        return shim.Error("Failed to format deposit report. " + err.Error())
+    }
+
+    return shim.Success(reportAsBytes)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+    "testing"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestDeposit(t *testing.T) {
+    stub := shim.NewMockStub("TestDeposit", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "user_a", "")
+    testRegister(t, stub, "user_b", "")
+
+    day := int64(1537776000)
+
+    // 同一个 MockStub 的交易 id 都是 "1"，之后的充值 id 依次加后缀
+    testInvokeAt(t, stub, "", day, "", "recharge", "user_a", "100", "BANK-001", "icbc")
+    testInvokeAtFail(t, stub, "", day, "recharge", "user_b", "100", "BANK-001", "icbc")
+    testInvokeAtFail(t, stub, "", day, "recharge", "user_b", "100", "BANK-001")
+    testInvokeAt(t, stub, "", day, "", "recharge", "user_b", "100", "BANK-001", "boc")
+    testInvokeAt(t, stub, "", day + 60, "", "recharge", "user_b", "5")
+    testGetBalance(t, stub, "user_a", "100")
+    testGetBalance(t, stub, "user_b", "105")
+    testInvoke(t, stub, `{"id":"1","username":"user_a","amount":"100","reference":"BANK-001","channel":"icbc","status":"credited",` +
+        `"created_by":"` + mockCallerId + `","created_at":1537776000,"approved_by":"` + mockCallerId + `","approved_at":1537776000,"reason":""}`, "getDeposit", "1")
+
+    testInvoke(t, stub, `{"require_reference":false,"dual_control":false}`, "getDepositRules")
+    testInvokeAsFail(t, stub, "bob", "setDepositRules", `{"require_reference":true,"dual_control":true}`)
+    testInvokeFail(t, stub, "setDepositRules", `not json`)
+    testInvoke(t, stub, "", "setDepositRules", `{"require_reference":true,"dual_control":true}`)
+
+    // 开启双人复核后只有 operator 可以充值，并且需要另一个 operator 确认
+    testInvokeAtFail(t, stub, "", day + 120, "recharge", "user_a", "50", "BANK-002", "icbc")
+    testInvoke(t, stub, "", "grantRole", "operator", mockCallerId)
+    testInvoke(t, stub, "", "grantRole", "operator", bobId)
+    testInvokeAtFail(t, stub, "", day + 120, "recharge", "user_a", "50")
+    testInvokeAt(t, stub, "", day + 120, "", "recharge", "user_a", "50", "BANK-002", "icbc")
+    testInvokeAt(t, stub, "", day + 180, "", "recharge", "user_a", "20", "BANK-003", "icbc")
+    testGetBalance(t, stub, "user_a", "100")
+
+    testInvokeFail(t, stub, "approveDeposit", "1.3")
+    testInvokeAsFail(t, stub, "carol", "approveDeposit", "1.3")
+    testInvokeAt(t, stub, "bob", day + 240, "", "approveDeposit", "1.3")
+    testInvokeAsFail(t, stub, "bob", "approveDeposit", "1.3")
+    testGetBalance(t, stub, "user_a", "150")
+
+    // 被拒绝的流水号可以再次使用
+    testInvokeAt(t, stub, "bob", day + 240, "", "rejectDeposit", "1.4", "wrong amount")
+    testInvokeAt(t, stub, "", day + 300, "", "recharge", "user_a", "25", "BANK-003", "icbc")
+    testGetBalance(t, stub, "user_a", "150")
+
+    testInvokeAt(t, stub, "", day, `{"count":3,"deposits":[` +
+        `{"id":"1.3","username":"user_a","amount":"50","reference":"BANK-002","channel":"icbc","status":"credited","created_by":"` + mockCallerId + `","created_at":1537776120,"approved_by":"` + bobId + `","approved_at":1537776240,"reason":""},` +
+        `{"id":"1.4","username":"user_a","amount":"20","reference":"BANK-003","channel":"icbc","status":"rejected","created_by":"` + mockCallerId + `","created_at":1537776180,"approved_by":"` + bobId + `","approved_at":1537776240,"reason":"wrong amount"},` +
+        `{"id":"1.5","username":"user_a","amount":"25","reference":"BANK-003","channel":"icbc","status":"pending","created_by":"` + mockCallerId + `","created_at":1537776300,"approved_by":"","approved_at":0,"reason":""}` +
+        `],"total_credited":"50","total_pending":"25","total_rejected":"20"}`, "getDepositReport", "1537776060", "1537776300", "icbc")
+    testInvokeAt(t, stub, "", day, `{"count":3,"deposits":[` +
+        `{"id":"1","username":"user_a","amount":"100","reference":"BANK-001","channel":"icbc","status":"credited","created_by":"` + mockCallerId + `","created_at":1537776000,"approved_by":"` + mockCallerId + `","approved_at":1537776000,"reason":""},` +
+        `{"id":"1.1","username":"user_b","amount":"100","reference":"BANK-001","channel":"boc","status":"credited","created_by":"` + mockCallerId + `","created_at":1537776000,"approved_by":"` + mockCallerId + `","approved_at":1537776000,"reason":""},` +
+        `{"id":"1.2","username":"user_b","amount":"5","reference":"","channel":"","status":"credited","created_by":"` + mockCallerId + `","created_at":1537776060,"approved_by":"` + mockCallerId + `","approved_at":1537776060,"reason":""}` +
+        `],"total_credited":"205","total_pending":"0","total_rejected":"0"}`, "getDepositReport", "0", "1537776060", "")
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// 冻结账户
+// 被冻结的账户存储在 u_f: 下，冻结后不能转出、转入和提款，也不会作为 routedTransfer 的中间账户
+// 只有 admin 或 compliance 角色可以冻结和解冻账户；冻结用户同时冻结它的所有子账户（见 subaccounts.go）
+
+// 查询账户是否被冻结，子账户的父账户被冻结时子账户也视为被冻结
+func isFrozen(stub shim.ChaincodeStubInterface, username string) (bool, error) {
+    holder, err := getAccountHolder(stub, username)
+    if err != nil {
+        return false, err
+    }
+
+    accounts := []string{username}
+    if holder != username {
+        accounts = append(accounts, holder)
+    }
+
+    for _, account := range accounts {
+        frozen_key, err := stub.CreateCompositeKey("u_f:", []string{account})
+        if err != nil {
+            return false, fmt.Errorf("username is not valid. %s", err.Error())
+        }
+
+        frozenAsBytes, err := stub.GetState(frozen_key)
+        if err != nil {
+            return false, fmt.Errorf("Failed to get state. %s", err.Error())
+        }
+        if frozenAsBytes != nil {
+            return true, nil
+        }
+    }
+
+    return false, nil
+}
+
+// 账户被冻结时返回 error
+func checkNotFrozen(stub shim.ChaincodeStubInterface, username string) error {
+    frozen, err := isFrozen(stub, username)
+    if err != nil {
+        return err
+    }
+    if frozen {
+        return fmt.Errorf("account %s is frozen.", username)
+    }
+    return nil
+}
+
+// 调用者既不是 admin 也不是 compliance 时返回 error
+func checkCallerCanFreeze(stub shim.ChaincodeStubInterface) error {
+    if err := checkCallerRole(stub, ROLE_ADMIN); err == nil {
+        return nil
+    }
+    return checkCallerRole(stub, ROLE_COMPLIANCE)
+}
+
+// 冻结账户
+// 返回值：nil
+func (cc *RestrainedTransferCC) freezeAccount(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'freezeAccount', args: ['username']}"`)
+    }
+
+    username := strings.TrimSpace(args[0])
+
+    var err error
+
+    if err = checkCallerCanFreeze(stub); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkRegistered(stub, "username", username); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    frozen_key, err := stub.CreateCompositeKey("u_f:", []string{username})
+    if err != nil {
+        return shim.Error("username is not valid. " + err.Error())
+    }
+
+    err = stub.PutState(frozen_key, []byte("1"))
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 解冻账户
+// 返回值：nil
+func (cc *RestrainedTransferCC) unfreezeAccount(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'unfreezeAccount', args: ['username']}"`)
+    }
+
+    username := strings.TrimSpace(args[0])
+
+    var err error
+
+    if err = checkCallerCanFreeze(stub); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkRegistered(stub, "username", username); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    frozen_key, err := stub.CreateCompositeKey("u_f:", []string{username})
+    if err != nil {
+        return shim.Error("username is not valid. " + err.Error())
+    }
+
+    err = stub.DelState(frozen_key)
+    if err != nil {
+        return shim.Error("Failed to del state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询账户是否被冻结
+// 返回值：字符串 "true" 或 "false"
+func (cc *RestrainedTransferCC) isAccountFrozen(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'isAccountFrozen', args: ['username']}"`)
+    }
+
+    username := strings.TrimSpace(args[0])
+
+    var err error
+
+    if err = checkRegistered(stub, "username", username); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    frozen, err := isFrozen(stub, username)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success([]byte(fmt.Sprintf("%t", frozen)))
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+    "testing"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestFreezeAccount(t *testing.T) {
+    stub := shim.NewMockStub("TestFreezeAccount", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "user_a", "")
+    testRegister(t, stub, "user_b", "")
+    testSetRestraint(t, stub, "user_a", "user_b", "3")
+    testRecharge(t, stub, "user_a", "100")
+
+    testInvoke(t, stub, "false", "isAccountFrozen", "user_a")
+    testInvokeFail(t, stub, "isAccountFrozen", "user_x")
+    testInvokeFail(t, stub, "freezeAccount", "user_x")
+
+    // 只有 admin 或 compliance 可以冻结和解冻
+    testInvokeAsFail(t, stub, "bob", "freezeAccount", "user_a")
+    testInvoke(t, stub, "false", "isAccountFrozen", "user_a")
+
+    testInvoke(t, stub, "", "freezeAccount", "user_a")
+    testInvoke(t, stub, "true", "isAccountFrozen", "user_a")
+
+    testTransferFail(t, stub, "user_a", "user_b", "10")
+    testTransferFail(t, stub, "user_b", "user_a", "0")
+    testWithdrawFail(t, stub, "user_a", "10")
+    testRecharge(t, stub, "user_a", "10")
+
+    testInvokeAsFail(t, stub, "bob", "unfreezeAccount", "user_a")
+    testInvoke(t, stub, "", "grantRole", "compliance", bobId)
+    testInvokeAs(t, stub, "bob", "", "unfreezeAccount", "user_a")
+    testInvoke(t, stub, "false", "isAccountFrozen", "user_a")
+
+    testTransfer(t, stub, "user_a", "user_b", "10")
+    testWithdraw(t, stub, "user_a", "10")
+    testGetBalance(t, stub, "user_a", "90")
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// 导出 u_r: 约束构成的图
+// setRestraint 对每对用户写入 a->b 和 b->a 两条互为反向的记录，导出时只保留 a < b（按字典序）的那条。
+
+// 以 username 为中心导出时最多允许的跳数
+const MAX_GRAPH_HOPS = 10
+
+type RestraintEdge struct {
+    A         string `json:"a"`
+    B         string `json:"b"`
+    Restraint string `json:"restraint"`
+}
+
+type RestraintGraph struct {
+    Nodes []string        `json:"nodes"`
+    Edges []RestraintEdge `json:"edges"`
+}
+
+// 读取所有 u_r: 约束，去掉反向的重复记录
+func listRestraintEdges(stub shim.ChaincodeStubInterface) ([]RestraintEdge, error) {
+    itr, err := stub.GetStateByPartialCompositeKey("u_r:", []string{})
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    defer itr.Close()
+
+    edges := []RestraintEdge{}
+
+    for itr.HasNext() {
+        kv, err := itr.Next()
+        if err != nil {
+            return nil, fmt.Errorf("Failed to get restraint stored. %s", err.Error())
+        }
+        _, compositeKeyParts, err := stub.SplitCompositeKey(kv.Key)
+        if err != nil {
+            return nil, fmt.Errorf("Failed to parse restraint stored. %s", err.Error())
+        }
+        if compositeKeyParts[0] < compositeKeyParts[1] && RestraintType(kv.Value[0]) != NONWAY {
+            edges = append(edges, RestraintEdge{compositeKeyParts[0], compositeKeyParts[1], string(kv.Value)})
+        }
+    }
+
+    return edges, nil
+}
+
+// 只保留与 username 相距 hops 跳以内的用户之间的约束，约束的方向不影响距离
+func filterRestraintGraph(edges []RestraintEdge, username string, hops int) []RestraintEdge {
+    neighbors := map[string][]string{}
+    for _, edge := range edges {
+        neighbors[edge.A] = append(neighbors[edge.A], edge.B)
+        neighbors[edge.B] = append(neighbors[edge.B], edge.A)
+    }
+
+    reached := map[string]bool{username: true}
+    frontier := []string{username}
+
+    for hop := 0; hop < hops && len(frontier) > 0; hop++ {
+        next := []string{}
+        for _, node := range frontier {
+            for _, neighbor := range neighbors[node] {
+                if !reached[neighbor] {
+                    reached[neighbor] = true
+                    next = append(next, neighbor)
+                }
+            }
+        }
+        frontier = next
+    }
+
+    filtered := []RestraintEdge{}
+    for _, edge := range edges {
+        if reached[edge.A] && reached[edge.B] {
+            filtered = append(filtered, edge)
+        }
+    }
+
+    return filtered
+}
+
+func newRestraintGraph(edges []RestraintEdge, username string) RestraintGraph {
+    nodeSet := map[string]bool{}
+    if username != "" {
+        nodeSet[username] = true
+    }
+    for _, edge := range edges {
+        nodeSet[edge.A] = true
+        nodeSet[edge.B] = true
+    }
+
+    nodes := []string{}
+    for node := range nodeSet {
+        nodes = append(nodes, node)
+    }
+    sort.Strings(nodes)
+
+    return RestraintGraph{Nodes: nodes, Edges: edges}
+}
+
+func dotQuote(s string) string {
+    return `"` + strings.Replace(strings.Replace(s, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+}
+
+// 转换为 Graphviz DOT 格式，箭头方向为允许转账的方向
+func (g RestraintGraph) dot() string {
+    var buf strings.Builder
+
+    buf.WriteString("digraph restraints {\n")
+    for _, node := range g.Nodes {
+        buf.WriteString("    " + dotQuote(node) + ";\n")
+    }
+    for _, edge := range g.Edges {
+        switch RestraintType(edge.Restraint[0]) {
+        case ONEWAY:
+            buf.WriteString("    " + dotQuote(edge.A) + " -> " + dotQuote(edge.B) + ";\n")
+        case ONEWAY_R:
+            buf.WriteString("    " + dotQuote(edge.B) + " -> " + dotQuote(edge.A) + ";\n")
+        case TWOWAY:
+            buf.WriteString("    " + dotQuote(edge.A) + " -> " + dotQuote(edge.B) + " [dir=both];\n")
+        }
+    }
+    buf.WriteString("}\n")
+
+    return buf.String()
+}
+
+// 导出转账约束图
+// format 为 json 或 dot
+// username 为空时导出所有约束，否则只导出与 username 相距 hops 跳以内（1 到 10）的用户之间的约束
+// 返回值：
+// format 为 json 时为json字符串，restraint 的含义见 setRestraint，a 按字典序小于 b
+// {
+//     "nodes":["user_a","user_b"],
+//     "edges":[{"a":"user_a","b":"user_b","restraint":"1"}]
+// }
+// format 为 dot 时为 Graphviz DOT 格式的字符串，箭头方向为允许转账的方向，双向转账为 dir=both
+func (cc *RestrainedTransferCC) exportRestraintGraph(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 3 {
+        return shim.Error(`parameter error. usage: "{fcn: 'exportRestraintGraph', args: ['json or dot', 'username or empty', 'hops']}"`)
+    }
+
+    format := strings.TrimSpace(args[0])
+    username := strings.TrimSpace(args[1])
+    hops_str := strings.TrimSpace(args[2])
+
+    if format != "json" && format != "dot" {
+        return shim.Error("format got " + format + ", expected json or dot")
+    }
+
+    var err error
+
+    edges, err := listRestraintEdges(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if username != "" {
+        if err = checkRegistered(stub, "username", username); err != nil {
+            return shim.Error(err.Error())
+        }
+
+        hops, err := strconv.Atoi(hops_str)
+        if err != nil || hops < 1 || hops > MAX_GRAPH_HOPS {
+            return shim.Error(fmt.Sprintf("hops got %s, expected an integer in [1, %d]", hops_str, MAX_GRAPH_HOPS))
+        }
+
+        edges = filterRestraintGraph(edges, username, hops)
+    }
+
+    graph := newRestraintGraph(edges, username)
+
+    if format == "dot" {
+        return shim.Success([]byte(graph.dot()))
+    }
+
+    graphAsBytes, err := json.Marshal(graph)
+    if err != nil {
+        return shim.Error("Failed to format restraint graph. " + err.Error())
+    }
+
+    return shim.Success(graphAsBytes)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+    "testing"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestExportRestraintGraph(t *testing.T) {
+    stub := shim.NewMockStub("TestExportRestraintGraph", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testInvoke(t, stub, `{"nodes":[],"edges":[]}`, "exportRestraintGraph", "json", "", "")
+
+    testRegister(t, stub, "user_a", "")
+    testRegister(t, stub, "user_b", "")
+    testRegister(t, stub, "user_c", "")
+    testRegister(t, stub, "user_d", "")
+    // 参数检查（见 validation.go）之前注册的用户名可能包含引号
+    stub.MockTransactionStart("1")
+    info_key, _ := stub.CreateCompositeKey("u_i:", []string{`user "e"`})
+    stub.PutState(info_key, []byte(`{"extras":"","name":"user \"e\""}`))
+    balance_key, _ := stub.CreateCompositeKey("u_b:", []string{`user "e"`})
+    stub.PutState(balance_key, []byte("0"))
+    stub.MockTransactionEnd("1")
+
+    testSetRestraint(t, stub, "user_b", "user_a", "1")
+    testSetRestraint(t, stub, "user_b", "user_c", "3")
+    testSetRestraint(t, stub, "user_c", "user_d", "1")
+
+    testInvoke(t, stub, `{"nodes":["user_a","user_b","user_c","user_d"],"edges":[{"a":"user_a","b":"user_b","restraint":"2"},{"a":"user_b","b":"user_c","restraint":"3"},{"a":"user_c","b":"user_d","restraint":"1"}]}`,
+        "exportRestraintGraph", "json", "", "")
+
+    testInvoke(t, stub, `{"nodes":["user_a","user_b","user_c"],"edges":[{"a":"user_a","b":"user_b","restraint":"2"},{"a":"user_b","b":"user_c","restraint":"3"}]}`,
+        "exportRestraintGraph", "json", "user_a", "2")
+    testInvoke(t, stub, `{"nodes":["user_b","user_c","user_d"],"edges":[{"a":"user_b","b":"user_c","restraint":"3"},{"a":"user_c","b":"user_d","restraint":"1"}]}`,
+        "exportRestraintGraph", "json", "user_c", "1")
+    testInvoke(t, stub, `{"nodes":["user \"e\""],"edges":[]}`, "exportRestraintGraph", "json", `user "e"`, "3")
+
+    testInvoke(t, stub, "digraph restraints {\n" +
+        "    \"user_b\";\n" +
+        "    \"user_c\";\n" +
+        "    \"user_d\";\n" +
+        "    \"user_b\" -> \"user_c\" [dir=both];\n" +
+        "    \"user_c\" -> \"user_d\";\n" +
+        "}\n", "exportRestraintGraph", "dot", "user_d", "2")
+
+    testSetRestraint(t, stub, "user_d", `user "e"`, "2")
+    testInvoke(t, stub, "digraph restraints {\n" +
+        "    \"user \\\"e\\\"\";\n" +
+        "    \"user_d\";\n" +
+        "    \"user \\\"e\\\"\" -> \"user_d\";\n" +
+        "}\n", "exportRestraintGraph", "dot", `user "e"`, "1")
+
+    testInvokeFail(t, stub, "exportRestraintGraph", "xml", "", "")
+    testInvokeFail(t, stub, "exportRestraintGraph", "json", "user_x", "1")
+    testInvokeFail(t, stub, "exportRestraintGraph", "json", "user_a", "0")
+    testInvokeFail(t, stub, "exportRestraintGraph", "json", "user_a", "11")
+}
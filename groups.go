@@ -0,0 +1,656 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// 用户组
+// 组信息存储在 g_i: 下，成员关系同时存储在 g_m:(组 -> 用户) 和 u_g:(用户 -> 组) 下，
+// 组级别的转账约束存储在 g_r: 下，与 u_r: 一样成对存储 a->b 和 b->a 两条记录。
+// 创建组、修改成员和组约束都只有 admin 可以调用，否则任何人都可以把自己加入有组约束的组而获得转账权限。
+//
+// transfer 判断 a 能否转给 b 的优先级：
+// 1. 如果 a b 之间存在 u_r: 约束（即通过 setRestraint 设置过约束，包括 0），则只以它为准，组约束不再生效；
+//    设置为 0 的约束作为显式的禁止保留在 u_r: 下，通过 clearRestraint 删除后才重新按组约束判断；
+// 2. 否则只要下列任一组约束允许 a 转给 b 即可转账：
+//    a -> b 所在的组，a 所在的组 -> b，a 所在的组 -> b 所在的组。
+// 组约束只会放开转账，不会禁止转账。
+// 3. a b 之间没有 u_r: 约束且组约束都不允许时，如果 a 或 b 是子账户，再按 1、2 判断双方父账户之间能否转账；同一个用户的账户之间总是可以转账，见 subaccounts.go。
+
+const (
+    SUBJECT_USER  = "u"
+    SUBJECT_GROUP = "g"
+)
+
+// 检查组是否已创建
+func checkGroupCreated(stub shim.ChaincodeStubInterface, arg_name, groupname string) error {
+    group_info_key, err := stub.CreateCompositeKey("g_i:", []string{groupname})
+    if err != nil {
+        return fmt.Errorf("%s is not valid. %s", arg_name, err.Error())
+    }
+
+    groupAsBytes, err := stub.GetState(group_info_key)
+    if err != nil {
+        return fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if groupAsBytes == nil {
+        return fmt.Errorf("%s %s is not created.", arg_name, groupname)
+    }
+
+    return nil
+}
+
+// 查询用户所在的所有组
+func listGroupsOfUser(stub shim.ChaincodeStubInterface, username string) ([]string, error) {
+    itr, err := stub.GetStateByPartialCompositeKey("u_g:", []string{username})
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    defer itr.Close()
+
+    groups := []string{}
+
+    for itr.HasNext() {
+        kv, err := itr.Next()
+        if err != nil {
+            return nil, fmt.Errorf("Failed to get group stored. %s", err.Error())
+        }
+        _, compositeKeyParts, err := stub.SplitCompositeKey(kv.Key)
+        if err != nil {
+            return nil, fmt.Errorf("Failed to parse group stored. %s", err.Error())
+        }
+        groups = append(groups, compositeKeyParts[1])
+    }
+
+    return groups, nil
+}
+
+// 查询组的所有成员
+func listGroupMembers(stub shim.ChaincodeStubInterface, groupname string) ([]string, error) {
+    itr, err := stub.GetStateByPartialCompositeKey("g_m:", []string{groupname})
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    defer itr.Close()
+
+    members := []string{}
+
+    for itr.HasNext() {
+        kv, err := itr.Next()
+        if err != nil {
+            return nil, fmt.Errorf("Failed to get member stored. %s", err.Error())
+        }
+        _, compositeKeyParts, err := stub.SplitCompositeKey(kv.Key)
+        if err != nil {
+            return nil, fmt.Errorf("Failed to parse member stored. %s", err.Error())
+        }
+        members = append(members, compositeKeyParts[1])
+    }
+
+    return members, nil
+}
+
+// 查询 subject_a 到 subject_b 的组约束，没有则返回 NONWAY
+func getGroupRestraint(stub shim.ChaincodeStubInterface, kind_a, name_a, kind_b, name_b string) (RestraintType, error) {
+    restraint_key, err := stub.CreateCompositeKey("g_r:", []string{kind_a, name_a, kind_b, name_b})
+    if err != nil {
+        return NONWAY, fmt.Errorf("restraint subject is not valid. %s", err.Error())
+    }
+
+    restraintAsBytes, err := stub.GetState(restraint_key)
+    if err != nil {
+        return NONWAY, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if restraintAsBytes == nil {
+        return NONWAY, nil
+    }
+
+    return RestraintType(restraintAsBytes[0]), nil
+}
+
+// 写入 subject_a 和 subject_b 之间的组约束，NONWAY 表示删除
+func putGroupRestraint(stub shim.ChaincodeStubInterface, kind_a, name_a, kind_b, name_b string, restraint RestraintType) error {
+    ab_restraint_key, err := stub.CreateCompositeKey("g_r:", []string{kind_a, name_a, kind_b, name_b})
+    if err != nil {
+        return fmt.Errorf("restraint subject is not valid. %s", err.Error())
+    }
+
+    ba_restraint_key, err := stub.CreateCompositeKey("g_r:", []string{kind_b, name_b, kind_a, name_a})
+    if err != nil {
+        return fmt.Errorf("restraint subject is not valid. %s", err.Error())
+    }
+
+    if restraint == NONWAY {
+        if err = stub.DelState(ab_restraint_key); err != nil {
+            return fmt.Errorf("Failed to del state. %s", err.Error())
+        }
+        if err = stub.DelState(ba_restraint_key); err != nil {
+            return fmt.Errorf("Failed to del state. %s", err.Error())
+        }
+        return nil
+    }
+
+    if err = stub.PutState(ab_restraint_key, []byte{byte(restraint)}); err != nil {
+        return fmt.Errorf("Failed to put state. %s", err.Error())
+    }
+    if err = stub.PutState(ba_restraint_key, []byte{byte(restraint.reverse())}); err != nil {
+        return fmt.Errorf("Failed to put state. %s", err.Error())
+    }
+
+    return nil
+}
+
+// 判断 username_a 是否可以转账给 username_b，优先级见文件开头的说明
+func transferAllowed(stub shim.ChaincodeStubInterface, username_a, username_b string) (bool, error) {
+    holder_a, err := getAccountHolder(stub, username_a)
+    if err != nil {
+        return false, err
+    }
+
+    holder_b, err := getAccountHolder(stub, username_b)
+    if err != nil {
+        return false, err
+    }
+
+    if holder_a == holder_b {
+        return true, nil
+    }
+
+    restraint, explicit, err := getExplicitRestraint(stub, username_a, username_b)
+    if err != nil {
+        return false, err
+    }
+    if explicit {
+        return restraint.allow(), nil
+    }
+
+    allowed, err := groupRestraintAllows(stub, username_a, username_b)
+    if err != nil || allowed {
+        return allowed, err
+    }
+
+    if holder_a == username_a && holder_b == username_b {
+        return false, nil
+    }
+
+    return restraintAllows(stub, holder_a, holder_b)
+}
+
+// 按 u_r: 约束和组约束判断 username_a 是否可以转账给 username_b，即文件开头说明的 1、2
+func restraintAllows(stub shim.ChaincodeStubInterface, username_a, username_b string) (bool, error) {
+    restraint, explicit, err := getExplicitRestraint(stub, username_a, username_b)
+    if err != nil {
+        return false, err
+    }
+    if explicit {
+        return restraint.allow(), nil
+    }
+
+    return groupRestraintAllows(stub, username_a, username_b)
+}
+
+// 按组约束判断 username_a 是否可以转账给 username_b，即文件开头说明的 2
+func groupRestraintAllows(stub shim.ChaincodeStubInterface, username_a, username_b string) (bool, error) {
+    groups_a, err := listGroupsOfUser(stub, username_a)
+    if err != nil {
+        return false, err
+    }
+
+    groups_b, err := listGroupsOfUser(stub, username_b)
+    if err != nil {
+        return false, err
+    }
+
+    for _, group_b := range groups_b {
+        restraint, err := getGroupRestraint(stub, SUBJECT_USER, username_a, SUBJECT_GROUP, group_b)
+        if err != nil {
+            return false, err
+        }
+        if restraint.allow() {
+            return true, nil
+        }
+    }
+
+    for _, group_a := range groups_a {
+        restraint, err := getGroupRestraint(stub, SUBJECT_GROUP, group_a, SUBJECT_USER, username_b)
+        if err != nil {
+            return false, err
+        }
+        if restraint.allow() {
+            return true, nil
+        }
+
+        for _, group_b := range groups_b {
+            restraint, err := getGroupRestraint(stub, SUBJECT_GROUP, group_a, SUBJECT_GROUP, group_b)
+            if err != nil {
+                return false, err
+            }
+            if restraint.allow() {
+                return true, nil
+            }
+        }
+    }
+
+    return false, nil
+}
+
+// 创建用户组
+// 返回值：nil
+func (cc *RestrainedTransferCC) createGroup(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'createGroup', args: ['groupname', 'extras']}"`)
+    }
+
+    groupname := strings.TrimSpace(args[0])
+    extras := args[1]
+
+    if len(groupname) == 0 {
+        return shim.Error("groupname should not be empty.")
+    }
+
+    var err error
+
+    if err = checkCallerRole(stub, ROLE_ADMIN); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    group_info_key, err := stub.CreateCompositeKey("g_i:", []string{groupname})
+    if err != nil {
+        return shim.Error("groupname is not valid. " + err.Error())
+    }
+
+    groupAsBytes, err := stub.GetState(group_info_key)
+    if err != nil {
+        return shim.Error("Failed to get state. " + err.Error())
+    }
+    if groupAsBytes != nil {
+        return shim.Error("groupname " + groupname + " already created.")
+    }
+
+    groupAsBytes, err = json.Marshal(MAP{
+        "name": groupname,
+        "extras": extras,
+    })
+    if err != nil {
+        return shim.Error("Failed to format group info. " + err.Error())
+    }
+
+    err = stub.PutState(group_info_key, groupAsBytes)
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询用户组信息
+// 返回值：json字符串
+// {
+//     "name":"group_a",
+//     "extras":"balabala"
+// }
+func (cc *RestrainedTransferCC) getGroupInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getGroupInfo', args: ['groupname']}"`)
+    }
+
+    groupname := strings.TrimSpace(args[0])
+
+    group_info_key, err := stub.CreateCompositeKey("g_i:", []string{groupname})
+    if err != nil {
+        return shim.Error("groupname is not valid. " + err.Error())
+    }
+
+    groupAsBytes, err := stub.GetState(group_info_key)
+    if err != nil {
+        return shim.Error("Failed to get state. " + err.Error())
+    }
+    if groupAsBytes == nil {
+        return shim.Error("groupname " + groupname + " is not created.")
+    }
+
+    return shim.Success(groupAsBytes)
+}
+
+// 将用户加入用户组
+// 返回值：nil
+func (cc *RestrainedTransferCC) addGroupMember(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'addGroupMember', args: ['groupname', 'username']}"`)
+    }
+
+    groupname := strings.TrimSpace(args[0])
+    username := strings.TrimSpace(args[1])
+
+    var err error
+
+    if err = checkCallerRole(stub, ROLE_ADMIN); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkGroupCreated(stub, "groupname", groupname); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkRegistered(stub, "username", username); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    member_key, err := stub.CreateCompositeKey("g_m:", []string{groupname, username})
+    if err != nil {
+        return shim.Error("groupname or username is not valid. " + err.Error())
+    }
+
+    user_group_key, err := stub.CreateCompositeKey("u_g:", []string{username, groupname})
+    if err != nil {
+        return shim.Error("groupname or username is not valid. " + err.Error())
+    }
+
+    err = stub.PutState(member_key, []byte("1"))
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    err = stub.PutState(user_group_key, []byte("1"))
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 将用户移出用户组
+// 返回值：nil
+func (cc *RestrainedTransferCC) removeGroupMember(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'removeGroupMember', args: ['groupname', 'username']}"`)
+    }
+
+    groupname := strings.TrimSpace(args[0])
+    username := strings.TrimSpace(args[1])
+
+    var err error
+
+    if err = checkCallerRole(stub, ROLE_ADMIN); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkGroupCreated(stub, "groupname", groupname); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    member_key, err := stub.CreateCompositeKey("g_m:", []string{groupname, username})
+    if err != nil {
+        return shim.Error("groupname or username is not valid. " + err.Error())
+    }
+
+    user_group_key, err := stub.CreateCompositeKey("u_g:", []string{username, groupname})
+    if err != nil {
+        return shim.Error("groupname or username is not valid. " + err.Error())
+    }
+
+    memberAsBytes, err := stub.GetState(member_key)
+    if err != nil {
+        return shim.Error("Failed to get state. " + err.Error())
+    }
+    if memberAsBytes == nil {
+        return shim.Error("username " + username + " is not a member of " + groupname + ".")
+    }
+
+    err = stub.DelState(member_key)
+    if err != nil {
+        return shim.Error("Failed to del state. " + err.Error())
+    }
+
+    err = stub.DelState(user_group_key)
+    if err != nil {
+        return shim.Error("Failed to del state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询用户组的所有成员
+// 返回值：json字符串
+// ["user_a","user_b"]
+func (cc *RestrainedTransferCC) getGroupMembers(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getGroupMembers', args: ['groupname']}"`)
+    }
+
+    groupname := strings.TrimSpace(args[0])
+
+    var err error
+
+    if err = checkGroupCreated(stub, "groupname", groupname); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    members, err := listGroupMembers(stub, groupname)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    membersAsBytes, err := json.Marshal(members)
+    if err != nil {
+        return shim.Error("Failed to format group members. " + err.Error())
+    }
+
+    return shim.Success(membersAsBytes)
+}
+
+// 查询用户所在的所有组
+// 返回值：json字符串
+// ["group_a","group_b"]
+func (cc *RestrainedTransferCC) getGroupsOfUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getGroupsOfUser', args: ['username']}"`)
+    }
+
+    username := strings.TrimSpace(args[0])
+
+    var err error
+
+    if err = checkRegistered(stub, "username", username); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    groups, err := listGroupsOfUser(stub, username)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    groupsAsBytes, err := json.Marshal(groups)
+    if err != nil {
+        return shim.Error("Failed to format user groups. " + err.Error())
+    }
+
+    return shim.Success(groupsAsBytes)
+}
+
+// 设置两个用户组之间的转账约束
+// 枚举值的含义同 setRestraint，a b 分别指 groupname_a 和 groupname_b 的成员
+// groupname_a 和 groupname_b 相同时表示组内成员之间的约束，只能是 0 或 3
+// 返回值：nil
+func (cc *RestrainedTransferCC) setGroupRestraint(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 3 {
+        return shim.Error(`parameter error. usage: "{fcn: 'setGroupRestraint', args: ['groupname_a', 'groupname_b', 'restraint_type']}"`)
+    }
+
+    groupname_a := strings.TrimSpace(args[0])
+    groupname_b := strings.TrimSpace(args[1])
+    restraint_str := strings.TrimSpace(args[2])
+
+    if len(restraint_str) != 1 || !RestraintType(restraint_str[0]).isValid() {
+        return shim.Error("transfer restraint type got " + restraint_str + ", expected one of [0, 1, 2, 3], respectively [forbid transfer, allow a to b, allow b to a, allow two-way]")
+    }
+
+    restraint := RestraintType(restraint_str[0])
+
+    if groupname_a == groupname_b && restraint != NONWAY && restraint != TWOWAY {
+        return shim.Error("restraint inside group " + groupname_a + " must be one of [0, 3]")
+    }
+
+    var err error
+
+    if err = checkCallerRole(stub, ROLE_ADMIN); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkGroupCreated(stub, "groupname_a", groupname_a); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkGroupCreated(stub, "groupname_b", groupname_b); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    err = putGroupRestraint(stub, SUBJECT_GROUP, groupname_a, SUBJECT_GROUP, groupname_b, restraint)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 设置用户与用户组之间的转账约束
+// 枚举值的含义同 setRestraint，a 指 username，b 指 groupname 的成员
+// 返回值：nil
+func (cc *RestrainedTransferCC) setUserGroupRestraint(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 3 {
+        return shim.Error(`parameter error. usage: "{fcn: 'setUserGroupRestraint', args: ['username', 'groupname', 'restraint_type']}"`)
+    }
+
+    username := strings.TrimSpace(args[0])
+    groupname := strings.TrimSpace(args[1])
+    restraint_str := strings.TrimSpace(args[2])
+
+    if len(restraint_str) != 1 || !RestraintType(restraint_str[0]).isValid() {
+        return shim.Error("transfer restraint type got " + restraint_str + ", expected one of [0, 1, 2, 3], respectively [forbid transfer, allow a to b, allow b to a, allow two-way]")
+    }
+
+    restraint := RestraintType(restraint_str[0])
+
+    var err error
+
+    if err = checkCallerRole(stub, ROLE_ADMIN); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkRegistered(stub, "username", username); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkGroupCreated(stub, "groupname", groupname); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    err = putGroupRestraint(stub, SUBJECT_USER, username, SUBJECT_GROUP, groupname, restraint)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询用户组的所有组约束
+// 枚举值的含义见 setRestraint，以 groupname 为 a
+// 返回值：json字符串
+// {
+//     "groups":{"group_x":"1"},
+//     "users":{"user_y":"2"}
+// }
+func (cc *RestrainedTransferCC) getRestraintsOfGroup(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getRestraintsOfGroup', args: ['groupname']}"`)
+    }
+
+    groupname := strings.TrimSpace(args[0])
+
+    var err error
+
+    if err = checkGroupCreated(stub, "groupname", groupname); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    itr, err := stub.GetStateByPartialCompositeKey("g_r:", []string{SUBJECT_GROUP, groupname})
+    if err != nil {
+        return shim.Error("Failed to get state. " + err.Error())
+    }
+    defer itr.Close()
+
+    groupRestraints := MAP{}
+    userRestraints := MAP{}
+
+    for itr.HasNext() {
+        kv, err := itr.Next()
+        if err != nil {
+            return shim.Error("Failed to get restraint stored. " + err.Error())
+        }
+        _, compositeKeyParts, err := stub.SplitCompositeKey(kv.Key)
+        if err != nil {
+            return shim.Error("Failed to parse restraint stored. " + err.Error())
+        }
+        if compositeKeyParts[2] == SUBJECT_GROUP {
+            groupRestraints[compositeKeyParts[3]] = string(kv.Value)
+        } else {
+            userRestraints[compositeKeyParts[3]] = string(kv.Value)
+        }
+    }
+
+    restraintsAsBytes, err := json.Marshal(MAP{
+        "groups": groupRestraints,
+        "users": userRestraints,
+    })
+    if err != nil {
+        return shim.Error("Failed to format group restraints. " + err.Error())
+    }
+
+    return shim.Success(restraintsAsBytes)
+}
+
+// 查询从 username_a 到 username_b 实际生效的转账限制，即同时考虑 u_r: 约束和组约束
+// 返回值：字符串 "0" 或 "1" 或 "2" 或 "3"，含义见 setRestraint
+func (cc *RestrainedTransferCC) getEffectiveRestraint(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getEffectiveRestraint', args: ['username_a', 'username_b']}"`)
+    }
+
+    username_a := strings.TrimSpace(args[0])
+    username_b := strings.TrimSpace(args[1])
+
+    var err error
+
+    if err = checkRegistered(stub, "username_a", username_a); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkRegistered(stub, "username_b", username_b); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    ab_allowed, err := transferAllowed(stub, username_a, username_b)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    ba_allowed, err := transferAllowed(stub, username_b, username_a)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    restraint := NONWAY
+    if ab_allowed {
+        restraint += ONEWAY - NONWAY
+    }
+    if ba_allowed {
+        restraint += ONEWAY_R - NONWAY
+    }
+
+    return shim.Success([]byte{byte(restraint)})
+}
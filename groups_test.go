@@ -0,0 +1,102 @@
+package main
+
+import (
+    "testing"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestGroups(t *testing.T) {
+    stub := shim.NewMockStub("TestGroups", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "user_a", "company A")
+    testRegister(t, stub, "user_b", "company B")
+    testRegister(t, stub, "user_c", "company B")
+    testRegister(t, stub, "user_d", "company D")
+
+    // 组管理只有 admin 可以调用
+    testInvokeAsFail(t, stub, "bob", "createGroup", "dept_a", "department A")
+    testInvokeFail(t, stub, "createGroup", "", "empty groupname")
+    testInvoke(t, stub, "", "createGroup", "dept_a", "department A")
+    testInvokeFail(t, stub, "createGroup", "dept_a", "department A")
+    testInvoke(t, stub, "", "createGroup", "dept_b", "department B")
+
+    testInvoke(t, stub, `{"extras":"department A","name":"dept_a"}`, "getGroupInfo", "dept_a")
+    testInvokeFail(t, stub, "getGroupInfo", "dept_x")
+
+    testInvoke(t, stub, "", "addGroupMember", "dept_a", "user_a")
+    testInvoke(t, stub, "", "addGroupMember", "dept_b", "user_b")
+    testInvoke(t, stub, "", "addGroupMember", "dept_b", "user_c")
+    testInvokeFail(t, stub, "addGroupMember", "dept_x", "user_a")
+    testInvokeAsFail(t, stub, "bob", "addGroupMember", "dept_a", "user_d")
+    testInvokeAsFail(t, stub, "bob", "removeGroupMember", "dept_a", "user_a")
+    testInvokeFail(t, stub, "addGroupMember", "dept_a", "user_x")
+
+    testInvoke(t, stub, `["user_b","user_c"]`, "getGroupMembers", "dept_b")
+    testInvoke(t, stub, `["dept_a"]`, "getGroupsOfUser", "user_a")
+    testInvoke(t, stub, `[]`, "getGroupsOfUser", "user_d")
+
+    testInvoke(t, stub, "", "recharge", "user_a", "1000")
+    testInvoke(t, stub, "", "recharge", "user_b", "1000")
+
+    testTransferFail(t, stub, "user_a", "user_b", "100")
+
+    testInvokeFail(t, stub, "setGroupRestraint", "dept_a", "dept_b", "4")
+    testInvokeFail(t, stub, "setGroupRestraint", "dept_a", "dept_x", "1")
+    testInvokeFail(t, stub, "setGroupRestraint", "dept_a", "dept_a", "1")
+    testInvokeAsFail(t, stub, "bob", "setGroupRestraint", "dept_a", "dept_b", "1")
+    testInvoke(t, stub, "", "setGroupRestraint", "dept_a", "dept_b", "1")
+
+    testInvoke(t, stub, `{"groups":{"dept_b":"1"},"users":{}}`, "getRestraintsOfGroup", "dept_a")
+    testInvoke(t, stub, `{"groups":{"dept_a":"2"},"users":{}}`, "getRestraintsOfGroup", "dept_b")
+
+    testInvoke(t, stub, "1", "getEffectiveRestraint", "user_a", "user_c")
+    testInvoke(t, stub, "2", "getEffectiveRestraint", "user_b", "user_a")
+    testGetRestraintBetweenUsers(t, stub, "user_a", "user_c", "0")
+
+    testTransfer(t, stub, "user_a", "user_b", "100")
+    testTransfer(t, stub, "user_a", "user_c", "100")
+    testTransferFail(t, stub, "user_b", "user_a", "100")
+    testTransferFail(t, stub, "user_b", "user_c", "100")
+
+    // 显式的 u_r: 约束优先于组约束
+    testSetRestraint(t, stub, "user_a", "user_b", "2")
+    testTransferFail(t, stub, "user_a", "user_b", "100")
+    testTransfer(t, stub, "user_b", "user_a", "100")
+    testInvoke(t, stub, "2", "getEffectiveRestraint", "user_a", "user_b")
+
+    // 显式的 0 同样优先于组约束，clearRestraint 删除后按组约束判断
+    testSetRestraint(t, stub, "user_a", "user_b", "0")
+    testTransferFail(t, stub, "user_a", "user_b", "100")
+    testInvoke(t, stub, "0", "getEffectiveRestraint", "user_a", "user_b")
+    testInvoke(t, stub, "{}", "getRestraintsOfUser", "user_b")
+    testInvokeFail(t, stub, "clearRestraint", "user_a", "user_x")
+    testInvoke(t, stub, "", "clearRestraint", "user_a", "user_b")
+    testTransfer(t, stub, "user_a", "user_b", "100")
+    testInvoke(t, stub, "", "clearRestraint", "user_a", "user_b")
+
+    // 组内互转
+    testTransferFail(t, stub, "user_b", "user_c", "100")
+    testInvoke(t, stub, "", "setGroupRestraint", "dept_b", "dept_b", "3")
+    testTransfer(t, stub, "user_b", "user_c", "100")
+    testInvoke(t, stub, "3", "getEffectiveRestraint", "user_c", "user_b")
+
+    // 用户到组
+    testRecharge(t, stub, "user_d", "1")
+    testTransferFail(t, stub, "user_d", "user_b", "1")
+    testInvokeFail(t, stub, "setUserGroupRestraint", "user_x", "dept_b", "1")
+    testInvokeAsFail(t, stub, "bob", "setUserGroupRestraint", "user_d", "dept_b", "1")
+    testInvoke(t, stub, "", "setUserGroupRestraint", "user_d", "dept_b", "1")
+    testInvoke(t, stub, `{"groups":{"dept_a":"2","dept_b":"3"},"users":{"user_d":"2"}}`, "getRestraintsOfGroup", "dept_b")
+    testTransfer(t, stub, "user_d", "user_b", "1")
+    testTransferFail(t, stub, "user_b", "user_d", "1")
+
+    testInvoke(t, stub, "", "removeGroupMember", "dept_b", "user_c")
+    testInvokeFail(t, stub, "removeGroupMember", "dept_b", "user_c")
+    testInvoke(t, stub, `["user_b"]`, "getGroupMembers", "dept_b")
+    testTransferFail(t, stub, "user_a", "user_c", "100")
+
+    testInvoke(t, stub, "", "setGroupRestraint", "dept_a", "dept_b", "0")
+    testTransferFail(t, stub, "user_a", "user_b", "100")
+    testInvoke(t, stub, `{"groups":{},"users":{}}`, "getRestraintsOfGroup", "dept_a")
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+
+    "github.com/shopspring/decimal"
+)
+
+// 哈希时间锁定转账（HTLC），用于和其他账本之间的原子交换
+// lockTransfer 按 transfer 的规则检查后从 from 扣款，锁定在 h_l: 下，key 为 hashlock（preimage 的 sha256 十六进制字符串）。
+// timeout 之前任何人提供 preimage 都可以调用 claim 把锁定的金额转给 to，preimage 通过 htlcClaimed 事件公开，
+// 对方账本上的交易可以用同一个 preimage 完成；timeout 之后 from 的锁定金额可以通过 refund 退回。
+// claim 和 refund 时再次检查双方是否被冻结并进行制裁筛查。
+// 锁定、领取和退回都记录转账记录（见 journal.go），锁定中的金额记在托管账户 htlc:<hashlock> 下：
+// lockTransfer 记录 from 到托管账户，claim 记录托管账户到 to，refund 记录托管账户到 from。
+// 托管账户不是注册的用户，这些记录不能冲正。
+
+const (
+    HTLC_LOCKED   = "locked"
+    HTLC_CLAIMED  = "claimed"
+    HTLC_REFUNDED = "refunded"
+)
+
+// claim 成功时发出的事件名
+const HTLC_CLAIMED_EVENT = "htlcClaimed"
+
+// 转账记录中锁定金额的托管账户，用户名不能包含 ':'，不会和用户重复
+func htlcEscrowAccount(hashlock string) string {
+    return "htlc:" + hashlock
+}
+
+type HashedTimelock struct {
+    Hashlock string `json:"hashlock"`
+    From     string `json:"from"`
+    To       string `json:"to"`
+    Amount   string `json:"amount"`
+    Timeout  int64  `json:"timeout"`
+    Status   string `json:"status"`
+    Preimage string `json:"preimage"`
+}
+
+func getHashedTimelock(stub shim.ChaincodeStubInterface, hashlock string) (*HashedTimelock, error) {
+    lock_key, err := stub.CreateCompositeKey("h_l:", []string{hashlock})
+    if err != nil {
+        return nil, fmt.Errorf("hashlock is not valid. %s", err.Error())
+    }
+
+    lockAsBytes, err := stub.GetState(lock_key)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if lockAsBytes == nil {
+        return nil, nil
+    }
+
+    var lock HashedTimelock
+    err = json.Unmarshal(lockAsBytes, &lock)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to parse hashed timelock stored. %s", err.Error())
+    }
+
+    return &lock, nil
+}
+
+func putHashedTimelock(stub shim.ChaincodeStubInterface, lock *HashedTimelock) error {
+    lock_key, err := stub.CreateCompositeKey("h_l:", []string{lock.Hashlock})
+    if err != nil {
+        return fmt.Errorf("hashlock is not valid. %s", err.Error())
+    }
+
+    lockAsBytes, err := json.Marshal(lock)
+    if err != nil {
+        return fmt.Errorf("Failed to format hashed timelock. %s", err.Error())
+    }
+
+    err = stub.PutState(lock_key, lockAsBytes)
+    if err != nil {
+        return fmt.Errorf("Failed to put state. %s", err.Error())
+    }
+
+    return nil
+}
+
+// 把锁定的金额转入 username
+func releaseHashedTimelock(stub shim.ChaincodeStubInterface, lock *HashedTimelock, username string) error {
+    amount, err := decimal.NewFromString(lock.Amount)
+    if err != nil {
+        return fmt.Errorf("Failed to parse hashed timelock stored. %s", err.Error())
+    }
+
+    balance, err := getStoredBalance(stub, "username", username)
+    if err != nil {
+        return err
+    }
+
+    err = setBalance(stub, username, balance, balance.Add(amount))
+    if err != nil {
+        return err
+    }
+
+    _, err = recordTransfer(stub, htlcEscrowAccount(lock.Hashlock), username, amount, "")
+    if err != nil {
+        return err
+    }
+
+    return putHashedTimelock(stub, lock)
+}
+
+// 锁定转账，调用者必须是 from 的所有者
+// hashlock 为 preimage 的 sha256 十六进制字符串，不能和已有的锁定重复；timeout 为 unix 时间戳，必须晚于交易时间
+// 和 transfer 一样检查制裁名单、转账约束、冻结、风控限额和余额
+// 返回值：nil，被制裁名单拦截时返回值见 screening.go
+func (cc *RestrainedTransferCC) lockTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 5 {
+        return shim.Error(`parameter error. usage: "{fcn: 'lockTransfer', args: ['from', 'to', 'amount', 'hashlock', 'timeout']}"`)
+    }
+
+    from := strings.TrimSpace(args[0])
+    to := strings.TrimSpace(args[1])
+    amount_str := strings.TrimSpace(args[2])
+    hashlock := strings.ToLower(strings.TrimSpace(args[3]))
+    timeout_str := strings.TrimSpace(args[4])
+
+    if from == to {
+        return shim.Error("from and to must not be equal")
+    }
+
+    var err error
+
+    amount, err := validateAmount(stub, DEFAULT_ASSET, "amount", amount_str)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if hashBytes, err := hex.DecodeString(hashlock); err != nil || len(hashBytes) != sha256.Size {
+        return shim.Error("hashlock got " + hashlock + ", expected a sha256 hex string")
+    }
+
+    timeout, err := strconv.ParseInt(timeout_str, 10, 64)
+    if err != nil {
+        return shim.Error("timeout got " + timeout_str + ", expected a unix timestamp")
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if timeout <= now.Unix() {
+        return shim.Error("timeout should be later than the transaction time.")
+    }
+
+    existing, err := getHashedTimelock(stub, hashlock)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if existing != nil {
+        return shim.Error("hashed timelock " + hashlock + " already exists.")
+    }
+
+    if err = checkRegistered(stub, "from", from); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkCallerOwns(stub, from); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    check, err := checkTransfer(stub, from, to, amount)
+    if err != nil {
+        return screeningErrorResponse(err)
+    }
+
+    err = setBalance(stub, from, check.BalanceA, check.BalanceA.Sub(amount))
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    err = check.Velocity.commit(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    err = check.Kyc.commit(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    _, err = recordTransfer(stub, from, htlcEscrowAccount(hashlock), amount, "")
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    err = putHashedTimelock(stub, &HashedTimelock{
+        Hashlock: hashlock,
+        From: from,
+        To: to,
+        Amount: amount.String(),
+        Timeout: timeout,
+        Status: HTLC_LOCKED,
+    })
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 提供 preimage 领取锁定的金额，任何人都可以在 timeout 之前调用，金额转给 to
+// 成功时发出 htlcClaimed 事件，内容同返回值
+// 返回值：json字符串
+// {"hashlock":"...","from":"user_a","to":"user_b","amount":"100","timeout":1537779600,"status":"claimed","preimage":"secret"}
+func (cc *RestrainedTransferCC) claim(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'claim', args: ['preimage']}"`)
+    }
+
+    preimage := args[0]
+
+    sum := sha256.Sum256([]byte(preimage))
+    hashlock := hex.EncodeToString(sum[:])
+
+    lock, err := getHashedTimelock(stub, hashlock)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if lock == nil {
+        return shim.Error("hashed timelock " + hashlock + " does not exist.")
+    }
+    if lock.Status != HTLC_LOCKED {
+        return shim.Error("hashed timelock " + hashlock + " is " + lock.Status + ".")
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if now.Unix() >= lock.Timeout {
+        return shim.Error("hashed timelock " + hashlock + " has timed out.")
+    }
+
+    if err = checkNotFrozen(stub, lock.From); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkNotFrozen(stub, lock.To); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = screen(stub, "transfer", lock.Amount, lock.From, lock.To); err != nil {
+        return screeningErrorResponse(err)
+    }
+
+    lock.Status = HTLC_CLAIMED
+    lock.Preimage = preimage
+
+    err = releaseHashedTimelock(stub, lock, lock.To)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    lockAsBytes, err := json.Marshal(lock)
+    if err != nil {
+        return shim.Error("Failed to format hashed timelock. " + err.Error())
+    }
+
+    err = stub.SetEvent(HTLC_CLAIMED_EVENT, lockAsBytes)
+    if err != nil {
+        return shim.Error("Failed to set event. " + err.Error())
+    }
+
+    return shim.Success(lockAsBytes)
+}
+
+// timeout 之后把锁定的金额退回 from，任何人都可以调用，和 claim 一样检查冻结和制裁名单
+// 返回值：nil，被制裁名单拦截时返回值见 screening.go
+func (cc *RestrainedTransferCC) refund(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'refund', args: ['hashlock']}"`)
+    }
+
+    hashlock := strings.ToLower(strings.TrimSpace(args[0]))
+
+    lock, err := getHashedTimelock(stub, hashlock)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if lock == nil {
+        return shim.Error("hashed timelock " + hashlock + " does not exist.")
+    }
+    if lock.Status != HTLC_LOCKED {
+        return shim.Error("hashed timelock " + hashlock + " is " + lock.Status + ".")
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if now.Unix() < lock.Timeout {
+        return shim.Error(fmt.Sprintf("hashed timelock %s can not be refunded before %d.", hashlock, lock.Timeout))
+    }
+
+    if err = checkNotFrozen(stub, lock.From); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkNotFrozen(stub, lock.To); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = screen(stub, "transfer", lock.Amount, lock.From, lock.To); err != nil {
+        return screeningErrorResponse(err)
+    }
+
+    lock.Status = HTLC_REFUNDED
+
+    err = releaseHashedTimelock(stub, lock, lock.From)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询锁定转账
+// 返回值：json字符串，格式同 claim 的返回值，领取之前 preimage 为 ""
+func (cc *RestrainedTransferCC) getHashedTimelock(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getHashedTimelock', args: ['hashlock']}"`)
+    }
+
+    hashlock := strings.ToLower(strings.TrimSpace(args[0]))
+
+    lock, err := getHashedTimelock(stub, hashlock)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if lock == nil {
+        return shim.Error("hashed timelock " + hashlock + " does not exist.")
+    }
+
+    lockAsBytes, err := json.Marshal(lock)
+    if err != nil {
+        return shim.Error("Failed to format hashed timelock. " + err.Error())
+    }
+
+    return shim.Success(lockAsBytes)
+}
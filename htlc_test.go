@@ -0,0 +1,92 @@
+package main
+
+import (
+    "testing"
+    "crypto/sha256"
+    "encoding/hex"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestHashedTimelock(t *testing.T) {
+    stub := shim.NewMockStub("TestHashedTimelock", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "user_a", "")
+    testRegister(t, stub, "user_b", "")
+    testRegister(t, stub, "user_c", "")
+    testSetRestraint(t, stub, "user_a", "user_b", "1")
+    testRecharge(t, stub, "user_a", "100")
+
+    sum := sha256.Sum256([]byte("secret"))
+    hashlock := hex.EncodeToString(sum[:])
+    sum = sha256.Sum256([]byte("other"))
+    hashlock_2 := hex.EncodeToString(sum[:])
+
+    day := int64(1537776000)
+
+    testInvokeAtFail(t, stub, "", day, "lockTransfer", "user_a", "user_b", "60", "not a hash", "1537779600")
+    testInvokeAtFail(t, stub, "", day, "lockTransfer", "user_a", "user_b", "60", hashlock, "1537776000")
+    testInvokeAtFail(t, stub, "", day, "lockTransfer", "user_a", "user_b", "101", hashlock, "1537779600")
+    testInvokeAtFail(t, stub, "", day, "lockTransfer", "user_a", "user_c", "60", hashlock, "1537779600")
+    testInvokeAtFail(t, stub, "bob", day, "lockTransfer", "user_a", "user_b", "60", hashlock, "1537779600")
+    testInvokeAt(t, stub, "", day, "", "lockTransfer", "user_a", "user_b", "60", hashlock, "1537779600")
+    testInvokeAtFail(t, stub, "", day, "lockTransfer", "user_a", "user_b", "10", hashlock, "1537779600")
+    testGetBalance(t, stub, "user_a", "40")
+
+    testInvokeAtFail(t, stub, "", day + 60, "refund", hashlock)
+    testInvokeAtFail(t, stub, "", day + 60, "claim", "wrong")
+    testInvokeAtFail(t, stub, "", day + 3600, "claim", "secret")
+
+    // claim 时双方被冻结或被筛查拦截都不能领取
+    testInvoke(t, stub, "", "freezeAccount", "user_a")
+    testInvokeAtFail(t, stub, "bob", day + 60, "claim", "secret")
+    testInvoke(t, stub, "", "unfreezeAccount", "user_a")
+    testInvoke(t, stub, "", "freezeAccount", "user_b")
+    testInvokeAtFail(t, stub, "bob", day + 60, "claim", "secret")
+    testInvoke(t, stub, "", "unfreezeAccount", "user_b")
+    testInvoke(t, stub, "", "grantRole", "compliance", mockCallerId)
+    testInvoke(t, stub, "", "addScreeningEntry", "user", "user_b", "sanctioned")
+    testInvokeAt(t, stub, "", day + 60, `{"audit_id":"1","blocked":true,"reason":"user user_b is on the screening list."}`, "claim", "secret")
+    testGetBalance(t, stub, "user_b", "0")
+    testInvoke(t, stub, "", "removeScreeningEntry", "user", "user_b")
+
+    claimed := `{"hashlock":"` + hashlock + `","from":"user_a","to":"user_b","amount":"60","timeout":1537779600,"status":"claimed","preimage":"secret"}`
+    testInvokeAt(t, stub, "bob", day + 60, claimed, "claim", "secret")
+    event := <-stub.ChaincodeEventsChannel
+    if event.EventName != "htlcClaimed" || string(event.Payload) != claimed {
+        t.Fatalf("claim set event %s %s", event.EventName, string(event.Payload))
+    }
+    testGetBalance(t, stub, "user_b", "60")
+    // 锁定和领取都经过托管账户
+    escrow := "htlc:" + hashlock
+    testInvoke(t, stub, `{"id":"1","from":"user_a","to":"` + escrow + `","amount":"60","timestamp":1537776000,"reversal_of":"","reversed_by":""}`, "getTransfer", "1")
+    testInvoke(t, stub, `{"id":"1.1","from":"` + escrow + `","to":"user_b","amount":"60","timestamp":1537776060,"reversal_of":"","reversed_by":""}`, "getTransfer", "1.1")
+    testInvoke(t, stub, claimed, "getHashedTimelock", hashlock)
+    testInvokeAtFail(t, stub, "", day + 60, "claim", "secret")
+    testInvokeAtFail(t, stub, "", day + 3600, "refund", hashlock)
+
+    // 超时后退回
+    testInvokeAt(t, stub, "", day, "", "lockTransfer", "user_a", "user_b", "40", hashlock_2, "1537779600")
+    testGetBalance(t, stub, "user_a", "0")
+
+    // refund 时同样检查冻结和制裁名单
+    testInvoke(t, stub, "", "freezeAccount", "user_a")
+    testInvokeAtFail(t, stub, "bob", day + 3600, "refund", hashlock_2)
+    testInvoke(t, stub, "", "unfreezeAccount", "user_a")
+    testInvoke(t, stub, "", "freezeAccount", "user_b")
+    testInvokeAtFail(t, stub, "bob", day + 3600, "refund", hashlock_2)
+    testInvoke(t, stub, "", "unfreezeAccount", "user_b")
+    testInvoke(t, stub, "", "addScreeningEntry", "user", "user_a", "sanctioned")
+    testInvokeAt(t, stub, "", day + 3600, `{"audit_id":"1.1","blocked":true,"reason":"user user_a is on the screening list."}`, "refund", hashlock_2)
+    testGetBalance(t, stub, "user_a", "0")
+    testInvoke(t, stub, "", "removeScreeningEntry", "user", "user_a")
+
+    testInvokeAt(t, stub, "bob", day + 3600, "", "refund", hashlock_2)
+    testGetBalance(t, stub, "user_a", "40")
+    escrow_2 := "htlc:" + hashlock_2
+    testInvoke(t, stub, `{"id":"1.2","from":"user_a","to":"` + escrow_2 + `","amount":"40","timestamp":1537776000,"reversal_of":"","reversed_by":""}`, "getTransfer", "1.2")
+    testInvoke(t, stub, `{"id":"1.3","from":"` + escrow_2 + `","to":"user_a","amount":"40","timestamp":1537779600,"reversal_of":"","reversed_by":""}`, "getTransfer", "1.3")
+    testGetBalance(t, stub, "user_b", "60")
+    testInvokeAtFail(t, stub, "", day + 60, "claim", "other")
+    testInvokeFail(t, stub, "getHashedTimelock", hashlock_2[:10])
+}
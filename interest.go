@@ -0,0 +1,538 @@
+package main
+
+import (
+    "fmt"
+    "time"
+    "strings"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+
+    "github.com/shopspring/decimal"
+)
+
+// 计息
+// 计息产品存储在 i_p: 下，创建后不能修改：
+// rate            年利率，如 0.035
+// compounding     复利周期，none（单利）、daily、monthly、annually，周期按 UTC 的自然日、自然月、自然年划分
+// day_count       计息基准，ACT/365 或 ACT/360，按实际经过的秒数计算天数
+// funding_account 支付利息的账户
+// 账户的计息信息存储在 i_a: 下，记录使用的产品、已计提未发放的利息 accrued 和上次计提的时间 last_accrual。
+// 计提是惰性的：账户余额变化时（见 setBalance），按变化前的余额把利息计提到交易时间；
+// 调用 accrueInterest 时把 accrued 从 funding_account 转入账户，按 default 资产的 max_scale（见 precision.go）截断，余下的部分继续保留在 accrued 中；
+// 发放和转账一样检查双方是否被冻结并进行制裁筛查。funding_account 自己不能计息。
+// 复利时，每个复利周期结束时已计提的利息也开始计息。余额为负（透支）时不计息。
+
+// 利息计算保留的小数位数
+const INTEREST_PRECISION = 18
+
+type InterestProduct struct {
+    Name           string `json:"name"`
+    Rate           string `json:"rate"`
+    Compounding    string `json:"compounding"`
+    DayCount       string `json:"day_count"`
+    FundingAccount string `json:"funding_account"`
+}
+
+type InterestAccount struct {
+    Product     string `json:"product"`
+    Accrued     string `json:"accrued"`
+    LastAccrual int64  `json:"last_accrual"`
+}
+
+func getInterestProduct(stub shim.ChaincodeStubInterface, name string) (*InterestProduct, error) {
+    product_key, err := stub.CreateCompositeKey("i_p:", []string{name})
+    if err != nil {
+        return nil, fmt.Errorf("product is not valid. %s", err.Error())
+    }
+
+    productAsBytes, err := stub.GetState(product_key)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if productAsBytes == nil {
+        return nil, nil
+    }
+
+    var product InterestProduct
+    err = json.Unmarshal(productAsBytes, &product)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to parse interest product stored. %s", err.Error())
+    }
+
+    return &product, nil
+}
+
+func getInterestAccount(stub shim.ChaincodeStubInterface, username string) (*InterestAccount, error) {
+    account_key, err := stub.CreateCompositeKey("i_a:", []string{username})
+    if err != nil {
+        return nil, fmt.Errorf("username is not valid. %s", err.Error())
+    }
+
+    accountAsBytes, err := stub.GetState(account_key)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if accountAsBytes == nil {
+        return nil, nil
+    }
+
+    var account InterestAccount
+    err = json.Unmarshal(accountAsBytes, &account)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to parse interest account stored. %s", err.Error())
+    }
+
+    return &account, nil
+}
+
+func putInterestAccount(stub shim.ChaincodeStubInterface, username string, account *InterestAccount) error {
+    account_key, err := stub.CreateCompositeKey("i_a:", []string{username})
+    if err != nil {
+        return fmt.Errorf("username is not valid. %s", err.Error())
+    }
+
+    if account == nil {
+        err = stub.DelState(account_key)
+        if err != nil {
+            return fmt.Errorf("Failed to del state. %s", err.Error())
+        }
+        return nil
+    }
+
+    accountAsBytes, err := json.Marshal(account)
+    if err != nil {
+        return fmt.Errorf("Failed to format interest account. %s", err.Error())
+    }
+
+    err = stub.PutState(account_key, accountAsBytes)
+    if err != nil {
+        return fmt.Errorf("Failed to put state. %s", err.Error())
+    }
+
+    return nil
+}
+
+// t 之后的下一个复利周期的开始时间
+func nextCompoundingBoundary(t time.Time, compounding string) time.Time {
+    y, m, d := t.Date()
+    switch compounding {
+    case "daily":
+        return time.Date(y, m, d + 1, 0, 0, 0, 0, time.UTC)
+    case "monthly":
+        return time.Date(y, m + 1, 1, 0, 0, 0, 0, time.UTC)
+    case "annually":
+        return time.Date(y + 1, 1, 1, 0, 0, 0, 0, time.UTC)
+    }
+    return time.Time{}
+}
+
+// 计算余额为 balance 时，从 from 到 to 计提后的 accrued
+func computeAccrual(product *InterestProduct, balance, accrued decimal.Decimal, from, to time.Time) (decimal.Decimal, error) {
+    rate, err := decimal.NewFromString(product.Rate)
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("Failed to parse interest product stored. %s", err.Error())
+    }
+
+    seconds_per_year := decimal.New(365 * SECONDS_PER_DAY, 0)
+    if product.DayCount == "ACT/360" {
+        seconds_per_year = decimal.New(360 * SECONDS_PER_DAY, 0)
+    }
+
+    for t := from.UTC(); t.Before(to); {
+        next := to
+        if product.Compounding != "none" {
+            if boundary := nextCompoundingBoundary(t, product.Compounding); boundary.Before(to) {
+                next = boundary
+            }
+        }
+
+        base := balance
+        if product.Compounding != "none" {
+            base = base.Add(accrued)
+        }
+
+        if base.GreaterThan(decimal.Zero) {
+            seconds := decimal.New(next.Unix() - t.Unix(), 0)
+            interest := base.Mul(rate).Mul(seconds).DivRound(seconds_per_year, INTEREST_PRECISION)
+            accrued = accrued.Add(interest)
+        }
+
+        t = next
+    }
+
+    return accrued, nil
+}
+
+// 按余额 balance 把账户的利息计提到交易时间，返回计提后的计息信息，没有计息产品时返回 nil
+// 只计算，不写入
+func accrueInterestAccount(stub shim.ChaincodeStubInterface, username string, balance decimal.Decimal) (*InterestAccount, *InterestProduct, error) {
+    account, err := getInterestAccount(stub, username)
+    if err != nil || account == nil {
+        return nil, nil, err
+    }
+
+    product, err := getInterestProduct(stub, account.Product)
+    if err != nil {
+        return nil, nil, err
+    }
+    if product == nil {
+        return nil, nil, fmt.Errorf("interest product %s is not created.", account.Product)
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    accrued, err := decimal.NewFromString(account.Accrued)
+    if err != nil {
+        return nil, nil, fmt.Errorf("Failed to parse interest account stored. %s", err.Error())
+    }
+
+    if now.Unix() > account.LastAccrual {
+        accrued, err = computeAccrual(product, balance, accrued, time.Unix(account.LastAccrual, 0), time.Unix(now.Unix(), 0))
+        if err != nil {
+            return nil, nil, err
+        }
+        account.Accrued = accrued.String()
+        account.LastAccrual = now.Unix()
+    }
+
+    return account, product, nil
+}
+
+// 余额变化前按变化前的余额计提利息
+func accrueBeforeBalanceChange(stub shim.ChaincodeStubInterface, username string, old_balance decimal.Decimal) error {
+    account, _, err := accrueInterestAccount(stub, username, old_balance)
+    if err != nil || account == nil {
+        return err
+    }
+
+    return putInterestAccount(stub, username, account)
+}
+
+// 创建计息产品，只有 admin 可以调用
+// 参数含义见 interest.go
+// 返回值：nil
+func (cc *RestrainedTransferCC) createInterestProduct(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 5 {
+        return shim.Error(`parameter error. usage: "{fcn: 'createInterestProduct', args: ['product', 'rate', 'none or daily or monthly or annually', 'ACT/365 or ACT/360', 'funding_account']}"`)
+    }
+
+    name := strings.TrimSpace(args[0])
+    rate_str := strings.TrimSpace(args[1])
+    compounding := strings.TrimSpace(args[2])
+    day_count := strings.TrimSpace(args[3])
+    funding_account := strings.TrimSpace(args[4])
+
+    if len(name) == 0 {
+        return shim.Error("product should not be empty.")
+    }
+
+    var err error
+
+    rate, err := decimal.NewFromString(rate_str)
+    if err != nil || rate.LessThan(decimal.Zero) {
+        return shim.Error("Invalid interest rate, expecting a number not less than 0.")
+    }
+
+    if compounding != "none" && compounding != "daily" && compounding != "monthly" && compounding != "annually" {
+        return shim.Error("compounding got " + compounding + ", expected one of [none, daily, monthly, annually]")
+    }
+
+    if day_count != "ACT/365" && day_count != "ACT/360" {
+        return shim.Error("day_count got " + day_count + ", expected one of [ACT/365, ACT/360]")
+    }
+
+    if err = checkCallerRole(stub, ROLE_ADMIN); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkRegistered(stub, "funding_account", funding_account); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    product, err := getInterestProduct(stub, name)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if product != nil {
+        return shim.Error("product " + name + " already created.")
+    }
+
+    product_key, err := stub.CreateCompositeKey("i_p:", []string{name})
+    if err != nil {
+        return shim.Error("product is not valid. " + err.Error())
+    }
+
+    productAsBytes, err := json.Marshal(InterestProduct{name, rate.String(), compounding, day_count, funding_account})
+    if err != nil {
+        return shim.Error("Failed to format interest product. " + err.Error())
+    }
+
+    err = stub.PutState(product_key, productAsBytes)
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询计息产品
+// 返回值：json字符串
+// {"name":"savings","rate":"0.035","compounding":"monthly","day_count":"ACT/365","funding_account":"bank"}
+func (cc *RestrainedTransferCC) getInterestProduct(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getInterestProduct', args: ['product']}"`)
+    }
+
+    name := strings.TrimSpace(args[0])
+
+    product, err := getInterestProduct(stub, name)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if product == nil {
+        return shim.Error("product " + name + " is not created.")
+    }
+
+    productAsBytes, err := json.Marshal(product)
+    if err != nil {
+        return shim.Error("Failed to format interest product. " + err.Error())
+    }
+
+    return shim.Success(productAsBytes)
+}
+
+// 给账户设置计息产品，只有 admin 可以调用
+// product 为空表示取消计息，取消时未发放的利息作废
+// 更换产品时先按原产品计提到交易时间，已计提的利息保留
+// 返回值：nil
+func (cc *RestrainedTransferCC) assignInterestProduct(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'assignInterestProduct', args: ['username', 'product']}"`)
+    }
+
+    username := strings.TrimSpace(args[0])
+    name := strings.TrimSpace(args[1])
+
+    var err error
+
+    if err = checkCallerRole(stub, ROLE_ADMIN); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    balance, err := getStoredBalance(stub, "username", username)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if name == "" {
+        err = putInterestAccount(stub, username, nil)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        return shim.Success(nil)
+    }
+
+    product, err := getInterestProduct(stub, name)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if product == nil {
+        return shim.Error("product " + name + " is not created.")
+    }
+    if product.FundingAccount == username {
+        return shim.Error("username " + username + " is the funding account of product " + name + ".")
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    account, _, err := accrueInterestAccount(stub, username, balance)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if account == nil {
+        account = &InterestAccount{Accrued: "0", LastAccrual: now.Unix()}
+    }
+    account.Product = name
+
+    err = putInterestAccount(stub, username, account)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询账户的计息信息，accrued 为计提到交易时间的利息
+// 返回值：json字符串
+// {"product":"savings","accrued":"1.234567890123456789","last_accrual":1537776000}
+func (cc *RestrainedTransferCC) getInterestAccrual(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getInterestAccrual', args: ['username']}"`)
+    }
+
+    username := strings.TrimSpace(args[0])
+
+    balance, err := getStoredBalance(stub, "username", username)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    account, _, err := accrueInterestAccount(stub, username, balance)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if account == nil {
+        return shim.Error("username " + username + " has no interest product.")
+    }
+
+    accountAsBytes, err := json.Marshal(account)
+    if err != nil {
+        return shim.Error("Failed to format interest account. " + err.Error())
+    }
+
+    return shim.Success(accountAsBytes)
+}
+
+// 把账户的利息计提到交易时间，并从计息产品的 funding_account 转入账户，任何人都可以调用
+// 可以一次处理多个账户，funding_account 的可用余额（不含锁定的额度，加透支额度）不足或任何一方被冻结时整个交易失败，
+// 每个账户的利息作为一笔从 funding_account 的转账记录，见 journal.go
+// 被筛查拦截时返回拦截结果（见 screening.go），不发放任何账户的利息
+// 返回值：json字符串，每个账户发放的利息
+// {"user_a":"1.234567890123456789"}
+func (cc *RestrainedTransferCC) accrueInterest(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) < 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'accrueInterest', args: ['username', ...]}"`)
+    }
+
+    policy, err := getAssetPolicy(stub, DEFAULT_ASSET)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    type payout struct {
+        username string
+        account  *InterestAccount
+        product  *InterestProduct
+        interest decimal.Decimal
+    }
+
+    paid := MAP{}
+    payouts := []payout{}
+
+    // 先检查所有账户，筛查拦截时返回的是成功的响应，检查通过前不能修改状态
+    for _, arg := range args {
+        username := strings.TrimSpace(arg)
+
+        if _, ok := paid[username]; ok {
+            return shim.Error("username " + username + " is duplicated.")
+        }
+
+        balance, err := getStoredBalance(stub, "username", username)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+
+        account, product, err := accrueInterestAccount(stub, username, balance)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        if account == nil {
+            return shim.Error("username " + username + " has no interest product.")
+        }
+
+        if product.FundingAccount == username {
+            return shim.Error("username " + username + " is the funding account of product " + product.Name + ".")
+        }
+
+        accrued, err := decimal.NewFromString(account.Accrued)
+        if err != nil {
+            return shim.Error("Failed to parse interest account stored. " + err.Error())
+        }
+
+        // 按余额的小数位数发放，不足最小单位的部分保留在 accrued 中
+        interest := accrued.Truncate(policy.MaxScale)
+
+        paid[username] = interest.String()
+        account.Accrued = accrued.Sub(interest).String()
+
+        if !interest.Equal(decimal.Zero) {
+            if err = checkNotFrozen(stub, product.FundingAccount); err != nil {
+                return shim.Error(err.Error())
+            }
+
+            if err = checkNotFrozen(stub, username); err != nil {
+                return shim.Error(err.Error())
+            }
+
+            if err = screen(stub, "transfer", interest.String(), product.FundingAccount, username); err != nil {
+                return screeningErrorResponse(err)
+            }
+        }
+
+        payouts = append(payouts, payout{username, account, product, interest})
+    }
+
+    for _, p := range payouts {
+        if p.interest.Equal(decimal.Zero) {
+            err = putInterestAccount(stub, p.username, p.account)
+            if err != nil {
+                return shim.Error(err.Error())
+            }
+            continue
+        }
+
+        funding_balance, err := getStoredBalance(stub, "funding_account", p.product.FundingAccount)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+
+        spendable, err := getSpendableBalance(stub, p.product.FundingAccount, funding_balance)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+
+        if spendable.LessThan(p.interest) {
+            return shim.Error("Failed accrue interest, not enough balance in funding account " + p.product.FundingAccount + ".")
+        }
+
+        err = putInterestAccount(stub, p.username, p.account)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+
+        err = setBalance(stub, p.product.FundingAccount, funding_balance, funding_balance.Sub(p.interest))
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+
+        balance, err := getStoredBalance(stub, "username", p.username)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+
+        err = setBalance(stub, p.username, balance, balance.Add(p.interest))
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+
+        _, err = recordTransfer(stub, p.product.FundingAccount, p.username, p.interest, "")
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+    }
+
+    paidAsBytes, err := json.Marshal(paid)
+    if err != nil {
+        return shim.Error("Failed to format paid interest. " + err.Error())
+    }
+
+    return shim.Success(paidAsBytes)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+    "testing"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestInterest(t *testing.T) {
+    stub := shim.NewMockStub("TestInterest", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "bank", "")
+    testRegister(t, stub, "user_a", "")
+    testRegister(t, stub, "user_b", "")
+    testRegister(t, stub, "user_c", "")
+    testRecharge(t, stub, "bank", "100")
+
+    // 2018-09-24 00:00:00 UTC
+    day := int64(1537747200)
+
+    testInvokeFail(t, stub, "createInterestProduct", "simple", "abc", "none", "ACT/365", "bank")
+    testInvokeFail(t, stub, "createInterestProduct", "simple", "0.0365", "weekly", "ACT/365", "bank")
+    testInvokeFail(t, stub, "createInterestProduct", "simple", "0.0365", "none", "30/360", "bank")
+    testInvokeFail(t, stub, "createInterestProduct", "simple", "0.0365", "none", "ACT/365", "nobody")
+    testInvokeAsFail(t, stub, "bob", "createInterestProduct", "simple", "0.0365", "none", "ACT/365", "bank")
+    testInvoke(t, stub, "", "createInterestProduct", "simple", "0.0365", "none", "ACT/365", "bank")
+    testInvokeFail(t, stub, "createInterestProduct", "simple", "0.0365", "none", "ACT/365", "bank")
+    testInvoke(t, stub, "", "createInterestProduct", "daily", "0.365", "daily", "ACT/365", "bank")
+    testInvoke(t, stub, "", "createInterestProduct", "act360", "0.036", "monthly", "ACT/360", "bank")
+
+    testInvoke(t, stub, `{"name":"simple","rate":"0.0365","compounding":"none","day_count":"ACT/365","funding_account":"bank"}`, "getInterestProduct", "simple")
+    testInvokeFail(t, stub, "getInterestProduct", "nothing")
+
+    testInvokeFail(t, stub, "assignInterestProduct", "user_a", "nothing")
+    testInvokeAsFail(t, stub, "bob", "assignInterestProduct", "user_a", "simple")
+    testInvokeFail(t, stub, "assignInterestProduct", "bank", "simple")
+    testInvokeAt(t, stub, "", day, "", "assignInterestProduct", "user_a", "simple")
+    testInvokeAt(t, stub, "", day, "", "assignInterestProduct", "user_b", "daily")
+    testInvokeAt(t, stub, "", day, "", "assignInterestProduct", "user_c", "act360")
+    testInvokeFail(t, stub, "getInterestAccrual", "bank")
+
+    testInvokeAt(t, stub, "", day, "", "recharge", "user_a", "1000")
+    testInvokeAt(t, stub, "", day, "", "recharge", "user_b", "1000")
+    testInvokeAt(t, stub, "", day, "", "recharge", "user_c", "1000")
+
+    // 单利：1000 * 0.0365 * 10 / 365
+    testInvokeAt(t, stub, "", day + 10 * 86400, `{"product":"simple","accrued":"1","last_accrual":1538611200}`, "getInterestAccrual", "user_a")
+    testInvokeAt(t, stub, "", day + 10 * 86400, "", "withdraw", "user_a", "500")
+    testInvokeAt(t, stub, "", day + 20 * 86400, `{"product":"simple","accrued":"1.5","last_accrual":1539475200}`, "getInterestAccrual", "user_a")
+
+    // 按日复利：1000 * 0.365 / 365 + 1001 * 0.365 / 365
+    testInvokeAt(t, stub, "", day + 2 * 86400, `{"product":"daily","accrued":"2.001","last_accrual":1537920000}`, "getInterestAccrual", "user_b")
+
+    // ACT/360，同一个月内不复利：1000 * 0.036 * 5 / 360
+    testInvokeAt(t, stub, "", day + 5 * 86400, `{"product":"act360","accrued":"0.5","last_accrual":1538179200}`, "getInterestAccrual", "user_c")
+
+    testInvokeAt(t, stub, "", day + 20 * 86400, `{"user_a":"1.5"}`, "accrueInterest", "user_a")
+    testGetBalance(t, stub, "user_a", "501.5")
+    testGetBalance(t, stub, "bank", "98.5")
+    testInvoke(t, stub, `{"id":"1","from":"bank","to":"user_a","amount":"1.5","timestamp":1539475200,"reversal_of":"","reversed_by":""}`, "getTransfer", "1")
+    testInvokeAt(t, stub, "", day + 20 * 86400, `{"product":"simple","accrued":"0","last_accrual":1539475200}`, "getInterestAccrual", "user_a")
+
+    // 不足一秒的利息保留全部精度
+    testInvokeAt(t, stub, "", day + 20 * 86400 + 1, `{"product":"simple","accrued":"0.000000580439814815","last_accrual":1539475201}`, "getInterestAccrual", "user_a")
+
+    testInvokeFail(t, stub, "accrueInterest", "bank")
+    testInvokeAtFail(t, stub, "", day + 3650 * 86400, "accrueInterest", "user_b")
+
+    testInvokeAt(t, stub, "", day + 2 * 86400, `{"user_b":"2.001","user_c":"0.2"}`, "accrueInterest", "user_b", "user_c")
+    testGetBalance(t, stub, "bank", "96.299")
+
+    testInvokeAt(t, stub, "", day + 3 * 86400, "", "assignInterestProduct", "user_b", "")
+    testInvokeFail(t, stub, "getInterestAccrual", "user_b")
+
+    // 按 default 资产的小数位数发放，余下的利息继续计提
+    testInvoke(t, stub, "", "setAssetPolicy", "default", "2", "", "reject")
+    testInvokeAtFail(t, stub, "", day + 20 * 86400 + 86400, "accrueInterest", "user_a", "user_a")
+    testInvokeAt(t, stub, "", day + 20 * 86400 + 86400, `{"user_a":"0.05"}`, "accrueInterest", "user_a")
+    testInvokeAt(t, stub, "", day + 20 * 86400 + 86400, `{"product":"simple","accrued":"0.00015","last_accrual":1539561600}`, "getInterestAccrual", "user_a")
+    testGetBalance(t, stub, "user_a", "501.55")
+
+    // 冻结或被筛查拦截时不发放
+    testInvoke(t, stub, "", "freezeAccount", "user_c")
+    testInvokeAtFail(t, stub, "", day + 10 * 86400, "accrueInterest", "user_c")
+    testInvoke(t, stub, "", "unfreezeAccount", "user_c")
+    testInvoke(t, stub, "", "freezeAccount", "bank")
+    testInvokeAtFail(t, stub, "", day + 10 * 86400, "accrueInterest", "user_c")
+    testInvoke(t, stub, "", "unfreezeAccount", "bank")
+    testInvoke(t, stub, "", "grantRole", "compliance", mockCallerId)
+    testInvoke(t, stub, "", "addScreeningEntry", "user", "user_c", "sanctioned")
+    testInvokeAt(t, stub, "", day + 10 * 86400, `{"audit_id":"1","blocked":true,"reason":"user user_c is on the screening list."}`, "accrueInterest", "user_a", "user_c")
+    testGetBalance(t, stub, "user_a", "501.55")
+    testInvoke(t, stub, "", "removeScreeningEntry", "user", "user_c")
+    testInvokeAt(t, stub, "", day + 10 * 86400, `{"user_c":"0.8"}`, "accrueInterest", "user_c")
+
+    // funding_account 中锁定的额度不能用于发放
+    testInvokeAt(t, stub, "", day + 10 * 86400, "", "grantVesting", "bank", "grant_1", "1000", "1537747200", "315360000", "315360000")
+    testInvokeAt(t, stub, "", day + 10 * 86400, "", "withdraw", "bank", "95")
+    testGetBalance(t, stub, "bank", "1000.449")
+    testInvokeAtFail(t, stub, "", day + 40 * 86400, "accrueInterest", "user_c")
+    testGetBalance(t, stub, "user_c", "1001")
+}
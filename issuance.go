@@ -0,0 +1,420 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+
+    "github.com/shopspring/decimal"
+)
+
+// 发行
+// admin 通过 setIssuanceConfig 配置发行规则后，充值视为从发行账户发行：
+// 只有 operator 角色可以调用 recharge，每笔发行都记录为从发行账户到充值账户的转账记录（见 journal.go），发行账户的余额不变；
+// 每个周期（period_seconds 秒，按交易时间戳计算）的发行总额不能超过 period_cap，每个 operator 每个周期的发行额不能超过 operator_cap，"" 表示不限制；
+// 发行后的总发行量（见 supply.go）不能超过 treasury 角色通过 attestReserve 在链上证明的储备金额，没有证明过时储备为 0。
+// 以上限制适用于默认资产的铸造操作：recharge、approveDeposit、grantVesting 和兑换为默认资产的 transferWithConversion（见 conversion.go），
+// 兑换时发行用量计入调用者；不适用于其他资产。没有配置发行规则时不做限制。
+// 发行规则存储在 is: 下，储备存储在 rs: 下，周期内的发行用量存储在 is_c: 下，key 为 [计数器名]，计数器名为 total 或 operator 的身份哈希。
+
+type IssuanceConfig struct {
+    IssuerAccount string `json:"issuer_account"`
+    PeriodSeconds int64  `json:"period_seconds"`
+    PeriodCap     string `json:"period_cap"`
+    OperatorCap   string `json:"operator_cap"`
+}
+
+type ReserveAttestation struct {
+    Amount     string `json:"amount"`
+    Reference  string `json:"reference"`
+    AttestedBy string `json:"attested_by"`
+    AttestedAt int64  `json:"attested_at"`
+}
+
+// 检查通过后需要写入的发行用量
+type IssuanceUpdate struct {
+    issuer   string
+    counters map[string]VelocityCounter
+}
+
+// 查询发行规则，没有配置时返回 nil
+func getIssuanceConfig(stub shim.ChaincodeStubInterface) (*IssuanceConfig, error) {
+    config_key, err := stub.CreateCompositeKey("is:", []string{})
+    if err != nil {
+        return nil, fmt.Errorf("Failed to create key. %s", err.Error())
+    }
+
+    configAsBytes, err := stub.GetState(config_key)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if configAsBytes == nil {
+        return nil, nil
+    }
+
+    var config IssuanceConfig
+    err = json.Unmarshal(configAsBytes, &config)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to parse issuance config stored. %s", err.Error())
+    }
+
+    return &config, nil
+}
+
+func getReserveAttestation(stub shim.ChaincodeStubInterface) (ReserveAttestation, error) {
+    reserve_key, err := stub.CreateCompositeKey("rs:", []string{})
+    if err != nil {
+        return ReserveAttestation{}, fmt.Errorf("Failed to create key. %s", err.Error())
+    }
+
+    reserveAsBytes, err := stub.GetState(reserve_key)
+    if err != nil {
+        return ReserveAttestation{}, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if reserveAsBytes == nil {
+        return ReserveAttestation{Amount: "0"}, nil
+    }
+
+    var reserve ReserveAttestation
+    err = json.Unmarshal(reserveAsBytes, &reserve)
+    if err != nil {
+        return ReserveAttestation{}, fmt.Errorf("Failed to parse reserve attestation stored. %s", err.Error())
+    }
+
+    return reserve, nil
+}
+
+// 读取发行用量在 window 周期内的值，周期不同时返回 0
+func getIssuanceCounter(stub shim.ChaincodeStubInterface, name string, window int64) (decimal.Decimal, error) {
+    counter_key, err := stub.CreateCompositeKey("is_c:", []string{name})
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("counter name is not valid. %s", err.Error())
+    }
+
+    counterAsBytes, err := stub.GetState(counter_key)
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if counterAsBytes == nil {
+        return decimal.Zero, nil
+    }
+
+    var counter VelocityCounter
+    err = json.Unmarshal(counterAsBytes, &counter)
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("Failed to parse issuance counter stored. %s", err.Error())
+    }
+    if counter.Window != window {
+        return decimal.Zero, nil
+    }
+
+    value, err := decimal.NewFromString(counter.Value)
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("Failed to parse issuance counter stored. %s", err.Error())
+    }
+
+    return value, nil
+}
+
+// 检查由调用者发行 amount 是否超过周期上限、operator 上限和储备
+// 没有配置发行规则时返回 nil
+func checkIssuance(stub shim.ChaincodeStubInterface, amount decimal.Decimal) (*IssuanceUpdate, error) {
+    config, err := getIssuanceConfig(stub)
+    if err != nil {
+        return nil, err
+    }
+    if config == nil {
+        return nil, nil
+    }
+
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return nil, err
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return nil, err
+    }
+
+    window := now.Unix() / config.PeriodSeconds
+    update := &IssuanceUpdate{issuer: config.IssuerAccount, counters: map[string]VelocityCounter{}}
+
+    for _, counter := range []struct{ name, limit, desc string }{
+        {"total", config.PeriodCap, "period issuance cap"},
+        {caller, config.OperatorCap, "operator issuance cap"},
+    } {
+        issued, err := getIssuanceCounter(stub, counter.name, window)
+        if err != nil {
+            return nil, err
+        }
+        issued = issued.Add(amount)
+
+        if counter.limit != "" {
+            limit, err := decimal.NewFromString(counter.limit)
+            if err != nil {
+                return nil, fmt.Errorf("Failed to parse issuance config stored. %s", err.Error())
+            }
+            if issued.GreaterThan(limit) {
+                return nil, fmt.Errorf("issuance %s exceeds %s %s.", amount.String(), counter.desc, counter.limit)
+            }
+        }
+
+        update.counters[counter.name] = VelocityCounter{window, issued.String()}
+    }
+
+    reserve, err := getReserveAttestation(stub)
+    if err != nil {
+        return nil, err
+    }
+
+    reserve_amount, err := decimal.NewFromString(reserve.Amount)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to parse reserve attestation stored. %s", err.Error())
+    }
+
+    supply, err := getTotalSupply(stub, DEFAULT_ASSET)
+    if err != nil {
+        return nil, err
+    }
+
+    if supply.Add(amount).GreaterThan(reserve_amount) {
+        return nil, fmt.Errorf("issuance %s exceeds attested reserve %s, total supply is %s.", amount.String(), reserve_amount.String(), supply.String())
+    }
+
+    return update, nil
+}
+
+// 写入发行用量，记录从发行账户到 username 的转账记录
+// u 为 nil（没有配置发行规则）时什么都不做
+func (u *IssuanceUpdate) commit(stub shim.ChaincodeStubInterface, username string, amount decimal.Decimal) error {
+    if u == nil {
+        return nil
+    }
+
+    for name, counter := range u.counters {
+        counter_key, err := stub.CreateCompositeKey("is_c:", []string{name})
+        if err != nil {
+            return fmt.Errorf("counter name is not valid. %s", err.Error())
+        }
+
+        counterAsBytes, err := json.Marshal(counter)
+        if err != nil {
+            return fmt.Errorf("Failed to format issuance counter. %s", err.Error())
+        }
+
+        err = stub.PutState(counter_key, counterAsBytes)
+        if err != nil {
+            return fmt.Errorf("Failed to put state. %s", err.Error())
+        }
+    }
+
+    _, err := recordTransfer(stub, u.issuer, username, amount, "")
+
+    return err
+}
+
+// 铸造：计入 username 的余额，增加总发行量，写入发行用量
+// issuance 为 checkIssuance 的返回值
+func mint(stub shim.ChaincodeStubInterface, username string, balance, amount decimal.Decimal, issuance *IssuanceUpdate) error {
+    err := setBalance(stub, username, balance, balance.Add(amount))
+    if err != nil {
+        return err
+    }
+
+    err = adjustTotalSupply(stub, DEFAULT_ASSET, amount)
+    if err != nil {
+        return err
+    }
+
+    return issuance.commit(stub, username, amount)
+}
+
+// 配置发行规则，只有 admin 可以调用
+// config 为json字符串，字段含义见 issuance.go；issuer_account 必须是已注册的用户，period_seconds 大于 0
+// {"issuer_account":"issuer","period_seconds":86400,"period_cap":"1000000","operator_cap":"100000"}
+// 返回值：nil
+func (cc *RestrainedTransferCC) setIssuanceConfig(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'setIssuanceConfig', args: ['config']}"`)
+    }
+
+    var err error
+
+    var config IssuanceConfig
+    err = json.Unmarshal([]byte(args[0]), &config)
+    if err != nil {
+        return shim.Error("Invalid issuance config, expecting json. " + err.Error())
+    }
+
+    if config.PeriodSeconds <= 0 {
+        return shim.Error("Invalid issuance config, period_seconds should be greater than 0.")
+    }
+
+    for _, limit := range []string{config.PeriodCap, config.OperatorCap} {
+        if limit == "" {
+            continue
+        }
+        value, err := decimal.NewFromString(limit)
+        if err != nil || value.LessThan(decimal.Zero) {
+            return shim.Error("Invalid issuance cap " + limit + ", expecting a number not less than 0.")
+        }
+    }
+
+    if err = checkCallerRole(stub, ROLE_ADMIN); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkRegistered(stub, "issuer_account", config.IssuerAccount); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    config_key, err := stub.CreateCompositeKey("is:", []string{})
+    if err != nil {
+        return shim.Error("Failed to create key. " + err.Error())
+    }
+
+    configAsBytes, err := json.Marshal(config)
+    if err != nil {
+        return shim.Error("Failed to format issuance config. " + err.Error())
+    }
+
+    err = stub.PutState(config_key, configAsBytes)
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询发行规则
+// 返回值：json字符串，格式同 setIssuanceConfig，没有配置时为 {}
+func (cc *RestrainedTransferCC) getIssuanceConfig(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 0 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getIssuanceConfig', args: []}"`)
+    }
+
+    config, err := getIssuanceConfig(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if config == nil {
+        return shim.Success([]byte("{}"))
+    }
+
+    configAsBytes, err := json.Marshal(config)
+    if err != nil {
+        return shim.Error("Failed to format issuance config. " + err.Error())
+    }
+
+    return shim.Success(configAsBytes)
+}
+
+// 证明储备金额，只有 treasury 角色可以调用，新的证明替换旧的证明
+// reference 为证明的外部凭据，如审计报告编号
+// 返回值：nil
+func (cc *RestrainedTransferCC) attestReserve(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'attestReserve', args: ['amount', 'reference']}"`)
+    }
+
+    amount_str := strings.TrimSpace(args[0])
+    reference := strings.TrimSpace(args[1])
+
+    var err error
+
+    amount, err := validateNonNegativeAmount("amount", amount_str)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkCallerRole(stub, ROLE_TREASURY); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    reserve_key, err := stub.CreateCompositeKey("rs:", []string{})
+    if err != nil {
+        return shim.Error("Failed to create key. " + err.Error())
+    }
+
+    reserveAsBytes, err := json.Marshal(ReserveAttestation{
+        Amount: amount.String(),
+        Reference: reference,
+        AttestedBy: caller,
+        AttestedAt: now.Unix(),
+    })
+    if err != nil {
+        return shim.Error("Failed to format reserve attestation. " + err.Error())
+    }
+
+    err = stub.PutState(reserve_key, reserveAsBytes)
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询储备证明和当前周期的发行用量
+// 返回值：json字符串，没有配置发行规则时 period_issued 为 "0"
+// {"amount":"1000000","reference":"AUDIT-2018-09","attested_by":"...","attested_at":1537776000,"total_supply":"150","period_issued":"150"}
+func (cc *RestrainedTransferCC) getReserve(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 0 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getReserve', args: []}"`)
+    }
+
+    reserve, err := getReserveAttestation(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    supply, err := getTotalSupply(stub, DEFAULT_ASSET)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    config, err := getIssuanceConfig(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    period_issued := decimal.Zero
+    if config != nil {
+        now, err := getTxTime(stub)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+
+        period_issued, err = getIssuanceCounter(stub, "total", now.Unix() / config.PeriodSeconds)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+    }
+
+    reserveAsBytes, err := json.Marshal(MAP{
+        "amount": reserve.Amount,
+        "reference": reserve.Reference,
+        "attested_by": reserve.AttestedBy,
+        "attested_at": reserve.AttestedAt,
+        "total_supply": supply.String(),
+        "period_issued": period_issued.String(),
+    })
+    if err != nil {
+        return shim.Error("Failed to format reserve attestation. " + err.Error())
+    }
+
+    return shim.Success(reserveAsBytes)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+    "testing"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestIssuance(t *testing.T) {
+    stub := shim.NewMockStub("TestIssuance", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "issuer", "")
+    testRegister(t, stub, "user_a", "")
+
+    // 没有配置发行规则时不做限制
+    testInvoke(t, stub, "{}", "getIssuanceConfig")
+    testRecharge(t, stub, "user_a", "100")
+
+    day := int64(1537776000)
+    config := `{"issuer_account":"issuer","period_seconds":86400,"period_cap":"300","operator_cap":"200"}`
+
+    testInvokeFail(t, stub, "setIssuanceConfig", "issuer")
+    testInvokeFail(t, stub, "setIssuanceConfig", `{"issuer_account":"issuer","period_seconds":0}`)
+    testInvokeFail(t, stub, "setIssuanceConfig", `{"issuer_account":"issuer","period_seconds":86400,"period_cap":"-1"}`)
+    testInvokeFail(t, stub, "setIssuanceConfig", `{"issuer_account":"user_x","period_seconds":86400}`)
+    testInvokeAsFail(t, stub, "bob", "setIssuanceConfig", config)
+    testInvoke(t, stub, "", "setIssuanceConfig", config)
+    testInvoke(t, stub, config, "getIssuanceConfig")
+
+    // 只有 operator 可以充值，没有储备证明时不能发行
+    testInvokeAtFail(t, stub, "", day, "recharge", "user_a", "50")
+    testInvoke(t, stub, "", "grantRole", "operator", mockCallerId)
+    testInvoke(t, stub, "", "grantRole", "operator", bobId)
+    testInvokeAtFail(t, stub, "", day, "recharge", "user_a", "50")
+
+    testInvokeFail(t, stub, "attestReserve", "1000", "AUDIT-1")
+    testInvoke(t, stub, "", "grantRole", "treasury", mockCallerId)
+    testInvokeFail(t, stub, "attestReserve", "-1", "AUDIT-1")
+    testInvokeAt(t, stub, "", day, "", "attestReserve", "1000", "AUDIT-1")
+
+    // operator 上限 200，周期上限 300
+    testInvokeAt(t, stub, "", day, "", "recharge", "user_a", "150")
+    testInvokeAtFail(t, stub, "", day, "recharge", "user_a", "60")
+    testInvokeAt(t, stub, "bob", day, "", "recharge", "user_a", "100")
+    testInvokeAtFail(t, stub, "bob", day, "recharge", "user_a", "60")
+    testGetBalance(t, stub, "user_a", "350")
+    testGetBalance(t, stub, "issuer", "0")
+    testInvokeAt(t, stub, "", day, `{"amount":"1000","attested_at":1537776000,"attested_by":"` + mockCallerId + `","period_issued":"250","reference":"AUDIT-1","total_supply":"350"}`,
+        "getReserve")
+
+    // 发行记录为从发行账户到充值账户的转账
+    testInvoke(t, stub, `{"id":"1","from":"issuer","to":"user_a","amount":"150","timestamp":1537776000,"reversal_of":"","reversed_by":""}`,
+        "getTransfer", "1")
+
+    // 下一个周期重新计算
+    testInvokeAt(t, stub, "bob", day + 86400, "", "recharge", "user_a", "60")
+    testInvokeAt(t, stub, "", day + 86400, `{"amount":"1000","attested_at":1537776000,"attested_by":"` + mockCallerId + `","period_issued":"60","reference":"AUDIT-1","total_supply":"410"}`,
+        "getReserve")
+
+    // 总发行量不能超过储备
+    testInvokeAt(t, stub, "", day + 86400, "", "attestReserve", "500", "AUDIT-2")
+    testInvokeAtFail(t, stub, "", day + 86400, "grantVesting", "user_a", "grant_1", "100", "1537776000", "100", "1000")
+    testInvokeAt(t, stub, "", day + 86400, "", "grantVesting", "user_a", "grant_1", "90", "1537776000", "100", "1000")
+    testInvoke(t, stub, "500", "getTotalSupply")
+}
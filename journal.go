@@ -0,0 +1,124 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+
+    "github.com/shopspring/decimal"
+)
+
+// 转账记录
+// 每一笔通过 doTransfer 完成的转账和每一笔冲正都记录在 t_j: 下，key 为转账 id。
+// 转账 id 为交易 id；同一个交易内有多笔转账时（如 routedTransfer、executeDue），
+// 第一笔为交易 id，之后依次为 交易id.1、交易id.2 ……，见 newTxScopedId
+
+type TransferRecord struct {
+    Id         string `json:"id"`
+    From       string `json:"from"`
+    To         string `json:"to"`
+    Amount     string `json:"amount"`
+    Timestamp  int64  `json:"timestamp"`
+    // 冲正记录对应的原转账 id
+    ReversalOf string `json:"reversal_of"`
+    // 原转账被冲正后，冲正记录的 id
+    ReversedBy string `json:"reversed_by"`
+}
+
+func getTransferRecord(stub shim.ChaincodeStubInterface, id string) (*TransferRecord, error) {
+    record_key, err := stub.CreateCompositeKey("t_j:", []string{id})
+    if err != nil {
+        return nil, fmt.Errorf("transfer id is not valid. %s", err.Error())
+    }
+
+    recordAsBytes, err := stub.GetState(record_key)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if recordAsBytes == nil {
+        return nil, nil
+    }
+
+    var record TransferRecord
+    err = json.Unmarshal(recordAsBytes, &record)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to parse transfer record stored. %s", err.Error())
+    }
+
+    return &record, nil
+}
+
+func putTransferRecord(stub shim.ChaincodeStubInterface, record *TransferRecord) error {
+    record_key, err := stub.CreateCompositeKey("t_j:", []string{record.Id})
+    if err != nil {
+        return fmt.Errorf("transfer id is not valid. %s", err.Error())
+    }
+
+    recordAsBytes, err := json.Marshal(record)
+    if err != nil {
+        return fmt.Errorf("Failed to format transfer record. %s", err.Error())
+    }
+
+    err = stub.PutState(record_key, recordAsBytes)
+    if err != nil {
+        return fmt.Errorf("Failed to put state. %s", err.Error())
+    }
+
+    return nil
+}
+
+// 记录一笔转账，返回转账 id
+func recordTransfer(stub shim.ChaincodeStubInterface, from, to string, amount decimal.Decimal, reversal_of string) (string, error) {
+    now, err := getTxTime(stub)
+    if err != nil {
+        return "", err
+    }
+
+    id, err := newTxScopedId(stub, "t_j:")
+    if err != nil {
+        return "", err
+    }
+
+    err = putTransferRecord(stub, &TransferRecord{
+        Id: id,
+        From: from,
+        To: to,
+        Amount: amount.String(),
+        Timestamp: now.Unix(),
+        ReversalOf: reversal_of,
+    })
+    if err != nil {
+        return "", err
+    }
+
+    return id, nil
+}
+
+// 查询转账记录
+// 返回值：json字符串
+// {"id":"txid","from":"user_a","to":"user_b","amount":"100","timestamp":1537776000,"reversal_of":"","reversed_by":""}
+func (cc *RestrainedTransferCC) getTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getTransfer', args: ['transfer_id']}"`)
+    }
+
+    id := strings.TrimSpace(args[0])
+
+    record, err := getTransferRecord(stub, id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if record == nil {
+        return shim.Error("transfer " + id + " does not exist.")
+    }
+
+    recordAsBytes, err := json.Marshal(record)
+    if err != nil {
+        return shim.Error("Failed to format transfer record. " + err.Error())
+    }
+
+    return shim.Success(recordAsBytes)
+}
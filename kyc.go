@@ -0,0 +1,430 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+
+    "github.com/shopspring/decimal"
+)
+
+// KYC 等级
+// 每个用户的 KYC 等级记录在 u_i: 的 json 中（kyc_level、kyc_updated_by、kyc_updated_at），由 kyc_officer 角色通过 setKycLevel 设置，没有设置时为 0。
+// admin 通过 setKycPolicy 为每个等级配置允许的操作和金额限制，存储在 k_p: 下，key 为 [等级]：
+// {"operations":{"recharge":{"max_amount":"1000","daily_limit":"5000"},"transfer":{"max_amount":"","daily_limit":"1000"},"receive":{}}}
+// 操作为 recharge、withdraw、transfer（转出）、receive（转入），不在 operations 中的操作不允许；
+// max_amount 为单笔上限，daily_limit 为每天（UTC）的累计上限，"" 表示不限制。
+// recharge、withdraw 和所有经过 checkTransfer 的转账（transfer、routedTransfer、lockTransfer、executeDue）都要检查，
+// 在转账约束之外另外检查；transferWithConversion 只检查默认资产一方。冲正和 approveDeposit 不检查。
+// 没有配置任何等级的策略时不检查；配置后没有策略的等级不允许任何操作。
+// 每天的用量存储在 k_c: 下，key 为 [用户名, 操作]，规则同风控计数器（见 velocity.go）；需要复核的充值在申请时计入用量。
+
+const (
+    KYC_RECHARGE = "recharge"
+    KYC_WITHDRAW = "withdraw"
+    KYC_TRANSFER = "transfer"
+    KYC_RECEIVE  = "receive"
+)
+
+// KYC 等级的上限
+const MAX_KYC_LEVEL = 9
+
+type KycOperationLimit struct {
+    MaxAmount  string `json:"max_amount"`
+    DailyLimit string `json:"daily_limit"`
+}
+
+type KycPolicy struct {
+    Operations map[string]KycOperationLimit `json:"operations"`
+}
+
+// 需要检查的用户和操作
+type KycCheck struct {
+    Username  string
+    Operation string
+}
+
+// 检查通过后需要写入的用量，key 为 [用户名, 操作]
+type KycUpdate struct {
+    counters map[KycCheck]VelocityCounter
+}
+
+// 查询 username 的 KYC 等级，子账户的等级以父账户为准
+func getKycLevel(stub shim.ChaincodeStubInterface, username string) (int, error) {
+    user_info_key, err := stub.CreateCompositeKey("u_i:", []string{username})
+    if err != nil {
+        return 0, fmt.Errorf("username is not valid. %s", err.Error())
+    }
+
+    userAsBytes, err := stub.GetState(user_info_key)
+    if err != nil {
+        return 0, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if userAsBytes == nil {
+        return 0, fmt.Errorf("username %s is not registered.", username)
+    }
+
+    var info struct {
+        KycLevel int    `json:"kyc_level"`
+        Parent   string `json:"parent"`
+    }
+    err = json.Unmarshal(userAsBytes, &info)
+    if err != nil {
+        return 0, fmt.Errorf("Failed to parse user info stored. %s", err.Error())
+    }
+
+    if info.Parent != "" {
+        return getKycLevel(stub, info.Parent)
+    }
+
+    return info.KycLevel, nil
+}
+
+// 查询 level 的策略，没有配置时返回 nil
+func getKycPolicy(stub shim.ChaincodeStubInterface, level int) (*KycPolicy, error) {
+    policy_key, err := stub.CreateCompositeKey("k_p:", []string{strconv.Itoa(level)})
+    if err != nil {
+        return nil, fmt.Errorf("Failed to create key. %s", err.Error())
+    }
+
+    policyAsBytes, err := stub.GetState(policy_key)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if policyAsBytes == nil {
+        return nil, nil
+    }
+
+    var policy KycPolicy
+    err = json.Unmarshal(policyAsBytes, &policy)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to parse kyc policy stored. %s", err.Error())
+    }
+
+    return &policy, nil
+}
+
+// 是否配置了任何等级的策略
+func kycEnforced(stub shim.ChaincodeStubInterface) (bool, error) {
+    itr, err := stub.GetStateByPartialCompositeKey("k_p:", []string{})
+    if err != nil {
+        return false, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    defer itr.Close()
+
+    return itr.HasNext(), nil
+}
+
+func getKycCounter(stub shim.ChaincodeStubInterface, check KycCheck, window int64) (decimal.Decimal, error) {
+    counter_key, err := stub.CreateCompositeKey("k_c:", []string{check.Username, check.Operation})
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("username is not valid. %s", err.Error())
+    }
+
+    counterAsBytes, err := stub.GetState(counter_key)
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if counterAsBytes == nil {
+        return decimal.Zero, nil
+    }
+
+    var counter VelocityCounter
+    err = json.Unmarshal(counterAsBytes, &counter)
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("Failed to parse kyc counter stored. %s", err.Error())
+    }
+    if counter.Window != window {
+        return decimal.Zero, nil
+    }
+
+    value, err := decimal.NewFromString(counter.Value)
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("Failed to parse kyc counter stored. %s", err.Error())
+    }
+
+    return value, nil
+}
+
+// 按用户的 KYC 等级检查每个 check 的操作是否允许、amount 是否超过限制，返回需要写入的用量
+// 没有配置策略时返回 nil
+func checkKyc(stub shim.ChaincodeStubInterface, amount decimal.Decimal, checks ...KycCheck) (*KycUpdate, error) {
+    enforced, err := kycEnforced(stub)
+    if err != nil {
+        return nil, err
+    }
+    if !enforced {
+        return nil, nil
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return nil, err
+    }
+
+    window := now.Unix() / SECONDS_PER_DAY
+    update := &KycUpdate{counters: map[KycCheck]VelocityCounter{}}
+
+    for _, check := range checks {
+        level, err := getKycLevel(stub, check.Username)
+        if err != nil {
+            return nil, err
+        }
+
+        policy, err := getKycPolicy(stub, level)
+        if err != nil {
+            return nil, err
+        }
+
+        limit, ok := KycOperationLimit{}, false
+        if policy != nil {
+            limit, ok = policy.Operations[check.Operation]
+        }
+        if !ok {
+            return nil, fmt.Errorf("%s is not allowed to %s at kyc level %d.", check.Username, check.Operation, level)
+        }
+
+        if limit.MaxAmount != "" {
+            max_amount, err := decimal.NewFromString(limit.MaxAmount)
+            if err != nil {
+                return nil, fmt.Errorf("Failed to parse kyc policy stored. %s", err.Error())
+            }
+            if amount.GreaterThan(max_amount) {
+                return nil, fmt.Errorf("%s %s exceeds max amount %s of kyc level %d.", check.Operation, amount.String(), limit.MaxAmount, level)
+            }
+        }
+
+        used, err := getKycCounter(stub, check, window)
+        if err != nil {
+            return nil, err
+        }
+        used = used.Add(amount)
+
+        if limit.DailyLimit != "" {
+            daily_limit, err := decimal.NewFromString(limit.DailyLimit)
+            if err != nil {
+                return nil, fmt.Errorf("Failed to parse kyc policy stored. %s", err.Error())
+            }
+            if used.GreaterThan(daily_limit) {
+                return nil, fmt.Errorf("%s %s exceeds daily limit %s of kyc level %d.", check.Operation, amount.String(), limit.DailyLimit, level)
+            }
+        }
+
+        update.counters[check] = VelocityCounter{window, used.String()}
+    }
+
+    return update, nil
+}
+
+// 写入用量，u 为 nil 时什么都不做
+func (u *KycUpdate) commit(stub shim.ChaincodeStubInterface) error {
+    if u == nil {
+        return nil
+    }
+
+    for check, counter := range u.counters {
+        counter_key, err := stub.CreateCompositeKey("k_c:", []string{check.Username, check.Operation})
+        if err != nil {
+            return fmt.Errorf("username is not valid. %s", err.Error())
+        }
+
+        counterAsBytes, err := json.Marshal(counter)
+        if err != nil {
+            return fmt.Errorf("Failed to format kyc counter. %s", err.Error())
+        }
+
+        err = stub.PutState(counter_key, counterAsBytes)
+        if err != nil {
+            return fmt.Errorf("Failed to put state. %s", err.Error())
+        }
+    }
+
+    return nil
+}
+
+// 设置用户的 KYC 等级，只有 kyc_officer 角色可以调用，level 为 0 到 MAX_KYC_LEVEL 的整数
+// 返回值：nil
+func (cc *RestrainedTransferCC) setKycLevel(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'setKycLevel', args: ['username', 'level']}"`)
+    }
+
+    username := strings.TrimSpace(args[0])
+    level_str := strings.TrimSpace(args[1])
+
+    var err error
+
+    level, err := strconv.Atoi(level_str)
+    if err != nil || level < 0 || level > MAX_KYC_LEVEL {
+        return shim.Error(fmt.Sprintf("level got %s, expected an integer in [0, %d]", level_str, MAX_KYC_LEVEL))
+    }
+
+    if err = checkCallerRole(stub, ROLE_KYC_OFFICER); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    user_info_key, err := stub.CreateCompositeKey("u_i:", []string{username})
+    if err != nil {
+        return shim.Error("username is not valid. " + err.Error())
+    }
+
+    userAsBytes, err := stub.GetState(user_info_key)
+    if err != nil {
+        return shim.Error("Failed to get state. " + err.Error())
+    }
+    if userAsBytes == nil {
+        return shim.Error("username " + username + " is not registered.")
+    }
+
+    var info map[string]interface{}
+    err = json.Unmarshal(userAsBytes, &info)
+    if err != nil {
+        return shim.Error("Failed to parse user info stored. " + err.Error())
+    }
+    if _, ok := info["parent"]; ok {
+        return shim.Error("username " + username + " is a sub-account, its KYC level follows its parent.")
+    }
+
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    info["kyc_level"] = level
+    info["kyc_updated_by"] = caller
+    info["kyc_updated_at"] = now.Unix()
+
+    userAsBytes, err = json.Marshal(info)
+    if err != nil {
+        return shim.Error("Failed to format user info. " + err.Error())
+    }
+
+    err = stub.PutState(user_info_key, userAsBytes)
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 配置 KYC 等级的策略，只有 admin 可以调用，policy 为 "" 时删除该等级的策略
+// policy 为json字符串，格式见 kyc.go
+// 返回值：nil
+func (cc *RestrainedTransferCC) setKycPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'setKycPolicy', args: ['level', 'policy']}"`)
+    }
+
+    level_str := strings.TrimSpace(args[0])
+    policy_str := strings.TrimSpace(args[1])
+
+    var err error
+
+    level, err := strconv.Atoi(level_str)
+    if err != nil || level < 0 || level > MAX_KYC_LEVEL {
+        return shim.Error(fmt.Sprintf("level got %s, expected an integer in [0, %d]", level_str, MAX_KYC_LEVEL))
+    }
+
+    var policyAsBytes []byte
+    if policy_str != "" {
+        var policy KycPolicy
+        err = json.Unmarshal([]byte(policy_str), &policy)
+        if err != nil {
+            return shim.Error("Invalid kyc policy, expecting json. " + err.Error())
+        }
+        if policy.Operations == nil {
+            policy.Operations = map[string]KycOperationLimit{}
+        }
+
+        for operation, limit := range policy.Operations {
+            switch operation {
+            case KYC_RECHARGE, KYC_WITHDRAW, KYC_TRANSFER, KYC_RECEIVE:
+            default:
+                return shim.Error("Invalid kyc policy, unknown operation " + operation + ".")
+            }
+            for _, value := range []string{limit.MaxAmount, limit.DailyLimit} {
+                if value == "" {
+                    continue
+                }
+                amount, err := decimal.NewFromString(value)
+                if err != nil || amount.LessThan(decimal.Zero) {
+                    return shim.Error("Invalid kyc limit " + value + ", expecting a number not less than 0.")
+                }
+            }
+        }
+
+        policyAsBytes, err = json.Marshal(policy)
+        if err != nil {
+            return shim.Error("Failed to format kyc policy. " + err.Error())
+        }
+    }
+
+    if err = checkCallerRole(stub, ROLE_ADMIN); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    policy_key, err := stub.CreateCompositeKey("k_p:", []string{strconv.Itoa(level)})
+    if err != nil {
+        return shim.Error("Failed to create key. " + err.Error())
+    }
+
+    if policyAsBytes == nil {
+        err = stub.DelState(policy_key)
+        if err != nil {
+            return shim.Error("Failed to del state. " + err.Error())
+        }
+        return shim.Success(nil)
+    }
+
+    err = stub.PutState(policy_key, policyAsBytes)
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询所有等级的策略
+// 返回值：json字符串，key 为等级，没有配置时为 {}
+// {"0":{"operations":{"recharge":{"max_amount":"1000","daily_limit":"5000"}}},"1":{"operations":{...}}}
+func (cc *RestrainedTransferCC) getKycPolicies(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 0 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getKycPolicies', args: []}"`)
+    }
+
+    itr, err := stub.GetStateByPartialCompositeKey("k_p:", []string{})
+    if err != nil {
+        return shim.Error("Failed to get state. " + err.Error())
+    }
+    defer itr.Close()
+
+    policies := map[string]json.RawMessage{}
+
+    for itr.HasNext() {
+        kv, err := itr.Next()
+        if err != nil {
+            return shim.Error("Failed to get kyc policy stored. " + err.Error())
+        }
+        _, compositeKeyParts, err := stub.SplitCompositeKey(kv.Key)
+        if err != nil {
+            return shim.Error("Failed to parse kyc policy stored. " + err.Error())
+        }
+        policies[compositeKeyParts[0]] = json.RawMessage(kv.Value)
+    }
+
+    policiesAsBytes, err := json.Marshal(policies)
+    if err != nil {
+        return shim.Error("Failed to format kyc policies. " + err.Error())
+    }
+
+    return shim.Success(policiesAsBytes)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+    "testing"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestKyc(t *testing.T) {
+    stub := shim.NewMockStub("TestKyc", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "user_a", "")
+    testRegister(t, stub, "user_b", "")
+    testSetRestraint(t, stub, "user_a", "user_b", "3")
+
+    // 没有配置策略时不检查
+    testRecharge(t, stub, "user_a", "1000")
+    testInvoke(t, stub, "{}", "getKycPolicies")
+
+    day := int64(1537776000)
+
+    testInvokeFail(t, stub, "setKycLevel", "user_a", "1")
+    testInvoke(t, stub, "", "grantRole", "kyc_officer", mockCallerId)
+    testInvokeFail(t, stub, "setKycLevel", "user_a", "10")
+    testInvokeFail(t, stub, "setKycLevel", "user_x", "1")
+
+    level_0 := `{"operations":{"receive":{"max_amount":"","daily_limit":""},"recharge":{"max_amount":"100","daily_limit":"150"}}}`
+    level_1 := `{"operations":{"receive":{"max_amount":"","daily_limit":""},"recharge":{"max_amount":"","daily_limit":""},` +
+        `"transfer":{"max_amount":"","daily_limit":"300"},"withdraw":{"max_amount":"500","daily_limit":""}}}`
+
+    testInvokeFail(t, stub, "setKycPolicy", "0", `{"operations":{"mint":{}}}`)
+    testInvokeFail(t, stub, "setKycPolicy", "0", `{"operations":{"recharge":{"max_amount":"-1"}}}`)
+    testInvokeFail(t, stub, "setKycPolicy", "10", level_0)
+    testInvokeAsFail(t, stub, "bob", "setKycPolicy", "0", level_0)
+    testInvoke(t, stub, "", "setKycPolicy", "0", level_0)
+    testInvoke(t, stub, "", "setKycPolicy", "1", level_1)
+    testInvoke(t, stub, `{"0":` + level_0 + `,"1":` + level_1 + `}`, "getKycPolicies")
+
+    // 等级 0：只能充值和收款
+    testInvokeAtFail(t, stub, "", day, "transfer", "user_a", "user_b", "10")
+    testInvokeAtFail(t, stub, "", day, "withdraw", "user_a", "10")
+    testInvokeAtFail(t, stub, "", day, "recharge", "user_a", "101")
+    testInvokeAt(t, stub, "", day, "", "recharge", "user_a", "100")
+    testInvokeAtFail(t, stub, "", day, "recharge", "user_a", "60")
+    testInvokeAt(t, stub, "", day, "", "recharge", "user_a", "50")
+    testInvokeAt(t, stub, "", day + 86400, "", "recharge", "user_a", "100")
+    testGetBalance(t, stub, "user_a", "1250")
+
+    // 等级 1
+    testInvokeAt(t, stub, "", day, "", "setKycLevel", "user_a", "1")
+    testGetUserInfo(t, stub, "user_a", `{"extras":"","kyc_level":1,"kyc_updated_at":1537776000,"kyc_updated_by":"` + mockCallerId + `","name":"user_a"}`)
+    testInvokeAt(t, stub, "", day, "", "transfer", "user_a", "user_b", "200")
+    testInvokeAtFail(t, stub, "", day, "transfer", "user_a", "user_b", "101")
+    testInvokeAt(t, stub, "", day, `["user_a","user_b"]`, "routedTransfer", "user_a", "user_b", "100", "3", "false")
+    testInvokeAtFail(t, stub, "", day, "routedTransfer", "user_a", "user_b", "1", "3", "false")
+    testInvokeAtFail(t, stub, "", day, "withdraw", "user_a", "501")
+    testInvokeAt(t, stub, "", day, "", "withdraw", "user_a", "500")
+    testGetBalance(t, stub, "user_a", "450")
+    testGetBalance(t, stub, "user_b", "300")
+
+    // 收款方等级 0 不能转出；没有策略的等级不允许任何操作
+    testInvokeAtFail(t, stub, "", day, "transfer", "user_b", "user_a", "10")
+    testInvokeAt(t, stub, "", day, "", "setKycLevel", "user_b", "2")
+    testInvokeAtFail(t, stub, "", day, "recharge", "user_b", "10")
+    testInvokeAtFail(t, stub, "", day + 86400, "transfer", "user_a", "user_b", "10")
+
+    // 删除所有策略后不再检查
+    testInvoke(t, stub, "", "setKycPolicy", "0", "")
+    testInvoke(t, stub, "", "setKycPolicy", "1", "")
+    testInvoke(t, stub, "{}", "getKycPolicies")
+    testInvokeAt(t, stub, "", day, "", "transfer", "user_b", "user_a", "10")
+}
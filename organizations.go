@@ -0,0 +1,720 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// 组织
+// 一个通道上可以有多个组织（公司），每个组织有自己的成员用户和组织管理员，由组织管理员管理组织内用户之间的转账约束。
+// 组织信息存储在 o_i: 下，组织管理员存储在 o_a: 下，key 为 [组织, 身份哈希]；
+// 成员关系同时存储在 o_m:(组织 -> 用户) 和 u_og:(用户 -> 组织) 下，一个用户最多属于一个组织，子账户属于父账户所在的组织。
+// admin 创建组织、任免组织管理员；admin 或组织管理员可以把用户加入组织，组织管理员只能加入自己是所有者的用户。
+//
+// setRestraint 的权限：
+// 1. 双方都不属于任何组织时，与之前一样不检查调用者；
+// 2. 否则 admin 可以直接设置；
+// 3. 双方属于同一个组织时，该组织的管理员可以直接设置；
+// 4. 双方属于不同组织时，需要双方组织的管理员都设置相同的约束才生效（同时是双方组织管理员的调用者可以直接设置）。
+//    第一个组织管理员设置时，约束记录为待确认，存储在 o_p: 下，key 为按字典序排序的 [用户, 用户]；
+//    另一方组织的管理员设置相同的约束时生效并删除待确认记录，设置不同的约束时替换待确认记录，需要对方重新确认；
+// 5. 一方属于组织、另一方不属于任何组织时，只有 admin 可以设置。
+
+type PendingRestraint struct {
+    UsernameA string            `json:"username_a"`
+    UsernameB string            `json:"username_b"`
+    // username_a 到 username_b 的约束
+    Restraint string            `json:"restraint"`
+    OrgA      string            `json:"org_a"`
+    OrgB      string            `json:"org_b"`
+    // 已经确认的组织 -> 确认的组织管理员身份哈希
+    Approvals map[string]string `json:"approvals"`
+}
+
+// 检查组织是否已创建
+func checkOrganizationCreated(stub shim.ChaincodeStubInterface, org string) error {
+    org_info_key, err := stub.CreateCompositeKey("o_i:", []string{org})
+    if err != nil {
+        return fmt.Errorf("org is not valid. %s", err.Error())
+    }
+
+    orgAsBytes, err := stub.GetState(org_info_key)
+    if err != nil {
+        return fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if orgAsBytes == nil {
+        return fmt.Errorf("org %s is not created.", org)
+    }
+
+    return nil
+}
+
+// 查询账户所属的组织，子账户以父账户为准，不属于任何组织时返回 ""
+func getOrganizationOf(stub shim.ChaincodeStubInterface, username string) (string, error) {
+    holder, err := getAccountHolder(stub, username)
+    if err != nil {
+        return "", err
+    }
+
+    user_org_key, err := stub.CreateCompositeKey("u_og:", []string{holder})
+    if err != nil {
+        return "", fmt.Errorf("username is not valid. %s", err.Error())
+    }
+
+    orgAsBytes, err := stub.GetState(user_org_key)
+    if err != nil {
+        return "", fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+
+    return string(orgAsBytes), nil
+}
+
+// 查询身份是否是组织管理员
+func isOrganizationAdmin(stub shim.ChaincodeStubInterface, org, identity string) (bool, error) {
+    org_admin_key, err := stub.CreateCompositeKey("o_a:", []string{org, identity})
+    if err != nil {
+        return false, fmt.Errorf("org or identity is not valid. %s", err.Error())
+    }
+
+    adminAsBytes, err := stub.GetState(org_admin_key)
+    if err != nil {
+        return false, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+
+    return adminAsBytes != nil, nil
+}
+
+// 查询组织的所有成员或管理员，object_type 为 o_m: 或 o_a:
+func listOrganizationEntries(stub shim.ChaincodeStubInterface, object_type, org string) ([]string, error) {
+    itr, err := stub.GetStateByPartialCompositeKey(object_type, []string{org})
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    defer itr.Close()
+
+    entries := []string{}
+
+    for itr.HasNext() {
+        kv, err := itr.Next()
+        if err != nil {
+            return nil, fmt.Errorf("Failed to get organization stored. %s", err.Error())
+        }
+        _, compositeKeyParts, err := stub.SplitCompositeKey(kv.Key)
+        if err != nil {
+            return nil, fmt.Errorf("Failed to parse organization stored. %s", err.Error())
+        }
+        entries = append(entries, compositeKeyParts[1])
+    }
+
+    return entries, nil
+}
+
+// 调用者既不是 admin 也不是组织管理员时返回 error
+func checkCallerAdministers(stub shim.ChaincodeStubInterface, org string) error {
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return err
+    }
+
+    ok, err := hasRole(stub, ROLE_ADMIN, caller)
+    if err != nil || ok {
+        return err
+    }
+
+    ok, err = isOrganizationAdmin(stub, org, caller)
+    if err != nil {
+        return err
+    }
+    if !ok {
+        return fmt.Errorf("caller %s is not an admin of org %s.", caller, org)
+    }
+
+    return nil
+}
+
+func getPendingRestraint(stub shim.ChaincodeStubInterface, pending_key string) (*PendingRestraint, error) {
+    pendingAsBytes, err := stub.GetState(pending_key)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if pendingAsBytes == nil {
+        return nil, nil
+    }
+
+    var pending PendingRestraint
+    err = json.Unmarshal(pendingAsBytes, &pending)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to parse pending restraint stored. %s", err.Error())
+    }
+
+    return &pending, nil
+}
+
+// planRestraint 的结果，commit 写入待确认记录的变化
+type RestraintPlan struct {
+    // true 表示可以直接写入约束，false 表示记录为待确认
+    Apply      bool
+    PendingKey string
+    // 需要写入的待确认记录，Apply 为 true 时为 nil
+    Pending    *PendingRestraint
+    // 是否需要删除已有的待确认记录
+    DelPending bool
+}
+
+// 检查调用者能否设置 username_a 和 username_b 之间的约束，规则见文件开头的说明；不修改状态
+func planRestraint(stub shim.ChaincodeStubInterface, username_a, username_b string, restraint RestraintType) (*RestraintPlan, error) {
+    org_a, err := getOrganizationOf(stub, username_a)
+    if err != nil {
+        return nil, err
+    }
+
+    org_b, err := getOrganizationOf(stub, username_b)
+    if err != nil {
+        return nil, err
+    }
+
+    if org_a == "" && org_b == "" {
+        return &RestraintPlan{Apply: true}, nil
+    }
+
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return nil, err
+    }
+
+    is_admin, err := hasRole(stub, ROLE_ADMIN, caller)
+    if err != nil {
+        return nil, err
+    }
+    if is_admin {
+        return &RestraintPlan{Apply: true}, nil
+    }
+
+    if org_a == "" || org_b == "" {
+        return nil, fmt.Errorf("caller %s does not have role %s.", caller, ROLE_ADMIN)
+    }
+
+    admin_a, err := isOrganizationAdmin(stub, org_a, caller)
+    if err != nil {
+        return nil, err
+    }
+
+    admin_b, err := isOrganizationAdmin(stub, org_b, caller)
+    if err != nil {
+        return nil, err
+    }
+
+    if admin_a && admin_b {
+        return &RestraintPlan{Apply: true}, nil
+    }
+    if !admin_a && !admin_b {
+        return nil, fmt.Errorf("caller %s is not an admin of org %s or %s.", caller, org_a, org_b)
+    }
+
+    caller_org, other_org := org_a, org_b
+    if admin_b {
+        caller_org, other_org = org_b, org_a
+    }
+
+    if username_a > username_b {
+        username_a, username_b = username_b, username_a
+        org_a, org_b = org_b, org_a
+        restraint = restraint.reverse()
+    }
+
+    pending_key, err := stub.CreateCompositeKey("o_p:", []string{username_a, username_b})
+    if err != nil {
+        return nil, fmt.Errorf("username_a or username_b is not valid. %s", err.Error())
+    }
+
+    pending, err := getPendingRestraint(stub, pending_key)
+    if err != nil {
+        return nil, err
+    }
+
+    if pending != nil && pending.Restraint == string(restraint) && pending.Approvals[other_org] != "" {
+        return &RestraintPlan{Apply: true, PendingKey: pending_key, DelPending: true}, nil
+    }
+
+    return &RestraintPlan{
+        PendingKey: pending_key,
+        Pending: &PendingRestraint{
+            UsernameA: username_a,
+            UsernameB: username_b,
+            Restraint: string(restraint),
+            OrgA: org_a,
+            OrgB: org_b,
+            Approvals: map[string]string{caller_org: caller},
+        },
+    }, nil
+}
+
+func (p *RestraintPlan) commit(stub shim.ChaincodeStubInterface) error {
+    if p.DelPending {
+        if err := stub.DelState(p.PendingKey); err != nil {
+            return fmt.Errorf("Failed to del state. %s", err.Error())
+        }
+    }
+
+    if p.Pending == nil {
+        return nil
+    }
+
+    pendingAsBytes, err := json.Marshal(p.Pending)
+    if err != nil {
+        return fmt.Errorf("Failed to format pending restraint. %s", err.Error())
+    }
+
+    err = stub.PutState(p.PendingKey, pendingAsBytes)
+    if err != nil {
+        return fmt.Errorf("Failed to put state. %s", err.Error())
+    }
+
+    return nil
+}
+
+// 检查调用者能否设置 username_a 和 username_b 之间的约束并记录待确认的约束
+// 返回 true 表示可以直接写入约束，返回 false 表示已记录为待确认
+func checkRestraintAuthority(stub shim.ChaincodeStubInterface, username_a, username_b string, restraint RestraintType) (bool, error) {
+    plan, err := planRestraint(stub, username_a, username_b, restraint)
+    if err != nil {
+        return false, err
+    }
+
+    err = plan.commit(stub)
+    if err != nil {
+        return false, err
+    }
+
+    return plan.Apply, nil
+}
+
+// 创建组织，只有 admin 可以调用
+// 返回值：nil
+func (cc *RestrainedTransferCC) createOrganization(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'createOrganization', args: ['org', 'extras']}"`)
+    }
+
+    org := strings.TrimSpace(args[0])
+    extras := args[1]
+
+    if len(org) == 0 {
+        return shim.Error("org should not be empty.")
+    }
+
+    var err error
+
+    if err = checkCallerRole(stub, ROLE_ADMIN); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = validateExtras(extras); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    org_info_key, err := stub.CreateCompositeKey("o_i:", []string{org})
+    if err != nil {
+        return shim.Error("org is not valid. " + err.Error())
+    }
+
+    orgAsBytes, err := stub.GetState(org_info_key)
+    if err != nil {
+        return shim.Error("Failed to get state. " + err.Error())
+    }
+    if orgAsBytes != nil {
+        return shim.Error("org " + org + " already created.")
+    }
+
+    orgAsBytes, err = json.Marshal(MAP{
+        "name": org,
+        "extras": extras,
+    })
+    if err != nil {
+        return shim.Error("Failed to format organization info. " + err.Error())
+    }
+
+    err = stub.PutState(org_info_key, orgAsBytes)
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询组织信息
+// 返回值：json字符串
+// {"name":"org_a","extras":"balabala","admins":["身份哈希"]}
+func (cc *RestrainedTransferCC) getOrganizationInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getOrganizationInfo', args: ['org']}"`)
+    }
+
+    org := strings.TrimSpace(args[0])
+
+    org_info_key, err := stub.CreateCompositeKey("o_i:", []string{org})
+    if err != nil {
+        return shim.Error("org is not valid. " + err.Error())
+    }
+
+    orgAsBytes, err := stub.GetState(org_info_key)
+    if err != nil {
+        return shim.Error("Failed to get state. " + err.Error())
+    }
+    if orgAsBytes == nil {
+        return shim.Error("org " + org + " is not created.")
+    }
+
+    var info MAP
+    err = json.Unmarshal(orgAsBytes, &info)
+    if err != nil {
+        return shim.Error("Failed to parse organization info stored. " + err.Error())
+    }
+
+    info["admins"], err = listOrganizationEntries(stub, "o_a:", org)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    infoAsBytes, err := json.Marshal(info)
+    if err != nil {
+        return shim.Error("Failed to format organization info. " + err.Error())
+    }
+
+    return shim.Success(infoAsBytes)
+}
+
+// 任命或撤销组织管理员，只有 admin 可以调用
+// action 为 add 或 remove
+// 返回值：nil
+func (cc *RestrainedTransferCC) setOrganizationAdmin(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 3 {
+        return shim.Error(`parameter error. usage: "{fcn: 'setOrganizationAdmin', args: ['org', 'identity', 'add|remove']}"`)
+    }
+
+    org := strings.TrimSpace(args[0])
+    identity := strings.TrimSpace(args[1])
+    action := strings.TrimSpace(args[2])
+
+    if len(identity) == 0 {
+        return shim.Error("identity should not be empty.")
+    }
+    if action != "add" && action != "remove" {
+        return shim.Error("action got " + action + ", expected add or remove")
+    }
+
+    var err error
+
+    if err = checkCallerRole(stub, ROLE_ADMIN); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkOrganizationCreated(stub, org); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    org_admin_key, err := stub.CreateCompositeKey("o_a:", []string{org, identity})
+    if err != nil {
+        return shim.Error("identity is not valid. " + err.Error())
+    }
+
+    if action == "remove" {
+        err = stub.DelState(org_admin_key)
+        if err != nil {
+            return shim.Error("Failed to del state. " + err.Error())
+        }
+        return shim.Success(nil)
+    }
+
+    err = stub.PutState(org_admin_key, []byte("1"))
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 将用户加入组织，admin 或组织管理员可以调用，组织管理员只能加入自己是所有者的用户
+// 返回值：nil
+func (cc *RestrainedTransferCC) addOrganizationMember(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'addOrganizationMember', args: ['org', 'username']}"`)
+    }
+
+    org := strings.TrimSpace(args[0])
+    username := strings.TrimSpace(args[1])
+
+    var err error
+
+    if err = checkOrganizationCreated(stub, org); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkRegistered(stub, "username", username); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    parent, err := getParentAccount(stub, username)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if parent != "" {
+        return shim.Error("username " + username + " is a sub-account, it belongs to the organization of " + parent + ".")
+    }
+
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    is_admin, err := hasRole(stub, ROLE_ADMIN, caller)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if !is_admin {
+        if err = checkCallerAdministers(stub, org); err != nil {
+            return shim.Error(err.Error())
+        }
+        if err = checkCallerOwns(stub, username); err != nil {
+            return shim.Error(err.Error())
+        }
+    }
+
+    current, err := getOrganizationOf(stub, username)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if current == org {
+        return shim.Success(nil)
+    }
+    if current != "" {
+        return shim.Error("username " + username + " already belongs to org " + current + ".")
+    }
+
+    member_key, err := stub.CreateCompositeKey("o_m:", []string{org, username})
+    if err != nil {
+        return shim.Error("org or username is not valid. " + err.Error())
+    }
+
+    user_org_key, err := stub.CreateCompositeKey("u_og:", []string{username})
+    if err != nil {
+        return shim.Error("username is not valid. " + err.Error())
+    }
+
+    err = stub.PutState(member_key, []byte(username))
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    err = stub.PutState(user_org_key, []byte(org))
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 将用户移出组织，admin 或组织管理员可以调用
+// 返回值：nil
+func (cc *RestrainedTransferCC) removeOrganizationMember(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'removeOrganizationMember', args: ['org', 'username']}"`)
+    }
+
+    org := strings.TrimSpace(args[0])
+    username := strings.TrimSpace(args[1])
+
+    var err error
+
+    if err = checkOrganizationCreated(stub, org); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkCallerAdministers(stub, org); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    // 子账户没有自己的成员记录，随父账户移出
+    parent, err := getParentAccount(stub, username)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if parent != "" {
+        return shim.Error("username " + username + " is a sub-account, it belongs to the organization of " + parent + ".")
+    }
+
+    current, err := getOrganizationOf(stub, username)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if current != org {
+        return shim.Error("username " + username + " is not a member of org " + org + ".")
+    }
+
+    member_key, err := stub.CreateCompositeKey("o_m:", []string{org, username})
+    if err != nil {
+        return shim.Error("org or username is not valid. " + err.Error())
+    }
+
+    user_org_key, err := stub.CreateCompositeKey("u_og:", []string{username})
+    if err != nil {
+        return shim.Error("username is not valid. " + err.Error())
+    }
+
+    err = stub.DelState(member_key)
+    if err != nil {
+        return shim.Error("Failed to del state. " + err.Error())
+    }
+
+    err = stub.DelState(user_org_key)
+    if err != nil {
+        return shim.Error("Failed to del state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询组织的所有成员用户
+// 返回值：json字符串
+// ["user_a","user_b"]
+func (cc *RestrainedTransferCC) getOrganizationUsers(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getOrganizationUsers', args: ['org']}"`)
+    }
+
+    org := strings.TrimSpace(args[0])
+
+    var err error
+
+    if err = checkOrganizationCreated(stub, org); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    members, err := listOrganizationEntries(stub, "o_m:", org)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    membersAsBytes, err := json.Marshal(members)
+    if err != nil {
+        return shim.Error("Failed to format organization members. " + err.Error())
+    }
+
+    return shim.Success(membersAsBytes)
+}
+
+// 查询组织成员的所有转账约束，包括与组织外用户之间的约束，枚举值的含义见 setRestraint
+// 返回值：json字符串
+// {
+//     "user_a":{"user_b":"1","user_x":"3"},
+//     "user_b":{"user_a":"2"}
+// }
+func (cc *RestrainedTransferCC) getOrganizationRestraints(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getOrganizationRestraints', args: ['org']}"`)
+    }
+
+    org := strings.TrimSpace(args[0])
+
+    var err error
+
+    if err = checkOrganizationCreated(stub, org); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    members, err := listOrganizationEntries(stub, "o_m:", org)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    restraints := MAP{}
+
+    for _, member := range members {
+        itr, err := stub.GetStateByPartialCompositeKey("u_r:", []string{member})
+        if err != nil {
+            return shim.Error("Failed to get state. " + err.Error())
+        }
+
+        memberRestraints := MAP{}
+        for itr.HasNext() {
+            kv, err := itr.Next()
+            if err != nil {
+                itr.Close()
+                return shim.Error("Failed to get restraint stored. " + err.Error())
+            }
+            _, compositeKeyParts, err := stub.SplitCompositeKey(kv.Key)
+            if err != nil {
+                itr.Close()
+                return shim.Error("Failed to parse restraint stored. " + err.Error())
+            }
+            if RestraintType(kv.Value[0]) == NONWAY {
+                continue
+            }
+            memberRestraints[compositeKeyParts[1]] = string(kv.Value)
+        }
+        itr.Close()
+
+        restraints[member] = memberRestraints
+    }
+
+    restraintsAsBytes, err := json.Marshal(restraints)
+    if err != nil {
+        return shim.Error("Failed to format organization restraints. " + err.Error())
+    }
+
+    return shim.Success(restraintsAsBytes)
+}
+
+// 查询涉及组织的所有待确认的跨组织约束
+// 返回值：json字符串
+// [{"username_a":"user_a","username_b":"user_x","restraint":"1","org_a":"org_a","org_b":"org_x","approvals":{"org_a":"身份哈希"}}]
+func (cc *RestrainedTransferCC) getPendingRestraints(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getPendingRestraints', args: ['org']}"`)
+    }
+
+    org := strings.TrimSpace(args[0])
+
+    var err error
+
+    if err = checkOrganizationCreated(stub, org); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    itr, err := stub.GetStateByPartialCompositeKey("o_p:", []string{})
+    if err != nil {
+        return shim.Error("Failed to get state. " + err.Error())
+    }
+    defer itr.Close()
+
+    pendings := []*PendingRestraint{}
+
+    for itr.HasNext() {
+        kv, err := itr.Next()
+        if err != nil {
+            return shim.Error("Failed to get pending restraint stored. " + err.Error())
+        }
+
+        var pending PendingRestraint
+        err = json.Unmarshal(kv.Value, &pending)
+        if err != nil {
+            return shim.Error("Failed to parse pending restraint stored. " + err.Error())
+        }
+
+        if pending.OrgA == org || pending.OrgB == org {
+            pendings = append(pendings, &pending)
+        }
+    }
+
+    pendingsAsBytes, err := json.Marshal(pendings)
+    if err != nil {
+        return shim.Error("Failed to format pending restraints. " + err.Error())
+    }
+
+    return shim.Success(pendingsAsBytes)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+    "testing"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestOrganizations(t *testing.T) {
+    stub := shim.NewMockStub("TestOrganizations", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testInvokeAsFail(t, stub, "bob", "createOrganization", "org_a", "")
+    testInvokeFail(t, stub, "createOrganization", "", "")
+    testInvoke(t, stub, "", "createOrganization", "org_a", "company A")
+    testInvoke(t, stub, "", "createOrganization", "org_b", "company B")
+    testInvokeFail(t, stub, "createOrganization", "org_a", "")
+
+    testInvokeAsFail(t, stub, "bob", "setOrganizationAdmin", "org_a", bobId, "add")
+    testInvokeFail(t, stub, "setOrganizationAdmin", "org_x", bobId, "add")
+    testInvokeFail(t, stub, "setOrganizationAdmin", "org_a", bobId, "grant")
+    testInvoke(t, stub, "", "setOrganizationAdmin", "org_a", bobId, "add")
+    testInvoke(t, stub, "", "setOrganizationAdmin", "org_b", carolId, "add")
+    testInvoke(t, stub, `{"admins":["` + bobId + `"],"extras":"company A","name":"org_a"}`, "getOrganizationInfo", "org_a")
+
+    testRegister(t, stub, "user_a1", "")
+    testRegister(t, stub, "user_a2", "")
+    testInvokeAs(t, stub, "bob", "", "register", "user_a3", "")
+    testRegister(t, stub, "user_b1", "")
+    testRegister(t, stub, "user_x", "")
+    testRegister(t, stub, "user_y", "")
+
+    // 组织管理员只能加入自己是所有者的用户
+    testInvokeAsFail(t, stub, "bob", "addOrganizationMember", "org_a", "user_a1")
+    testInvokeAsFail(t, stub, "carol", "addOrganizationMember", "org_a", "user_a3")
+    testInvokeAs(t, stub, "bob", "", "addOrganizationMember", "org_a", "user_a3")
+    // 设置了多跳转账意愿（见 routing.go）的用户不会被当作已加入组织
+    testInvoke(t, stub, "", "setRoutingOptIn", "user_a1", "true")
+    testInvoke(t, stub, "", "addOrganizationMember", "org_a", "user_a1")
+    testInvoke(t, stub, "", "addOrganizationMember", "org_a", "user_a2")
+    testInvoke(t, stub, "", "addOrganizationMember", "org_b", "user_b1")
+    testInvokeFail(t, stub, "addOrganizationMember", "org_b", "user_a1")
+    testInvokeFail(t, stub, "addOrganizationMember", "org_a", "user_z")
+    testInvoke(t, stub, `["user_a1","user_a2","user_a3"]`, "getOrganizationUsers", "org_a")
+
+    // 组织内的约束由组织管理员设置
+    testInvokeAs(t, stub, "bob", "", "setRestraint", "user_a1", "user_a2", "1")
+    testInvokeAsFail(t, stub, "carol", "setRestraint", "user_a1", "user_a2", "3")
+    testRecharge(t, stub, "user_a1", "100")
+    testTransfer(t, stub, "user_a1", "user_a2", "10")
+    testTransferFail(t, stub, "user_a2", "user_a1", "10")
+
+    // 子账户属于父账户所在的组织
+    testInvoke(t, stub, "user_a1/savings", "openSubAccount", "user_a1", "savings", "")
+    testInvokeFail(t, stub, "addOrganizationMember", "org_b", "user_a1/savings")
+    testInvokeAs(t, stub, "bob", "", "setRestraint", "user_a2", "user_a1/savings", "1")
+
+    // 跨组织的约束需要双方组织的管理员确认
+    testInvokeAs(t, stub, "bob", "", "setRestraint", "user_b1", "user_a1", "1")
+    testRecharge(t, stub, "user_b1", "100")
+    testTransferFail(t, stub, "user_b1", "user_a1", "10")
+    pending := `[{"username_a":"user_a1","username_b":"user_b1","restraint":"2","org_a":"org_a","org_b":"org_b","approvals":{"org_a":"` + bobId + `"}}]`
+    testInvoke(t, stub, pending, "getPendingRestraints", "org_b")
+    testInvoke(t, stub, pending, "getPendingRestraints", "org_a")
+    testInvokeAs(t, stub, "bob", "", "setRestraint", "user_a1", "user_b1", "2")
+    testTransferFail(t, stub, "user_b1", "user_a1", "10")
+    testInvokeAs(t, stub, "carol", "", "setRestraint", "user_a1", "user_b1", "2")
+    testTransfer(t, stub, "user_b1", "user_a1", "10")
+    testInvoke(t, stub, `[]`, "getPendingRestraints", "org_b")
+
+    // 不同的约束替换待确认记录
+    testInvokeAs(t, stub, "bob", "", "setRestraint", "user_a1", "user_b1", "3")
+    testInvokeAs(t, stub, "carol", "", "setRestraint", "user_a1", "user_b1", "0")
+    testTransfer(t, stub, "user_b1", "user_a1", "10")
+    testInvoke(t, stub, `[{"username_a":"user_a1","username_b":"user_b1","restraint":"0","org_a":"org_a","org_b":"org_b","approvals":{"org_b":"` + carolId + `"}}]`,
+        "getPendingRestraints", "org_a")
+    testInvokeAs(t, stub, "bob", "", "setRestraint", "user_b1", "user_a1", "0")
+    testTransferFail(t, stub, "user_b1", "user_a1", "10")
+
+    // 一方不属于组织时只有 admin 可以设置，双方都不属于组织时不检查
+    testInvokeAsFail(t, stub, "bob", "setRestraint", "user_a1", "user_x", "3")
+    testSetRestraint(t, stub, "user_a1", "user_x", "3")
+    testInvokeAs(t, stub, "bob", "", "setRestraint", "user_x", "user_y", "3")
+
+    testInvoke(t, stub, `{"user_a1":{"user_a2":"1","user_x":"3"},"user_a2":{"user_a1":"2","user_a1/savings":"1"},"user_a3":{}}`,
+        "getOrganizationRestraints", "org_a")
+    testInvokeFail(t, stub, "getOrganizationRestraints", "org_x")
+
+    // 移出组织
+    testInvokeAsFail(t, stub, "carol", "removeOrganizationMember", "org_a", "user_a2")
+    testInvokeFail(t, stub, "removeOrganizationMember", "org_b", "user_a2")
+    // 子账户随父账户移出，不能单独移出
+    testInvokeAsFail(t, stub, "bob", "removeOrganizationMember", "org_a", "user_a1/savings")
+    testInvokeAs(t, stub, "bob", "", "setRestraint", "user_a2", "user_a1/savings", "3")
+    testInvokeAs(t, stub, "bob", "", "removeOrganizationMember", "org_a", "user_a2")
+    testInvoke(t, stub, `["user_a1","user_a3"]`, "getOrganizationUsers", "org_a")
+    testInvokeAsFail(t, stub, "bob", "setRestraint", "user_a1", "user_a2", "3")
+
+    testInvoke(t, stub, "", "setOrganizationAdmin", "org_a", bobId, "remove")
+    testInvokeAsFail(t, stub, "bob", "setRestraint", "user_a1", "user_a3", "3")
+}
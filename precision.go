@@ -0,0 +1,186 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+
+    "github.com/shopspring/decimal"
+)
+
+// 金额精度
+// 每种资产的金额规则存储在 ap: 下，key 为 [资产]，由 admin 通过 setAssetPolicy 设置：
+// max_scale 为最多的小数位数，max_amount 为单笔金额的上限（"" 表示不限制），
+// rounding 为小数位数超过 max_scale 时的处理方式：reject 拒绝，truncate 截断，half_up 四舍五入，banker 银行家舍入（四舍六入五成双）。
+// 没有设置时使用默认规则：max_scale 为 DEFAULT_MAX_SCALE，不限制金额，rounding 为 reject。
+// 充值、提款、转账等接收金额参数的函数都通过 validateAmount（见 validation.go）检查金额，舍入后为 0 的金额被拒绝。
+// 余额和金额都以 decimal 的 String() 格式存储：没有指数，没有多余的 0（如 1.50 存储为 1.5），所有客户端看到的字符串一致。
+// 修改规则不会改变已经存储的余额。
+
+const (
+    ROUNDING_REJECT   = "reject"
+    ROUNDING_TRUNCATE = "truncate"
+    ROUNDING_HALF_UP  = "half_up"
+    ROUNDING_BANKER   = "banker"
+)
+
+// 没有设置金额规则时最多的小数位数
+const DEFAULT_MAX_SCALE = 18
+
+type AssetPolicy struct {
+    MaxScale  int32  `json:"max_scale"`
+    MaxAmount string `json:"max_amount"`
+    Rounding  string `json:"rounding"`
+}
+
+// 查询 asset 的金额规则，没有设置时返回默认规则
+func getAssetPolicy(stub shim.ChaincodeStubInterface, asset string) (AssetPolicy, error) {
+    policy_key, err := stub.CreateCompositeKey("ap:", []string{asset})
+    if err != nil {
+        return AssetPolicy{}, fmt.Errorf("asset is not valid. %s", err.Error())
+    }
+
+    policyAsBytes, err := stub.GetState(policy_key)
+    if err != nil {
+        return AssetPolicy{}, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if policyAsBytes == nil {
+        return AssetPolicy{MaxScale: DEFAULT_MAX_SCALE, Rounding: ROUNDING_REJECT}, nil
+    }
+
+    var policy AssetPolicy
+    err = json.Unmarshal(policyAsBytes, &policy)
+    if err != nil {
+        return AssetPolicy{}, fmt.Errorf("Failed to parse asset policy stored. %s", err.Error())
+    }
+
+    return policy, nil
+}
+
+// 按 asset 的金额规则检查 amount，返回舍入后的金额
+func applyAmountPolicy(stub shim.ChaincodeStubInterface, asset string, amount decimal.Decimal) (decimal.Decimal, error) {
+    policy, err := getAssetPolicy(stub, asset)
+    if err != nil {
+        return decimal.Zero, err
+    }
+
+    rounded := amount
+    if !amount.Truncate(policy.MaxScale).Equal(amount) {
+        switch policy.Rounding {
+        case ROUNDING_TRUNCATE:
+            rounded = amount.Truncate(policy.MaxScale)
+        case ROUNDING_HALF_UP:
+            rounded = amount.Round(policy.MaxScale)
+        case ROUNDING_BANKER:
+            rounded = amount.RoundBank(policy.MaxScale)
+        default:
+            return decimal.Zero, validationError(ERR_AMOUNT_PRECISION, "amount %s has more than %d decimal places", amount.String(), policy.MaxScale)
+        }
+        if rounded.IsZero() {
+            return decimal.Zero, validationError(ERR_AMOUNT_PRECISION, "amount %s rounds to 0", amount.String())
+        }
+    }
+
+    if policy.MaxAmount != "" {
+        max_amount, err := decimal.NewFromString(policy.MaxAmount)
+        if err != nil {
+            return decimal.Zero, fmt.Errorf("Failed to parse asset policy stored. %s", err.Error())
+        }
+        if rounded.GreaterThan(max_amount) {
+            return decimal.Zero, validationError(ERR_AMOUNT_OUT_OF_RANGE, "amount %s exceeds max amount %s of asset %s", rounded.String(), policy.MaxAmount, asset)
+        }
+    }
+
+    return rounded, nil
+}
+
+// 设置资产的金额规则，只有 admin 可以调用
+// max_scale 为 0 到 DEFAULT_MAX_SCALE 的整数，max_amount 为 "" 或大于 0 的数，rounding 为 reject、truncate、half_up 或 banker
+// 返回值：nil
+func (cc *RestrainedTransferCC) setAssetPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 4 {
+        return shim.Error(`parameter error. usage: "{fcn: 'setAssetPolicy', args: ['asset', 'max_scale', 'max_amount', 'rounding']}"`)
+    }
+
+    asset := strings.TrimSpace(args[0])
+    max_scale_str := strings.TrimSpace(args[1])
+    max_amount_str := strings.TrimSpace(args[2])
+    rounding := strings.TrimSpace(args[3])
+
+    if len(asset) == 0 {
+        return shim.Error("asset should not be empty.")
+    }
+
+    var err error
+
+    max_scale, err := strconv.Atoi(max_scale_str)
+    if err != nil || max_scale < 0 || max_scale > DEFAULT_MAX_SCALE {
+        return shim.Error(fmt.Sprintf("max_scale got %s, expected an integer in [0, %d]", max_scale_str, DEFAULT_MAX_SCALE))
+    }
+
+    if max_amount_str != "" {
+        max_amount, err := decimal.NewFromString(max_amount_str)
+        if err != nil || max_amount.LessThanOrEqual(decimal.Zero) {
+            return shim.Error("Invalid max_amount, expecting a number greater than 0.")
+        }
+        max_amount_str = max_amount.String()
+    }
+
+    switch rounding {
+    case ROUNDING_REJECT, ROUNDING_TRUNCATE, ROUNDING_HALF_UP, ROUNDING_BANKER:
+    default:
+        return shim.Error("rounding got " + rounding + ", expected reject, truncate, half_up or banker")
+    }
+
+    if err = checkCallerRole(stub, ROLE_ADMIN); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    policy_key, err := stub.CreateCompositeKey("ap:", []string{asset})
+    if err != nil {
+        return shim.Error("asset is not valid. " + err.Error())
+    }
+
+    policyAsBytes, err := json.Marshal(AssetPolicy{
+        MaxScale: int32(max_scale),
+        MaxAmount: max_amount_str,
+        Rounding: rounding,
+    })
+    if err != nil {
+        return shim.Error("Failed to format asset policy. " + err.Error())
+    }
+
+    err = stub.PutState(policy_key, policyAsBytes)
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询资产的金额规则，没有设置时返回默认规则
+// 返回值：json字符串
+// {"max_scale":2,"max_amount":"1000000","rounding":"half_up"}
+func (cc *RestrainedTransferCC) getAssetPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getAssetPolicy', args: ['asset']}"`)
+    }
+
+    asset := strings.TrimSpace(args[0])
+
+    policy, err := getAssetPolicy(stub, asset)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    policyAsBytes, err := json.Marshal(policy)
+    if err != nil {
+        return shim.Error("Failed to format asset policy. " + err.Error())
+    }
+
+    return shim.Success(policyAsBytes)
+}
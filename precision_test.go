@@ -0,0 +1,62 @@
+package main
+
+import (
+    "testing"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestAssetPolicy(t *testing.T) {
+    stub := shim.NewMockStub("TestAssetPolicy", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "user_a", "")
+    testRegister(t, stub, "user_b", "")
+    testSetRestraint(t, stub, "user_a", "user_b", "3")
+
+    // 默认规则：最多 18 位小数，不限制金额，存储为规范格式
+    testInvoke(t, stub, `{"max_scale":18,"max_amount":"","rounding":"reject"}`, "getAssetPolicy", "default")
+    testRechargeFail(t, stub, "user_a", "0.0000000000000000001")
+    testRecharge(t, stub, "user_a", "1.50")
+    testRecharge(t, stub, "user_a", "1e2")
+    testGetBalance(t, stub, "user_a", "101.5")
+
+    testInvokeFail(t, stub, "setAssetPolicy", "default", "19", "", "reject")
+    testInvokeFail(t, stub, "setAssetPolicy", "default", "2", "0", "reject")
+    testInvokeFail(t, stub, "setAssetPolicy", "default", "2", "", "ceil")
+    testInvokeAsFail(t, stub, "bob", "setAssetPolicy", "default", "2", "", "reject")
+
+    testInvoke(t, stub, "", "setAssetPolicy", "default", "2", "1e3", "reject")
+    testInvoke(t, stub, `{"max_scale":2,"max_amount":"1000","rounding":"reject"}`, "getAssetPolicy", "default")
+    testRechargeFail(t, stub, "user_a", "0.001")
+    testRechargeFail(t, stub, "user_a", "1e30")
+    testRecharge(t, stub, "user_a", "1000.000")
+    testTransferFail(t, stub, "user_a", "user_b", "0.125")
+    testWithdrawFail(t, stub, "user_a", "0.125")
+
+    // 四舍五入
+    testInvoke(t, stub, "", "setAssetPolicy", "default", "2", "", "half_up")
+    testTransfer(t, stub, "user_a", "user_b", "0.125")
+    testGetBalance(t, stub, "user_b", "0.13")
+
+    // 银行家舍入
+    testInvoke(t, stub, "", "setAssetPolicy", "default", "2", "", "banker")
+    testTransfer(t, stub, "user_a", "user_b", "0.125")
+    testGetBalance(t, stub, "user_b", "0.25")
+
+    // 截断，截断后为 0 时拒绝
+    testInvoke(t, stub, "", "setAssetPolicy", "default", "2", "", "truncate")
+    testWithdraw(t, stub, "user_a", "0.129")
+    testWithdrawFail(t, stub, "user_a", "0.009")
+    testGetBalance(t, stub, "user_a", "1101.13")
+
+    // 兑换结果按目标资产的小数位数向下取整
+    testInvoke(t, stub, "", "grantRole", "operator", mockCallerId)
+    testInvoke(t, stub, "", "grantRole", "oracle", mockCallerId)
+    testInvoke(t, stub, "", "setAssetPolicy", "usd", "4", "", "reject")
+    testInvokeFail(t, stub, "rechargeAsset", "user_a", "usd", "1.00001")
+    testInvoke(t, stub, "", "rechargeAsset", "user_a", "usd", "10")
+    testInvokeAt(t, stub, "", 1537776000, "", "setExchangeRate", "usd", "default", "6.999", "3600")
+    testInvokeAt(t, stub, "", 1537776000, `{"id":"1","from":"user_a","to":"user_b","from_asset":"usd","to_asset":"default","amount":"1.2345",` +
+        `"rate":"6.999","rate_posted_at":1537776000,"credited":"8.64","timestamp":1537776000}`,
+        "transferWithConversion", "user_a", "user_b", "usd", "default", "1.2345")
+}
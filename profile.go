@@ -0,0 +1,494 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+    "reflect"
+    "strings"
+    "unicode/utf8"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// 用户资料
+// 用户的 extras 存储在 u_i: 的 json 中，register 之后可以由用户的所有者或 admin 通过 updateUserInfo 修改：
+// merge 模式下 extras 必须是 json 对象，按字段合并，值为 null 的字段被删除；replace 模式下整体替换。
+// admin 可以通过 setUserInfoSchema 在链上配置 extras 的格式，配置后 register 和 updateUserInfo 的 extras 都必须是满足格式的 json 对象。
+// 格式为 json schema 的子集：
+// {
+//     "type": "object",
+//     "properties": {"company": {"type":"string","maxLength":64}, "level": {"type":"integer","minimum":1,"maximum":5}, "region": {"enum":["north","south"]}},
+//     "required": ["company"],
+//     "additionalProperties": false
+// }
+// type 可以是 string、number、integer、boolean、object、array；maxLength 只用于字符串，minimum 和 maximum 只用于数字。
+// 格式存储在 u_s: 下。每次修改记录在 u_h: 下，key 为修改 id（规则同转账 id），按用户索引在 u_hi: 下，key 为 [用户名, %020d 修改时间, 修改 id]。
+
+const (
+    PROFILE_MERGE   = "merge"
+    PROFILE_REPLACE = "replace"
+)
+
+type ExtrasFieldSchema struct {
+    Type      string        `json:"type,omitempty"`
+    MaxLength *int          `json:"maxLength,omitempty"`
+    Minimum   *float64      `json:"minimum,omitempty"`
+    Maximum   *float64      `json:"maximum,omitempty"`
+    Enum      []interface{} `json:"enum,omitempty"`
+}
+
+type ExtrasSchema struct {
+    Type                 string                       `json:"type,omitempty"`
+    Properties           map[string]ExtrasFieldSchema `json:"properties"`
+    Required             []string                     `json:"required"`
+    AdditionalProperties *bool                        `json:"additionalProperties,omitempty"`
+}
+
+type ProfileChange struct {
+    Id        string   `json:"id"`
+    Username  string   `json:"username"`
+    Fields    []string `json:"fields"`
+    OldExtras string   `json:"old_extras"`
+    NewExtras string   `json:"new_extras"`
+    ChangedBy string   `json:"changed_by"`
+    ChangedAt int64    `json:"changed_at"`
+}
+
+// 查询 extras 的格式，没有配置时返回 nil
+func getExtrasSchema(stub shim.ChaincodeStubInterface) (*ExtrasSchema, error) {
+    schema_key, err := stub.CreateCompositeKey("u_s:", []string{})
+    if err != nil {
+        return nil, fmt.Errorf("Failed to create key. %s", err.Error())
+    }
+
+    schemaAsBytes, err := stub.GetState(schema_key)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if schemaAsBytes == nil {
+        return nil, nil
+    }
+
+    var schema ExtrasSchema
+    err = json.Unmarshal(schemaAsBytes, &schema)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to parse user info schema stored. %s", err.Error())
+    }
+
+    return &schema, nil
+}
+
+// 把 extras 解析为 json 对象，extras 为 "" 时返回空对象
+func parseExtrasObject(extras string) (map[string]interface{}, error) {
+    fields := map[string]interface{}{}
+    if strings.TrimSpace(extras) == "" {
+        return fields, nil
+    }
+
+    err := json.Unmarshal([]byte(extras), &fields)
+    if err != nil || fields == nil {
+        return nil, validationError(ERR_INVALID_EXTRAS, "extras is not a json object")
+    }
+
+    return fields, nil
+}
+
+func (f ExtrasFieldSchema) check(name string, value interface{}) error {
+    switch f.Type {
+    case "":
+    case "string", "number", "integer", "boolean", "object", "array":
+        ok := false
+        switch v := value.(type) {
+        case string:
+            ok = f.Type == "string"
+        case float64:
+            ok = f.Type == "number" || (f.Type == "integer" && v == float64(int64(v)))
+        case bool:
+            ok = f.Type == "boolean"
+        case map[string]interface{}:
+            ok = f.Type == "object"
+        case []interface{}:
+            ok = f.Type == "array"
+        }
+        if !ok {
+            return validationError(ERR_INVALID_EXTRAS, "extras field %s should be %s", name, f.Type)
+        }
+    default:
+        return fmt.Errorf("unknown type %s of field %s in user info schema.", f.Type, name)
+    }
+
+    if s, ok := value.(string); ok && f.MaxLength != nil && utf8.RuneCountInString(s) > *f.MaxLength {
+        return validationError(ERR_INVALID_EXTRAS, "extras field %s should have at most %d characters", name, *f.MaxLength)
+    }
+
+    if n, ok := value.(float64); ok {
+        if f.Minimum != nil && n < *f.Minimum {
+            return validationError(ERR_INVALID_EXTRAS, "extras field %s should not be less than %v", name, *f.Minimum)
+        }
+        if f.Maximum != nil && n > *f.Maximum {
+            return validationError(ERR_INVALID_EXTRAS, "extras field %s should not be greater than %v", name, *f.Maximum)
+        }
+    }
+
+    if len(f.Enum) > 0 {
+        for _, allowed := range f.Enum {
+            if reflect.DeepEqual(allowed, value) {
+                return nil
+            }
+        }
+        return validationError(ERR_INVALID_EXTRAS, "extras field %s is not one of the allowed values", name)
+    }
+
+    return nil
+}
+
+func (s *ExtrasSchema) check(fields map[string]interface{}) error {
+    for _, name := range s.Required {
+        if _, ok := fields[name]; !ok {
+            return validationError(ERR_INVALID_EXTRAS, "extras field %s is required", name)
+        }
+    }
+
+    for name, value := range fields {
+        field, ok := s.Properties[name]
+        if !ok {
+            if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+                return validationError(ERR_INVALID_EXTRAS, "extras field %s is not allowed", name)
+            }
+            continue
+        }
+        if err := field.check(name, value); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// 检查 extras：大小和 json 的限制（见 validation.go），配置了格式时还要满足格式
+func checkExtras(stub shim.ChaincodeStubInterface, extras string) error {
+    if err := validateExtras(extras); err != nil {
+        return err
+    }
+
+    schema, err := getExtrasSchema(stub)
+    if err != nil {
+        return err
+    }
+    if schema == nil {
+        return nil
+    }
+
+    fields, err := parseExtrasObject(extras)
+    if err != nil {
+        return err
+    }
+
+    return schema.check(fields)
+}
+
+// 配置 extras 的格式，只有 admin 可以调用，schema 为 "" 时删除格式
+// 已经注册的用户不会重新检查，修改时才按新的格式检查
+// 返回值：nil
+func (cc *RestrainedTransferCC) setUserInfoSchema(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'setUserInfoSchema', args: ['schema']}"`)
+    }
+
+    var err error
+
+    var schemaAsBytes []byte
+    if strings.TrimSpace(args[0]) != "" {
+        decoder := json.NewDecoder(strings.NewReader(args[0]))
+        decoder.DisallowUnknownFields()
+
+        var schema ExtrasSchema
+        err = decoder.Decode(&schema)
+        if err != nil {
+            return shim.Error("Invalid user info schema, expecting json. " + err.Error())
+        }
+
+        if schema.Type != "" && schema.Type != "object" {
+            return shim.Error("Invalid user info schema, type should be object.")
+        }
+
+        for name, field := range schema.Properties {
+            switch field.Type {
+            case "", "string", "number", "integer", "boolean", "object", "array":
+            default:
+                return shim.Error("Invalid user info schema, unknown type " + field.Type + " of field " + name + ".")
+            }
+        }
+
+        schemaAsBytes, err = json.Marshal(schema)
+        if err != nil {
+            return shim.Error("Failed to format user info schema. " + err.Error())
+        }
+    }
+
+    if err = checkCallerRole(stub, ROLE_ADMIN); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    schema_key, err := stub.CreateCompositeKey("u_s:", []string{})
+    if err != nil {
+        return shim.Error("Failed to create key. " + err.Error())
+    }
+
+    if schemaAsBytes == nil {
+        err = stub.DelState(schema_key)
+        if err != nil {
+            return shim.Error("Failed to del state. " + err.Error())
+        }
+        return shim.Success(nil)
+    }
+
+    err = stub.PutState(schema_key, schemaAsBytes)
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询 extras 的格式
+// 返回值：json字符串，格式见 profile.go；没有配置时返回 nil
+func (cc *RestrainedTransferCC) getUserInfoSchema(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 0 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getUserInfoSchema', args: []}"`)
+    }
+
+    schema, err := getExtrasSchema(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if schema == nil {
+        return shim.Success(nil)
+    }
+
+    schemaAsBytes, err := json.Marshal(schema)
+    if err != nil {
+        return shim.Error("Failed to format user info schema. " + err.Error())
+    }
+
+    return shim.Success(schemaAsBytes)
+}
+
+// 修改用户的 extras，只有用户的所有者或 admin 可以调用
+// mode 可以省略，默认为 merge：fields 为 json 对象，按字段合并到 extras 中，值为 null 的字段被删除；
+// mode 为 replace 时 fields 整体替换 extras
+// 返回值：nil，extras 没有变化时不记录修改
+func (cc *RestrainedTransferCC) updateUserInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 && len(args) != 3 {
+        return shim.Error(`parameter error. usage: "{fcn: 'updateUserInfo', args: ['username', 'fields', 'mode'?]}"`)
+    }
+
+    username := strings.TrimSpace(args[0])
+    fields_str := args[1]
+
+    mode := PROFILE_MERGE
+    if len(args) == 3 {
+        mode = strings.TrimSpace(args[2])
+    }
+    if mode != PROFILE_MERGE && mode != PROFILE_REPLACE {
+        return shim.Error("mode got " + mode + ", expected merge or replace")
+    }
+
+    var err error
+
+    user_info_key, err := stub.CreateCompositeKey("u_i:", []string{username})
+    if err != nil {
+        return shim.Error("username is not valid. " + err.Error())
+    }
+
+    userAsBytes, err := stub.GetState(user_info_key)
+    if err != nil {
+        return shim.Error("Failed to get state. " + err.Error())
+    }
+    if userAsBytes == nil {
+        return shim.Error("username " + username + " is not registered.")
+    }
+
+    if err = checkCallerOwns(stub, username); err != nil {
+        if checkCallerRole(stub, ROLE_ADMIN) != nil {
+            return shim.Error(err.Error())
+        }
+    }
+
+    var info map[string]interface{}
+    err = json.Unmarshal(userAsBytes, &info)
+    if err != nil {
+        return shim.Error("Failed to parse user info stored. " + err.Error())
+    }
+
+    old_extras, _ := info["extras"].(string)
+    new_extras := fields_str
+    changed := []string{}
+
+    if mode == PROFILE_MERGE {
+        var updates map[string]interface{}
+        err = json.Unmarshal([]byte(fields_str), &updates)
+        if err != nil || updates == nil {
+            return shim.Error(validationError(ERR_INVALID_EXTRAS, "fields is not a json object").Error())
+        }
+
+        extras, err := parseExtrasObject(old_extras)
+        if err != nil {
+            return shim.Error(fmt.Sprintf("extras of %s is not a json object, use replace.", username))
+        }
+
+        for name, value := range updates {
+            old_value, ok := extras[name]
+            if value == nil {
+                if !ok {
+                    continue
+                }
+                delete(extras, name)
+            } else {
+                if ok && reflect.DeepEqual(old_value, value) {
+                    continue
+                }
+                extras[name] = value
+            }
+            changed = append(changed, name)
+        }
+
+        extrasAsBytes, err := json.Marshal(extras)
+        if err != nil {
+            return shim.Error("Failed to format extras. " + err.Error())
+        }
+        new_extras = string(extrasAsBytes)
+    } else if new_extras != old_extras {
+        changed = append(changed, "extras")
+    }
+
+    if len(changed) == 0 {
+        return shim.Success(nil)
+    }
+
+    if err = checkExtras(stub, new_extras); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    info["extras"] = new_extras
+
+    userAsBytes, err = json.Marshal(info)
+    if err != nil {
+        return shim.Error("Failed to format user info. " + err.Error())
+    }
+
+    err = stub.PutState(user_info_key, userAsBytes)
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    id, err := newTxScopedId(stub, "u_h:")
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    sort.Strings(changed)
+
+    changeAsBytes, err := json.Marshal(ProfileChange{
+        Id: id,
+        Username: username,
+        Fields: changed,
+        OldExtras: old_extras,
+        NewExtras: new_extras,
+        ChangedBy: caller,
+        ChangedAt: now.Unix(),
+    })
+    if err != nil {
+        return shim.Error("Failed to format user info change. " + err.Error())
+    }
+
+    change_key, err := stub.CreateCompositeKey("u_h:", []string{id})
+    if err != nil {
+        return shim.Error("change id is not valid. " + err.Error())
+    }
+
+    err = stub.PutState(change_key, changeAsBytes)
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    index_key, err := stub.CreateCompositeKey("u_hi:", []string{username, fmt.Sprintf("%020d", now.Unix()), id})
+    if err != nil {
+        return shim.Error("username is not valid. " + err.Error())
+    }
+
+    err = stub.PutState(index_key, []byte{0x00})
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询用户资料的修改记录，按修改时间排序
+// 返回值：json字符串
+// [{"id":"txid","username":"user_a","fields":["company"],"old_extras":"{\"company\":\"A\"}","new_extras":"{\"company\":\"B\"}","changed_by":"...","changed_at":1537776000}]
+func (cc *RestrainedTransferCC) getUserInfoHistory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getUserInfoHistory', args: ['username']}"`)
+    }
+
+    username := strings.TrimSpace(args[0])
+
+    var err error
+
+    if err = checkRegistered(stub, "username", username); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    itr, err := stub.GetStateByPartialCompositeKey("u_hi:", []string{username})
+    if err != nil {
+        return shim.Error("Failed to get state. " + err.Error())
+    }
+    defer itr.Close()
+
+    changes := []json.RawMessage{}
+
+    for itr.HasNext() {
+        kv, err := itr.Next()
+        if err != nil {
+            return shim.Error("Failed to get user info change stored. " + err.Error())
+        }
+        _, compositeKeyParts, err := stub.SplitCompositeKey(kv.Key)
+        if err != nil {
+            return shim.Error("Failed to parse user info change stored. " + err.Error())
+        }
+
+        change_key, err := stub.CreateCompositeKey("u_h:", []string{compositeKeyParts[2]})
+        if err != nil {
+            return shim.Error("change id is not valid. " + err.Error())
+        }
+
+        changeAsBytes, err := stub.GetState(change_key)
+        if err != nil {
+            return shim.Error("Failed to get state. " + err.Error())
+        }
+        if changeAsBytes != nil {
+            changes = append(changes, json.RawMessage(changeAsBytes))
+        }
+    }
+
+    changesAsBytes, err := json.Marshal(changes)
+    if err != nil {
+        return shim.Error("Failed to format user info changes. " + err.Error())
+    }
+
+    return shim.Success(changesAsBytes)
+}
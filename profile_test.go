@@ -0,0 +1,88 @@
+package main
+
+import (
+    "testing"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestUpdateUserInfo(t *testing.T) {
+    stub := shim.NewMockStub("TestUpdateUserInfo", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "user_a", "company A")
+    testRegister(t, stub, "user_b", `{"company":"B"}`)
+
+    day := int64(1537776000)
+
+    // 纯文本的 extras 只能整体替换
+    testInvokeFail(t, stub, "updateUserInfo", "user_a", `{"company":"A"}`)
+    testInvokeFail(t, stub, "updateUserInfo", "user_a", `{"company":"A"}`, "append")
+    testInvokeFail(t, stub, "updateUserInfo", "user_x", `{"company":"A"}`, "replace")
+    testInvokeAt(t, stub, "", day, "", "updateUserInfo", "user_a", `{"company":"A"}`, "replace")
+    testGetUserInfo(t, stub, "user_a", `{"extras":"{\"company\":\"A\"}","name":"user_a"}`)
+
+    // 按字段合并和删除
+    testInvokeFail(t, stub, "updateUserInfo", "user_a", `["level"]`)
+    testInvokeAt(t, stub, "", day + 60, "", "updateUserInfo", "user_a", `{"level":2}`)
+    testGetUserInfo(t, stub, "user_a", `{"extras":"{\"company\":\"A\",\"level\":2}","name":"user_a"}`)
+    testInvokeAt(t, stub, "", day + 120, "", "updateUserInfo", "user_a", `{"company":null,"level":2,"region":null}`)
+    testGetUserInfo(t, stub, "user_a", `{"extras":"{\"level\":2}","name":"user_a"}`)
+
+    testInvoke(t, stub, `[` +
+        `{"id":"1","username":"user_a","fields":["extras"],"old_extras":"company A","new_extras":"{\"company\":\"A\"}","changed_by":"` + mockCallerId + `","changed_at":1537776000},` +
+        `{"id":"1.1","username":"user_a","fields":["level"],"old_extras":"{\"company\":\"A\"}","new_extras":"{\"company\":\"A\",\"level\":2}","changed_by":"` + mockCallerId + `","changed_at":1537776060},` +
+        `{"id":"1.2","username":"user_a","fields":["company"],"old_extras":"{\"company\":\"A\",\"level\":2}","new_extras":"{\"level\":2}","changed_by":"` + mockCallerId + `","changed_at":1537776120}` +
+        `]`, "getUserInfoHistory", "user_a")
+    testInvoke(t, stub, `[]`, "getUserInfoHistory", "user_b")
+    testInvokeFail(t, stub, "getUserInfoHistory", "user_x")
+
+    // 用户的所有者或 admin
+    testInvoke(t, stub, "", "setUserOwner", "user_b", bobId)
+    testInvokeAs(t, stub, "bob", "", "updateUserInfo", "user_b", `{"company":"Bob's"}`)
+    testInvokeAsFail(t, stub, "bob", "updateUserInfo", "user_a", `{"company":"Bob's"}`)
+    testInvokeAsFail(t, stub, "carol", "updateUserInfo", "user_b", `{"company":"Carol's"}`)
+    testInvoke(t, stub, "", "updateUserInfo", "user_b", `{"company":"B"}`)
+    testGetUserInfo(t, stub, "user_b", `{"extras":"{\"company\":\"B\"}","name":"user_b"}`)
+}
+
+func TestUserInfoSchema(t *testing.T) {
+    stub := shim.NewMockStub("TestUserInfoSchema", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "user_a", "company A")
+
+    schema := `{"type":"object","properties":{"company":{"type":"string","maxLength":8},"level":{"type":"integer","minimum":1,"maximum":5},` +
+        `"region":{"enum":["north","south"]}},"required":["company"],"additionalProperties":false}`
+
+    testInvoke(t, stub, "", "getUserInfoSchema")
+    testInvokeFail(t, stub, "setUserInfoSchema", `{"type":"array"}`)
+    testInvokeFail(t, stub, "setUserInfoSchema", `{"properties":{"company":{"type":"text"}}}`)
+    testInvokeFail(t, stub, "setUserInfoSchema", `{"properties":{"company":{"format":"email"}}}`)
+    testInvokeAsFail(t, stub, "bob", "setUserInfoSchema", schema)
+    testInvoke(t, stub, "", "setUserInfoSchema", schema)
+    testInvoke(t, stub, schema, "getUserInfoSchema")
+
+    // register 时检查
+    testRegisterFail(t, stub, "user_b", "")
+    testRegisterFail(t, stub, "user_b", "company B")
+    testRegisterFail(t, stub, "user_b", `{"company":"company B"}`)
+    testRegister(t, stub, "user_b", `{"company":"B","level":3}`)
+
+    // 修改时检查
+    testInvokeFail(t, stub, "updateUserInfo", "user_b", `{"level":6}`)
+    testInvokeFail(t, stub, "updateUserInfo", "user_b", `{"level":1.5}`)
+    testInvokeFail(t, stub, "updateUserInfo", "user_b", `{"level":"3"}`)
+    testInvokeFail(t, stub, "updateUserInfo", "user_b", `{"region":"east"}`)
+    testInvokeFail(t, stub, "updateUserInfo", "user_b", `{"email":"b@example.com"}`)
+    testInvokeFail(t, stub, "updateUserInfo", "user_b", `{"company":null}`)
+    testInvoke(t, stub, "", "updateUserInfo", "user_b", `{"level":1,"region":"north"}`)
+    testGetUserInfo(t, stub, "user_b", `{"extras":"{\"company\":\"B\",\"level\":1,\"region\":\"north\"}","name":"user_b"}`)
+
+    // 之前注册的用户修改时按新的格式检查
+    testInvokeFail(t, stub, "updateUserInfo", "user_a", `company A2`, "replace")
+    testInvoke(t, stub, "", "updateUserInfo", "user_a", `{"company":"A"}`, "replace")
+
+    testInvoke(t, stub, "", "setUserInfoSchema", "")
+    testInvoke(t, stub, "", "getUserInfoSchema")
+    testRegister(t, stub, "user_c", "company C")
+}
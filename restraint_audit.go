@@ -0,0 +1,205 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// 转账约束的修改记录
+// 每次通过 putRestraint、delRestraint 修改 u_r: 的约束（setRestraint、clearRestraint、applyRestraintTemplate）都会追加一条修改记录，
+// 约束没有变化时不记录；跨组织的约束在双方确认生效时才记录，确认人为生效时的调用者。
+// 修改记录存储在 r_a: 下，key 为修改 id（规则同转账 id），old 和 new 为从 username_a 到 username_b 的约束，枚举值的含义见 setRestraint，"" 表示没有 u_r: 约束。
+// 按用户索引在 r_au: 下，key 为 [用户名, %020d 修改时间, 修改 id]；按用户对索引在 r_ap: 下，key 为 [用户名, 用户名, %020d 修改时间, 修改 id]，
+// 两个索引都为双方各写一条。
+
+// 修改原因的最大长度
+const MAX_RESTRAINT_REASON_SIZE = 1024
+
+type RestraintAudit struct {
+    Id        string `json:"id"`
+    UsernameA string `json:"username_a"`
+    UsernameB string `json:"username_b"`
+    Old       string `json:"old"`
+    New       string `json:"new"`
+    Actor     string `json:"actor"`
+    Reason    string `json:"reason"`
+    TxId      string `json:"txid"`
+    Timestamp int64  `json:"timestamp"`
+}
+
+// 检查修改原因
+func validateRestraintReason(reason string) error {
+    if len(reason) > MAX_RESTRAINT_REASON_SIZE {
+        return fmt.Errorf("reason is too long, got %d bytes, expected at most %d", len(reason), MAX_RESTRAINT_REASON_SIZE)
+    }
+    return nil
+}
+
+// 记录 username_a 到 username_b 的约束从 old_restraint 修改为 new_restraint
+func recordRestraintChange(stub shim.ChaincodeStubInterface, username_a, username_b string, old_restraint, new_restraint string, reason string) error {
+    now, err := getTxTime(stub)
+    if err != nil {
+        return err
+    }
+
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return err
+    }
+
+    id, err := newTxScopedId(stub, "r_a:")
+    if err != nil {
+        return err
+    }
+
+    audit_key, err := stub.CreateCompositeKey("r_a:", []string{id})
+    if err != nil {
+        return fmt.Errorf("audit id is not valid. %s", err.Error())
+    }
+
+    auditAsBytes, err := json.Marshal(&RestraintAudit{
+        Id: id,
+        UsernameA: username_a,
+        UsernameB: username_b,
+        Old: old_restraint,
+        New: new_restraint,
+        Actor: caller,
+        Reason: reason,
+        TxId: stub.GetTxID(),
+        Timestamp: now.Unix(),
+    })
+    if err != nil {
+        return fmt.Errorf("Failed to format restraint audit. %s", err.Error())
+    }
+
+    err = stub.PutState(audit_key, auditAsBytes)
+    if err != nil {
+        return fmt.Errorf("Failed to put state. %s", err.Error())
+    }
+
+    timestamp := fmt.Sprintf("%020d", now.Unix())
+
+    index_keys := [][]string{
+        {"r_au:", username_a, timestamp, id},
+        {"r_au:", username_b, timestamp, id},
+        {"r_ap:", username_a, username_b, timestamp, id},
+        {"r_ap:", username_b, username_a, timestamp, id},
+    }
+
+    for _, parts := range index_keys {
+        index_key, err := stub.CreateCompositeKey(parts[0], parts[1:])
+        if err != nil {
+            return fmt.Errorf("username_a or username_b is not valid. %s", err.Error())
+        }
+
+        err = stub.PutState(index_key, []byte{0x00})
+        if err != nil {
+            return fmt.Errorf("Failed to put state. %s", err.Error())
+        }
+    }
+
+    return nil
+}
+
+// 按索引查询修改记录，索引 key 的最后一部分为修改 id
+func listRestraintAudits(stub shim.ChaincodeStubInterface, object_type string, attributes []string) ([]json.RawMessage, error) {
+    itr, err := stub.GetStateByPartialCompositeKey(object_type, attributes)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    defer itr.Close()
+
+    audits := []json.RawMessage{}
+
+    for itr.HasNext() {
+        kv, err := itr.Next()
+        if err != nil {
+            return nil, fmt.Errorf("Failed to get restraint audit stored. %s", err.Error())
+        }
+        _, compositeKeyParts, err := stub.SplitCompositeKey(kv.Key)
+        if err != nil {
+            return nil, fmt.Errorf("Failed to parse restraint audit stored. %s", err.Error())
+        }
+
+        audit_key, err := stub.CreateCompositeKey("r_a:", []string{compositeKeyParts[len(compositeKeyParts) - 1]})
+        if err != nil {
+            return nil, fmt.Errorf("audit id is not valid. %s", err.Error())
+        }
+
+        auditAsBytes, err := stub.GetState(audit_key)
+        if err != nil {
+            return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+        }
+        if auditAsBytes != nil {
+            audits = append(audits, json.RawMessage(auditAsBytes))
+        }
+    }
+
+    return audits, nil
+}
+
+// 查询两个用户之间约束的修改记录，按修改时间排序
+// 返回值：json字符串
+// [{"id":"txid","username_a":"user_a","username_b":"user_b","old":"0","new":"1","actor":"身份哈希","reason":"onboarding","txid":"txid","timestamp":1537776000}]
+func (cc *RestrainedTransferCC) getRestraintAudit(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getRestraintAudit', args: ['username_a', 'username_b']}"`)
+    }
+
+    username_a := strings.TrimSpace(args[0])
+    username_b := strings.TrimSpace(args[1])
+
+    var err error
+
+    if err = checkRegistered(stub, "username_a", username_a); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkRegistered(stub, "username_b", username_b); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    audits, err := listRestraintAudits(stub, "r_ap:", []string{username_a, username_b})
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    auditsAsBytes, err := json.Marshal(audits)
+    if err != nil {
+        return shim.Error("Failed to format restraint audits. " + err.Error())
+    }
+
+    return shim.Success(auditsAsBytes)
+}
+
+// 查询用户所有约束的修改记录，按修改时间排序，格式同 getRestraintAudit
+// 返回值：json字符串
+func (cc *RestrainedTransferCC) getRestraintAuditOfUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getRestraintAuditOfUser', args: ['username']}"`)
+    }
+
+    username := strings.TrimSpace(args[0])
+
+    var err error
+
+    if err = checkRegistered(stub, "username", username); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    audits, err := listRestraintAudits(stub, "r_au:", []string{username})
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    auditsAsBytes, err := json.Marshal(audits)
+    if err != nil {
+        return shim.Error("Failed to format restraint audits. " + err.Error())
+    }
+
+    return shim.Success(auditsAsBytes)
+}
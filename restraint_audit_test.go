@@ -0,0 +1,54 @@
+package main
+
+import (
+    "strings"
+    "testing"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestRestraintAudit(t *testing.T) {
+    stub := shim.NewMockStub("TestRestraintAudit", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "user_a", "")
+    testRegister(t, stub, "user_b", "")
+    testRegister(t, stub, "user_c", "")
+
+    day := int64(1537776000)
+
+    testInvokeFail(t, stub, "setRestraint", "user_a", "user_b", "1", strings.Repeat("x", MAX_RESTRAINT_REASON_SIZE + 1))
+    testInvokeAt(t, stub, "", day, "", "setRestraint", "user_a", "user_b", "1", "onboarding")
+    // 没有变化时不记录
+    testInvokeAt(t, stub, "", day + 60, "", "setRestraint", "user_b", "user_a", "2")
+    testInvokeAt(t, stub, "bob", day + 120, "", "setRestraint", "user_b", "user_a", "3", "partner upgrade")
+    testInvokeAt(t, stub, "", day + 180, "", "setRestraint", "user_a", "user_c", "3")
+    // 删除约束也有记录
+    testInvokeAt(t, stub, "", day + 240, "", "setRestraint", "user_a", "user_b", "0", "contract ended")
+
+    ab := []string{
+        `{"id":"1","username_a":"user_a","username_b":"user_b","old":"","new":"1","actor":"` + mockCallerId + `","reason":"onboarding","txid":"1","timestamp":1537776000}`,
+        `{"id":"1.1","username_a":"user_b","username_b":"user_a","old":"2","new":"3","actor":"` + bobId + `","reason":"partner upgrade","txid":"1","timestamp":1537776120}`,
+        `{"id":"1.3","username_a":"user_a","username_b":"user_b","old":"3","new":"0","actor":"` + mockCallerId + `","reason":"contract ended","txid":"1","timestamp":1537776240}`,
+    }
+    ac := `{"id":"1.2","username_a":"user_a","username_b":"user_c","old":"","new":"3","actor":"` + mockCallerId + `","reason":"","txid":"1","timestamp":1537776180}`
+
+    testInvoke(t, stub, "[" + strings.Join(ab, ",") + "]", "getRestraintAudit", "user_a", "user_b")
+    testInvoke(t, stub, "[" + strings.Join(ab, ",") + "]", "getRestraintAudit", "user_b", "user_a")
+    testInvoke(t, stub, "[" + ac + "]", "getRestraintAudit", "user_c", "user_a")
+    testInvoke(t, stub, "[]", "getRestraintAudit", "user_b", "user_c")
+    testInvokeFail(t, stub, "getRestraintAudit", "user_a", "user_x")
+
+    testInvoke(t, stub, "[" + ab[0] + "," + ab[1] + "," + ac + "," + ab[2] + "]", "getRestraintAuditOfUser", "user_a")
+    testInvoke(t, stub, "[" + ac + "]", "getRestraintAuditOfUser", "user_c")
+    testInvokeFail(t, stub, "getRestraintAuditOfUser", "user_x")
+
+    // 按模板设置时同样记录，dry_run 不记录
+    testInvoke(t, stub, "", "setRestraintTemplate", "partners", "3", "")
+    testInvokeAt(t, stub, "", day + 300, `{"changes":[{"username":"user_c","old":"","new":"3","status":"applied"}],"dry_run":true,"template":"partners","username":"user_b"}`,
+        "applyRestraintTemplate", "partners", "user_b", "users", `["user_c"]`, "true", "bulk")
+    testInvoke(t, stub, "[]", "getRestraintAudit", "user_b", "user_c")
+    testInvokeAt(t, stub, "", day + 300, `{"changes":[{"username":"user_c","old":"","new":"3","status":"applied"}],"dry_run":false,"template":"partners","username":"user_b"}`,
+        "applyRestraintTemplate", "partners", "user_b", "users", `["user_c"]`, "false", "bulk")
+    testInvoke(t, stub, `[{"id":"1.4","username_a":"user_b","username_b":"user_c","old":"","new":"3","actor":"` + mockCallerId + `","reason":"bulk","txid":"1","timestamp":1537776300}]`,
+        "getRestraintAudit", "user_b", "user_c")
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+
+    "github.com/shopspring/decimal"
+)
+
+// 转账冲正和争议处理
+// 付款人（from 的所有者）通过 requestReversal 申请冲正一笔转账，申请存储在 t_r: 下，key 为原转账 id。
+// 收款人（to 的所有者）可以同意或拒绝；收款人拒绝后申请进入争议状态，由 arbitrator 角色裁决。
+// arbitrator 也可以直接同意或拒绝还没有处理的申请。
+// 同意后立即执行冲正：把原金额从 to 转回 from，不检查转账约束（ONEWAY 约束下也可以冲正），
+// 但仍然检查制裁名单、冻结和 to 的余额。冲正记录在转账记录中，reversal_of 为原转账 id。
+// 每笔转账最多冲正一次，冲正记录本身不能再冲正；被 arbitrator 拒绝的申请不能再次提交。
+
+const (
+    REVERSAL_PENDING  = "pending"
+    REVERSAL_DISPUTED = "disputed"
+    REVERSAL_APPROVED = "approved"
+    REVERSAL_REJECTED = "rejected"
+)
+
+type ReversalRequest struct {
+    TransferId  string `json:"transfer_id"`
+    Reason      string `json:"reason"`
+    Status      string `json:"status"`
+    RequestedBy string `json:"requested_by"`
+    RequestedAt int64  `json:"requested_at"`
+    DecidedBy   string `json:"decided_by"`
+    DecidedAt   int64  `json:"decided_at"`
+    ReversalId  string `json:"reversal_id"`
+}
+
+func getReversalRequest(stub shim.ChaincodeStubInterface, transfer_id string) (*ReversalRequest, error) {
+    request_key, err := stub.CreateCompositeKey("t_r:", []string{transfer_id})
+    if err != nil {
+        return nil, fmt.Errorf("transfer id is not valid. %s", err.Error())
+    }
+
+    requestAsBytes, err := stub.GetState(request_key)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if requestAsBytes == nil {
+        return nil, nil
+    }
+
+    var request ReversalRequest
+    err = json.Unmarshal(requestAsBytes, &request)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to parse reversal request stored. %s", err.Error())
+    }
+
+    return &request, nil
+}
+
+func putReversalRequest(stub shim.ChaincodeStubInterface, request *ReversalRequest) error {
+    request_key, err := stub.CreateCompositeKey("t_r:", []string{request.TransferId})
+    if err != nil {
+        return fmt.Errorf("transfer id is not valid. %s", err.Error())
+    }
+
+    requestAsBytes, err := json.Marshal(request)
+    if err != nil {
+        return fmt.Errorf("Failed to format reversal request. %s", err.Error())
+    }
+
+    err = stub.PutState(request_key, requestAsBytes)
+    if err != nil {
+        return fmt.Errorf("Failed to put state. %s", err.Error())
+    }
+
+    return nil
+}
+
+// 读取原转账和还没有结束的冲正申请
+func getOpenReversal(stub shim.ChaincodeStubInterface, transfer_id string) (*TransferRecord, *ReversalRequest, error) {
+    record, err := getTransferRecord(stub, transfer_id)
+    if err != nil {
+        return nil, nil, err
+    }
+    if record == nil {
+        return nil, nil, fmt.Errorf("transfer %s does not exist.", transfer_id)
+    }
+
+    request, err := getReversalRequest(stub, transfer_id)
+    if err != nil {
+        return nil, nil, err
+    }
+    if request == nil {
+        return nil, nil, fmt.Errorf("reversal of transfer %s is not requested.", transfer_id)
+    }
+    if request.Status != REVERSAL_PENDING && request.Status != REVERSAL_DISPUTED {
+        return nil, nil, fmt.Errorf("reversal of transfer %s is %s.", transfer_id, request.Status)
+    }
+
+    return record, request, nil
+}
+
+// 调用者以什么身份处理冲正申请：arbitrator 或者收款人，都不是时返回 error
+// 收款人只能处理 pending 状态的申请
+func checkReversalDecider(stub shim.ChaincodeStubInterface, record *TransferRecord, request *ReversalRequest) (bool, error) {
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return false, err
+    }
+
+    is_arbitrator, err := hasRole(stub, ROLE_ARBITRATOR, caller)
+    if err != nil {
+        return false, err
+    }
+    if is_arbitrator {
+        return true, nil
+    }
+
+    if request.Status == REVERSAL_PENDING {
+        owner, err := getOwnerOf(stub, record.To)
+        if err != nil {
+            return false, err
+        }
+        if owner == caller {
+            return false, nil
+        }
+    }
+
+    return false, fmt.Errorf("caller %s can not decide reversal of transfer %s.", caller, record.Id)
+}
+
+// 把原转账的金额从 to 转回 from，不检查转账约束，返回冲正记录的 id
+// 被制裁名单拦截时返回 *ScreeningBlocked，此时还没有修改余额
+func executeReversal(stub shim.ChaincodeStubInterface, record *TransferRecord) (string, error) {
+    amount, err := decimal.NewFromString(record.Amount)
+    if err != nil {
+        return "", fmt.Errorf("Failed to parse transfer record stored. %s", err.Error())
+    }
+
+    balance_to, err := getStoredBalance(stub, "to", record.To)
+    if err != nil {
+        return "", err
+    }
+
+    balance_from, err := getStoredBalance(stub, "from", record.From)
+    if err != nil {
+        return "", err
+    }
+
+    if err = screen(stub, "transfer", amount.String(), record.To, record.From); err != nil {
+        return "", err
+    }
+
+    if err = checkNotFrozen(stub, record.To); err != nil {
+        return "", err
+    }
+
+    if err = checkNotFrozen(stub, record.From); err != nil {
+        return "", err
+    }
+
+    spendable_to, err := getSpendableBalance(stub, record.To, balance_to)
+    if err != nil {
+        return "", err
+    }
+    if spendable_to.LessThan(amount) {
+        return "", fmt.Errorf("Failed reversal, not enough balance.")
+    }
+
+    err = setBalance(stub, record.To, balance_to, balance_to.Sub(amount))
+    if err != nil {
+        return "", err
+    }
+
+    err = setBalance(stub, record.From, balance_from, balance_from.Add(amount))
+    if err != nil {
+        return "", err
+    }
+
+    reversal_id, err := recordTransfer(stub, record.To, record.From, amount, record.Id)
+    if err != nil {
+        return "", err
+    }
+
+    record.ReversedBy = reversal_id
+
+    err = putTransferRecord(stub, record)
+    if err != nil {
+        return "", err
+    }
+
+    return reversal_id, nil
+}
+
+// 申请冲正一笔转账，调用者必须是原转账 from 的所有者
+// 返回值：nil
+func (cc *RestrainedTransferCC) requestReversal(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'requestReversal', args: ['transfer_id', 'reason']}"`)
+    }
+
+    transfer_id := strings.TrimSpace(args[0])
+    reason := strings.TrimSpace(args[1])
+
+    var err error
+
+    record, err := getTransferRecord(stub, transfer_id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if record == nil {
+        return shim.Error("transfer " + transfer_id + " does not exist.")
+    }
+    if record.ReversalOf != "" {
+        return shim.Error("transfer " + transfer_id + " is a reversal and can not be reversed.")
+    }
+
+    if err = checkCallerOwns(stub, record.From); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    request, err := getReversalRequest(stub, transfer_id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if request != nil {
+        return shim.Error("reversal of transfer " + transfer_id + " is already " + request.Status + ".")
+    }
+
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    err = putReversalRequest(stub, &ReversalRequest{
+        TransferId: transfer_id,
+        Reason: reason,
+        Status: REVERSAL_PENDING,
+        RequestedBy: caller,
+        RequestedAt: now.Unix(),
+    })
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 同意冲正并立即执行，调用者必须是原转账 to 的所有者（申请为 pending 时）或 arbitrator
+// 返回值：字符串，冲正记录的 id；被制裁名单拦截时返回值见 screening.go，申请保持原来的状态
+func (cc *RestrainedTransferCC) approveReversal(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'approveReversal', args: ['transfer_id']}"`)
+    }
+
+    transfer_id := strings.TrimSpace(args[0])
+
+    record, request, err := getOpenReversal(stub, transfer_id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if _, err = checkReversalDecider(stub, record, request); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    reversal_id, err := executeReversal(stub, record)
+    if err != nil {
+        return screeningErrorResponse(err)
+    }
+
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    request.Status = REVERSAL_APPROVED
+    request.DecidedBy = caller
+    request.DecidedAt = now.Unix()
+    request.ReversalId = reversal_id
+
+    err = putReversalRequest(stub, request)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success([]byte(reversal_id))
+}
+
+// 拒绝冲正
+// 收款人拒绝 pending 的申请时，申请进入 disputed 状态，等待 arbitrator 裁决；arbitrator 拒绝时申请结束
+// 返回值：字符串，拒绝后申请的状态 "disputed" 或 "rejected"
+func (cc *RestrainedTransferCC) rejectReversal(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'rejectReversal', args: ['transfer_id']}"`)
+    }
+
+    transfer_id := strings.TrimSpace(args[0])
+
+    record, request, err := getOpenReversal(stub, transfer_id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    is_arbitrator, err := checkReversalDecider(stub, record, request)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if is_arbitrator {
+        request.Status = REVERSAL_REJECTED
+    } else {
+        request.Status = REVERSAL_DISPUTED
+    }
+    request.DecidedBy = caller
+    request.DecidedAt = now.Unix()
+
+    err = putReversalRequest(stub, request)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success([]byte(request.Status))
+}
+
+// 查询转账的冲正申请
+// 返回值：json字符串
+// {
+//     "transfer_id":"txid","reason":"wrong payee","status":"approved",
+//     "requested_by":"e3b0c442...","requested_at":1537776000,
+//     "decided_by":"81b637d8...","decided_at":1537779600,"reversal_id":"txid2"
+// }
+func (cc *RestrainedTransferCC) getReversalRequest(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getReversalRequest', args: ['transfer_id']}"`)
+    }
+
+    transfer_id := strings.TrimSpace(args[0])
+
+    request, err := getReversalRequest(stub, transfer_id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if request == nil {
+        return shim.Error("reversal of transfer " + transfer_id + " is not requested.")
+    }
+
+    requestAsBytes, err := json.Marshal(request)
+    if err != nil {
+        return shim.Error("Failed to format reversal request. " + err.Error())
+    }
+
+    return shim.Success(requestAsBytes)
+}
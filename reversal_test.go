@@ -0,0 +1,74 @@
+package main
+
+import (
+    "testing"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// sha256("carol")
+const carolId = "4c26d9074c27d89ede59270c0ac14b71e071b15239519f75474b2f3ba63481f5"
+
+func TestReversal(t *testing.T) {
+    stub := shim.NewMockStub("TestReversal", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "user_a", "")
+    testRegister(t, stub, "user_b", "")
+    testSetRestraint(t, stub, "user_a", "user_b", "1")
+    testRecharge(t, stub, "user_a", "100")
+    testInvoke(t, stub, "", "setUserOwner", "user_b", bobId)
+
+    day := int64(1537776000)
+
+    // 同一个 MockStub 的交易 id 都是 "1"，之后的转账 id 依次加后缀
+    testInvokeAt(t, stub, "", day, "", "transfer", "user_a", "user_b", "30")
+    testInvokeAt(t, stub, "", day, "", "transfer", "user_a", "user_b", "20")
+    testInvokeAt(t, stub, "", day, "", "transfer", "user_a", "user_b", "10")
+    testInvoke(t, stub, `{"id":"1.1","from":"user_a","to":"user_b","amount":"20","timestamp":1537776000,"reversal_of":"","reversed_by":""}`, "getTransfer", "1.1")
+    testInvokeFail(t, stub, "getTransfer", "2")
+
+    // ONEWAY 约束下 user_b 不能转回
+    testTransferFail(t, stub, "user_b", "user_a", "30")
+
+    testInvokeAsFail(t, stub, "bob", "requestReversal", "1", "wrong amount")
+    testInvokeFail(t, stub, "approveReversal", "1")
+    testInvokeAt(t, stub, "", day + 60, "", "requestReversal", "1", "wrong amount")
+    testInvokeFail(t, stub, "requestReversal", "1", "again")
+
+    // 付款人不能自己同意
+    testInvokeFail(t, stub, "approveReversal", "1")
+    testInvokeAt(t, stub, "bob", day + 120, "1.3", "approveReversal", "1")
+    testGetBalance(t, stub, "user_a", "70")
+    testGetBalance(t, stub, "user_b", "30")
+    testInvoke(t, stub, `{"id":"1","from":"user_a","to":"user_b","amount":"30","timestamp":1537776000,"reversal_of":"","reversed_by":"1.3"}`, "getTransfer", "1")
+    testInvoke(t, stub, `{"id":"1.3","from":"user_b","to":"user_a","amount":"30","timestamp":1537776120,"reversal_of":"1","reversed_by":""}`, "getTransfer", "1.3")
+    testInvoke(t, stub, `{"transfer_id":"1","reason":"wrong amount","status":"approved","requested_by":"` + mockCallerId + `","requested_at":1537776060,` +
+        `"decided_by":"` + bobId + `","decided_at":1537776120,"reversal_id":"1.3"}`, "getReversalRequest", "1")
+    testInvokeAsFail(t, stub, "bob", "approveReversal", "1")
+    testInvokeFail(t, stub, "requestReversal", "1.3", "reverse the reversal")
+
+    // 收款人拒绝后进入争议，由 arbitrator 裁决
+    testInvoke(t, stub, "", "requestReversal", "1.1", "not delivered")
+    testInvokeAs(t, stub, "bob", "disputed", "rejectReversal", "1.1")
+    testInvokeAsFail(t, stub, "bob", "approveReversal", "1.1")
+    testInvokeAsFail(t, stub, "carol", "approveReversal", "1.1")
+    testInvoke(t, stub, "", "grantRole", "arbitrator", carolId)
+    testInvokeAs(t, stub, "carol", "1.4", "approveReversal", "1.1")
+    testGetBalance(t, stub, "user_a", "90")
+    testGetBalance(t, stub, "user_b", "10")
+
+    // arbitrator 拒绝后申请结束
+    testInvoke(t, stub, "", "requestReversal", "1.2", "duplicate")
+    testInvokeAs(t, stub, "carol", "rejected", "rejectReversal", "1.2")
+    testInvokeAsFail(t, stub, "carol", "approveReversal", "1.2")
+    testInvokeFail(t, stub, "requestReversal", "1.2", "duplicate")
+
+    // 收款人余额不足时不能冲正
+    testInvoke(t, stub, "", "setRestraint", "user_a", "user_b", "3")
+    testTransfer(t, stub, "user_a", "user_b", "50")
+    testInvokeAs(t, stub, "bob", "", "transfer", "user_b", "user_a", "60")
+    testInvoke(t, stub, "", "requestReversal", "1.5", "mistake")
+    testInvokeAsFail(t, stub, "bob", "approveReversal", "1.5")
+    testGetBalance(t, stub, "user_a", "100")
+    testGetBalance(t, stub, "user_b", "0")
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// 角色
+// 调用者身份用 GetCreator() 返回的序列化身份（MSP ID 和证书）的 sha256 十六进制字符串表示，称为身份哈希。
+// 角色成员存储在 r_m: 下，key 为 [角色, 身份哈希]。
+// 实例化链码的身份自动成为 admin，admin 可以授予和收回所有角色。
+//
+// 用户的所有者
+// register 时调用者的身份成为用户的所有者，存储在 u_w: 下。需要用户本人操作的函数（如 createScheduledTransfer）
+// 要求调用者是用户的所有者。admin 可以通过 setUserOwner 更换所有者。
+
+const (
+    ROLE_ADMIN      = "admin"
+    ROLE_COMPLIANCE = "compliance"
+    ROLE_ARBITRATOR = "arbitrator"
+    ROLE_SETTLEMENT = "settlement"
+    ROLE_OPERATOR   = "operator"
+    ROLE_TREASURY   = "treasury"
+    ROLE_ORACLE      = "oracle"
+    ROLE_KYC_OFFICER = "kyc_officer"
+)
+
+var knownRoles = map[string]bool{
+    ROLE_ADMIN:      true,
+    ROLE_COMPLIANCE: true,
+    ROLE_ARBITRATOR: true,
+    ROLE_SETTLEMENT: true,
+    ROLE_OPERATOR:   true,
+    ROLE_TREASURY:   true,
+    ROLE_ORACLE:      true,
+    ROLE_KYC_OFFICER: true,
+}
+
+// 查询调用者的身份哈希
+func getCallerId(stub shim.ChaincodeStubInterface) (string, error) {
+    creator, err := stub.GetCreator()
+    if err != nil {
+        return "", fmt.Errorf("Failed to get creator. %s", err.Error())
+    }
+
+    sum := sha256.Sum256(creator)
+
+    return hex.EncodeToString(sum[:]), nil
+}
+
+// 查询身份是否拥有角色
+func hasRole(stub shim.ChaincodeStubInterface, role, identity string) (bool, error) {
+    role_key, err := stub.CreateCompositeKey("r_m:", []string{role, identity})
+    if err != nil {
+        return false, fmt.Errorf("role or identity is not valid. %s", err.Error())
+    }
+
+    roleAsBytes, err := stub.GetState(role_key)
+    if err != nil {
+        return false, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+
+    return roleAsBytes != nil, nil
+}
+
+// 调用者没有角色时返回 error
+func checkCallerRole(stub shim.ChaincodeStubInterface, role string) error {
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return err
+    }
+
+    ok, err := hasRole(stub, role, caller)
+    if err != nil {
+        return err
+    }
+    if !ok {
+        return fmt.Errorf("caller %s does not have role %s.", caller, role)
+    }
+
+    return nil
+}
+
+// 查询角色的所有成员
+func listRoleMembers(stub shim.ChaincodeStubInterface, role string) ([]string, error) {
+    itr, err := stub.GetStateByPartialCompositeKey("r_m:", []string{role})
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    defer itr.Close()
+
+    members := []string{}
+
+    for itr.HasNext() {
+        kv, err := itr.Next()
+        if err != nil {
+            return nil, fmt.Errorf("Failed to get role stored. %s", err.Error())
+        }
+        _, compositeKeyParts, err := stub.SplitCompositeKey(kv.Key)
+        if err != nil {
+            return nil, fmt.Errorf("Failed to parse role stored. %s", err.Error())
+        }
+        members = append(members, compositeKeyParts[1])
+    }
+
+    return members, nil
+}
+
+// 还没有 admin 时，把调用者设为 admin
+func initAdmin(stub shim.ChaincodeStubInterface) error {
+    admins, err := listRoleMembers(stub, ROLE_ADMIN)
+    if err != nil {
+        return err
+    }
+    if len(admins) > 0 {
+        return nil
+    }
+
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return err
+    }
+
+    role_key, err := stub.CreateCompositeKey("r_m:", []string{ROLE_ADMIN, caller})
+    if err != nil {
+        return fmt.Errorf("identity is not valid. %s", err.Error())
+    }
+
+    err = stub.PutState(role_key, []byte("1"))
+    if err != nil {
+        return fmt.Errorf("Failed to put state. %s", err.Error())
+    }
+
+    return nil
+}
+
+// 查询调用者的身份哈希
+// 返回值：字符串，64 位十六进制数
+func (cc *RestrainedTransferCC) getCallerIdentity(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 0 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getCallerIdentity', args: []}"`)
+    }
+
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success([]byte(caller))
+}
+
+// 授予角色，只有 admin 可以调用
+// 返回值：nil
+func (cc *RestrainedTransferCC) grantRole(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'grantRole', args: ['role', 'identity']}"`)
+    }
+
+    role := strings.TrimSpace(args[0])
+    identity := strings.TrimSpace(args[1])
+
+    if !knownRoles[role] {
+        return shim.Error("role " + role + " is not valid.")
+    }
+
+    if len(identity) == 0 {
+        return shim.Error("identity should not be empty.")
+    }
+
+    var err error
+
+    if err = checkCallerRole(stub, ROLE_ADMIN); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    role_key, err := stub.CreateCompositeKey("r_m:", []string{role, identity})
+    if err != nil {
+        return shim.Error("identity is not valid. " + err.Error())
+    }
+
+    err = stub.PutState(role_key, []byte("1"))
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 收回角色，只有 admin 可以调用
+// 返回值：nil
+func (cc *RestrainedTransferCC) revokeRole(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'revokeRole', args: ['role', 'identity']}"`)
+    }
+
+    role := strings.TrimSpace(args[0])
+    identity := strings.TrimSpace(args[1])
+
+    if !knownRoles[role] {
+        return shim.Error("role " + role + " is not valid.")
+    }
+
+    var err error
+
+    if err = checkCallerRole(stub, ROLE_ADMIN); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if role == ROLE_ADMIN {
+        admins, err := listRoleMembers(stub, ROLE_ADMIN)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        if len(admins) == 1 && admins[0] == identity {
+            return shim.Error("can not revoke the last admin.")
+        }
+    }
+
+    role_key, err := stub.CreateCompositeKey("r_m:", []string{role, identity})
+    if err != nil {
+        return shim.Error("identity is not valid. " + err.Error())
+    }
+
+    err = stub.DelState(role_key)
+    if err != nil {
+        return shim.Error("Failed to del state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询角色的所有成员
+// 返回值：json字符串，身份哈希的数组
+// ["e3b0c442...","5e884898..."]
+func (cc *RestrainedTransferCC) getRoleMembers(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getRoleMembers', args: ['role']}"`)
+    }
+
+    role := strings.TrimSpace(args[0])
+
+    if !knownRoles[role] {
+        return shim.Error("role " + role + " is not valid.")
+    }
+
+    members, err := listRoleMembers(stub, role)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    membersAsBytes, err := json.Marshal(members)
+    if err != nil {
+        return shim.Error("Failed to format role members. " + err.Error())
+    }
+
+    return shim.Success(membersAsBytes)
+}
+
+func putUserOwner(stub shim.ChaincodeStubInterface, username, identity string) error {
+    owner_key, err := stub.CreateCompositeKey("u_w:", []string{username})
+    if err != nil {
+        return fmt.Errorf("username is not valid. %s", err.Error())
+    }
+
+    err = stub.PutState(owner_key, []byte(identity))
+    if err != nil {
+        return fmt.Errorf("Failed to put state. %s", err.Error())
+    }
+
+    return nil
+}
+
+// 查询用户的所有者，没有时返回 ""
+func getOwnerOf(stub shim.ChaincodeStubInterface, username string) (string, error) {
+    owner_key, err := stub.CreateCompositeKey("u_w:", []string{username})
+    if err != nil {
+        return "", fmt.Errorf("username is not valid. %s", err.Error())
+    }
+
+    ownerAsBytes, err := stub.GetState(owner_key)
+    if err != nil {
+        return "", fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+
+    return string(ownerAsBytes), nil
+}
+
+// 调用者不是用户的所有者时返回 error
+func checkCallerOwns(stub shim.ChaincodeStubInterface, username string) error {
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return err
+    }
+
+    owner, err := getOwnerOf(stub, username)
+    if err != nil {
+        return err
+    }
+    if owner != caller {
+        return fmt.Errorf("caller %s is not the owner of %s.", caller, username)
+    }
+
+    return nil
+}
+
+// 更换用户的所有者，只有 admin 可以调用
+// 返回值：nil
+func (cc *RestrainedTransferCC) setUserOwner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'setUserOwner', args: ['username', 'identity']}"`)
+    }
+
+    username := strings.TrimSpace(args[0])
+    identity := strings.TrimSpace(args[1])
+
+    if len(identity) == 0 {
+        return shim.Error("identity should not be empty.")
+    }
+
+    var err error
+
+    if err = checkCallerRole(stub, ROLE_ADMIN); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkRegistered(stub, "username", username); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    err = putUserOwner(stub, username, identity)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询用户的所有者
+// 返回值：字符串，所有者的身份哈希
+func (cc *RestrainedTransferCC) getUserOwner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getUserOwner', args: ['username']}"`)
+    }
+
+    username := strings.TrimSpace(args[0])
+
+    var err error
+
+    if err = checkRegistered(stub, "username", username); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    owner, err := getOwnerOf(stub, username)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success([]byte(owner))
+}
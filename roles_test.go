@@ -0,0 +1,41 @@
+package main
+
+import (
+    "testing"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// MockStub 调用者身份为 nil 时的身份哈希，即 sha256("")
+const mockCallerId = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sha256("bob")
+const bobId = "81b637d8fcd2c6da6359e6963113a1170de795e4b725b84d1e0b4cfd9ec58ce9"
+
+func TestRoles(t *testing.T) {
+    stub := shim.NewMockStub("TestRoles", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testInvoke(t, stub, mockCallerId, "getCallerIdentity")
+    testInvokeAs(t, stub, "bob", bobId, "getCallerIdentity")
+
+    testInvoke(t, stub, `["` + mockCallerId + `"]`, "getRoleMembers", "admin")
+    testInvoke(t, stub, `[]`, "getRoleMembers", "compliance")
+    testInvokeFail(t, stub, "getRoleMembers", "nobody")
+
+    // 再次 Init 不会改变 admin
+    testInit(t, stub)
+    testInvoke(t, stub, `["` + mockCallerId + `"]`, "getRoleMembers", "admin")
+
+    testInvokeAsFail(t, stub, "bob", "grantRole", "compliance", bobId)
+    testInvokeFail(t, stub, "grantRole", "nobody", bobId)
+    testInvokeFail(t, stub, "grantRole", "compliance", "")
+    testInvoke(t, stub, "", "grantRole", "compliance", bobId)
+    testInvoke(t, stub, `["` + bobId + `"]`, "getRoleMembers", "compliance")
+
+    testInvokeFail(t, stub, "revokeRole", "admin", mockCallerId)
+    testInvoke(t, stub, "", "grantRole", "admin", bobId)
+    testInvokeAs(t, stub, "bob", "", "revokeRole", "admin", mockCallerId)
+    testInvokeFail(t, stub, "grantRole", "compliance", mockCallerId)
+    testInvokeAs(t, stub, "bob", "", "revokeRole", "compliance", bobId)
+    testInvoke(t, stub, `[]`, "getRoleMembers", "compliance")
+}
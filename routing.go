@@ -0,0 +1,305 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// 多跳转账
+// a 可以转给 b、b 可以转给 c 时，a 可以通过 b 转账给 c。
+// 路径只在 u_r: 约束构成的图中查找（不考虑组约束），使用广度优先搜索，找到的是跳数最少的路径，
+// 跳数相同时按用户名的字典序选择。被冻结的账户不会出现在路径中。
+// 中间账户可以通过 setRoutingOptIn 声明是否愿意作为中间账户，查找时可以要求所有中间账户都已声明愿意。
+
+// 最多允许的跳数
+const MAX_ROUTE_HOPS = 5
+
+// 查询用户是否愿意作为多跳转账的中间账户
+func isRoutingOptedIn(stub shim.ChaincodeStubInterface, username string) (bool, error) {
+    opt_in_key, err := stub.CreateCompositeKey("u_o:", []string{username})
+    if err != nil {
+        return false, fmt.Errorf("username is not valid. %s", err.Error())
+    }
+
+    optInAsBytes, err := stub.GetState(opt_in_key)
+    if err != nil {
+        return false, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+
+    return optInAsBytes != nil, nil
+}
+
+// 查询 username 可以直接转账的所有用户
+func listPayees(stub shim.ChaincodeStubInterface, username string) ([]string, error) {
+    itr, err := stub.GetStateByPartialCompositeKey("u_r:", []string{username})
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    defer itr.Close()
+
+    payees := []string{}
+
+    for itr.HasNext() {
+        kv, err := itr.Next()
+        if err != nil {
+            return nil, fmt.Errorf("Failed to get restraint stored. %s", err.Error())
+        }
+        _, compositeKeyParts, err := stub.SplitCompositeKey(kv.Key)
+        if err != nil {
+            return nil, fmt.Errorf("Failed to parse restraint stored. %s", err.Error())
+        }
+        if RestraintType(kv.Value[0]).allow() {
+            payees = append(payees, compositeKeyParts[1])
+        }
+    }
+
+    return payees, nil
+}
+
+// 查找从 username_a 到 username_b 的转账路径，路径包含 username_a 和 username_b
+// 找不到时返回 nil
+func searchTransferPath(stub shim.ChaincodeStubInterface, username_a, username_b string, max_hops int, require_opt_in bool) ([]string, error) {
+    for _, username := range []string{username_a, username_b} {
+        frozen, err := isFrozen(stub, username)
+        if err != nil {
+            return nil, err
+        }
+        if frozen {
+            return nil, nil
+        }
+    }
+
+    parents := map[string]string{username_a: ""}
+    frontier := []string{username_a}
+
+    for hop := 1; hop <= max_hops && len(frontier) > 0; hop++ {
+        next := []string{}
+
+        for _, username := range frontier {
+            payees, err := listPayees(stub, username)
+            if err != nil {
+                return nil, err
+            }
+
+            for _, payee := range payees {
+                if _, visited := parents[payee]; visited {
+                    continue
+                }
+
+                if payee == username_b {
+                    path := []string{payee}
+                    for node := username; node != ""; node = parents[node] {
+                        path = append([]string{node}, path...)
+                    }
+                    return path, nil
+                }
+
+                frozen, err := isFrozen(stub, payee)
+                if err != nil {
+                    return nil, err
+                }
+                if frozen {
+                    continue
+                }
+
+                if require_opt_in {
+                    opted_in, err := isRoutingOptedIn(stub, payee)
+                    if err != nil {
+                        return nil, err
+                    }
+                    if !opted_in {
+                        continue
+                    }
+                }
+
+                parents[payee] = username
+                next = append(next, payee)
+            }
+        }
+
+        frontier = next
+    }
+
+    return nil, nil
+}
+
+// 解析 findTransferPath 和 routedTransfer 共用的 max_hops、require_opt_in 参数
+func parseRouteArgs(max_hops_str, require_opt_in_str string) (int, bool, error) {
+    max_hops, err := strconv.Atoi(max_hops_str)
+    if err != nil || max_hops < 1 || max_hops > MAX_ROUTE_HOPS {
+        return 0, false, fmt.Errorf("max_hops got %s, expected an integer in [1, %d]", max_hops_str, MAX_ROUTE_HOPS)
+    }
+
+    require_opt_in, err := strconv.ParseBool(require_opt_in_str)
+    if err != nil {
+        return 0, false, fmt.Errorf("require_opt_in got %s, expected true or false", require_opt_in_str)
+    }
+
+    return max_hops, require_opt_in, nil
+}
+
+// 设置用户是否愿意作为多跳转账的中间账户，只有用户的所有者可以调用
+// 返回值：nil
+func (cc *RestrainedTransferCC) setRoutingOptIn(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'setRoutingOptIn', args: ['username', 'true or false']}"`)
+    }
+
+    username := strings.TrimSpace(args[0])
+    opt_in_str := strings.TrimSpace(args[1])
+
+    var err error
+
+    opt_in, err := strconv.ParseBool(opt_in_str)
+    if err != nil {
+        return shim.Error("opt_in got " + opt_in_str + ", expected true or false")
+    }
+
+    if err = checkRegistered(stub, "username", username); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkCallerOwns(stub, username); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    opt_in_key, err := stub.CreateCompositeKey("u_o:", []string{username})
+    if err != nil {
+        return shim.Error("username is not valid. " + err.Error())
+    }
+
+    if opt_in {
+        err = stub.PutState(opt_in_key, []byte("1"))
+        if err != nil {
+            return shim.Error("Failed to put state. " + err.Error())
+        }
+    } else {
+        err = stub.DelState(opt_in_key)
+        if err != nil {
+            return shim.Error("Failed to del state. " + err.Error())
+        }
+    }
+
+    return shim.Success(nil)
+}
+
+// 查找从 username_a 到 username_b 的转账路径
+// max_hops 为最多允许的跳数，1 到 5
+// require_opt_in 为 true 时只经过通过 setRoutingOptIn 声明愿意作为中间账户的用户
+// 返回值：json字符串，包含 username_a 和 username_b，找不到时为 []
+// ["user_a","user_x","user_b"]
+func (cc *RestrainedTransferCC) findTransferPath(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 4 {
+        return shim.Error(`parameter error. usage: "{fcn: 'findTransferPath', args: ['username_a', 'username_b', 'max_hops', 'require_opt_in']}"`)
+    }
+
+    username_a := strings.TrimSpace(args[0])
+    username_b := strings.TrimSpace(args[1])
+
+    if username_a == username_b {
+        return shim.Error("username_a and username_b must not be equal")
+    }
+
+    var err error
+
+    max_hops, require_opt_in, err := parseRouteArgs(strings.TrimSpace(args[2]), strings.TrimSpace(args[3]))
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkRegistered(stub, "username_a", username_a); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkRegistered(stub, "username_b", username_b); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    path, err := searchTransferPath(stub, username_a, username_b, max_hops, require_opt_in)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if path == nil {
+        path = []string{}
+    }
+
+    pathAsBytes, err := json.Marshal(path)
+    if err != nil {
+        return shim.Error("Failed to format transfer path. " + err.Error())
+    }
+
+    return shim.Success(pathAsBytes)
+}
+
+// 通过 findTransferPath 找到的路径从 username_a 转账给 username_b，调用者必须是 username_a 的所有者
+// 路径上的每一跳都按 transfer 的规则检查，任何一跳失败则整个交易失败
+// 返回值：json字符串，实际使用的转账路径，格式同 findTransferPath；被制裁名单拦截时返回值见 screening.go
+func (cc *RestrainedTransferCC) routedTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 5 {
+        return shim.Error(`parameter error. usage: "{fcn: 'routedTransfer', args: ['username_a', 'username_b', 'amount', 'max_hops', 'require_opt_in']}"`)
+    }
+
+    username_a := strings.TrimSpace(args[0])
+    username_b := strings.TrimSpace(args[1])
+    amount_str := strings.TrimSpace(args[2])
+
+    if username_a == username_b {
+        return shim.Error("username_a and username_b must not be equal")
+    }
+
+    var err error
+
+    amount, err := validateAmount(stub, DEFAULT_ASSET, "amount", amount_str)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    max_hops, require_opt_in, err := parseRouteArgs(strings.TrimSpace(args[3]), strings.TrimSpace(args[4]))
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkRegistered(stub, "username_a", username_a); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkRegistered(stub, "username_b", username_b); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkCallerOwns(stub, username_a); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    path, err := searchTransferPath(stub, username_a, username_b, max_hops, require_opt_in)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if path == nil {
+        return shim.Error(fmt.Sprintf("no transfer path from %s to %s within %d hops.", username_a, username_b, max_hops))
+    }
+
+    // 先筛查整条路径，避免已经完成部分转账后才被拦截
+    if err = screen(stub, "transfer", amount.String(), path...); err != nil {
+        return screeningErrorResponse(err)
+    }
+
+    for i := 0; i+1 < len(path); i++ {
+        err = doTransfer(stub, path[i], path[i+1], amount)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+    }
+
+    pathAsBytes, err := json.Marshal(path)
+    if err != nil {
+        return shim.Error("Failed to format transfer path. " + err.Error())
+    }
+
+    return shim.Success(pathAsBytes)
+}
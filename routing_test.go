@@ -0,0 +1,66 @@
+package main
+
+import (
+    "testing"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestRoutedTransfer(t *testing.T) {
+    stub := shim.NewMockStub("TestRoutedTransfer", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "user_a", "")
+    testRegister(t, stub, "user_b", "")
+    testRegister(t, stub, "user_c", "")
+    testRegister(t, stub, "user_d", "")
+    testRegister(t, stub, "user_e", "")
+
+    testSetRestraint(t, stub, "user_a", "user_b", "1")
+    testSetRestraint(t, stub, "user_b", "user_c", "1")
+    testSetRestraint(t, stub, "user_c", "user_d", "3")
+    testSetRestraint(t, stub, "user_a", "user_e", "1")
+    testSetRestraint(t, stub, "user_e", "user_d", "2")
+
+    testInvoke(t, stub, `["user_a","user_b"]`, "findTransferPath", "user_a", "user_b", "1", "false")
+    testInvoke(t, stub, `[]`, "findTransferPath", "user_a", "user_c", "1", "false")
+    testInvoke(t, stub, `["user_a","user_b","user_c"]`, "findTransferPath", "user_a", "user_c", "2", "false")
+    testInvoke(t, stub, `["user_a","user_b","user_c","user_d"]`, "findTransferPath", "user_a", "user_d", "5", "false")
+    testInvoke(t, stub, `[]`, "findTransferPath", "user_d", "user_a", "5", "false")
+    testInvokeFail(t, stub, "findTransferPath", "user_a", "user_d", "0", "false")
+    testInvokeFail(t, stub, "findTransferPath", "user_a", "user_d", "6", "false")
+    testInvokeFail(t, stub, "findTransferPath", "user_a", "user_d", "3", "maybe")
+    testInvokeFail(t, stub, "findTransferPath", "user_a", "user_x", "3", "false")
+
+    testInvoke(t, stub, `[]`, "findTransferPath", "user_a", "user_d", "5", "true")
+    testInvokeAsFail(t, stub, "bob", "setRoutingOptIn", "user_b", "true")
+    testInvoke(t, stub, "", "setRoutingOptIn", "user_b", "true")
+    testInvoke(t, stub, `[]`, "findTransferPath", "user_a", "user_d", "5", "true")
+    testInvoke(t, stub, "", "setRoutingOptIn", "user_c", "true")
+    testInvoke(t, stub, `["user_a","user_b","user_c","user_d"]`, "findTransferPath", "user_a", "user_d", "5", "true")
+
+    testRecharge(t, stub, "user_a", "100")
+
+    testInvokeFail(t, stub, "routedTransfer", "user_a", "user_d", "-1", "5", "false")
+    testInvokeFail(t, stub, "routedTransfer", "user_a", "user_d", "1000", "5", "false")
+    testInvokeAsFail(t, stub, "bob", "routedTransfer", "user_a", "user_d", "60", "5", "true")
+    testGetBalance(t, stub, "user_a", "100")
+
+    testInvoke(t, stub, `["user_a","user_b","user_c","user_d"]`, "routedTransfer", "user_a", "user_d", "60", "5", "true")
+    testGetBalance(t, stub, "user_a", "40")
+    testGetBalance(t, stub, "user_b", "0")
+    testGetBalance(t, stub, "user_c", "0")
+    testGetBalance(t, stub, "user_d", "60")
+
+    testInvokeFail(t, stub, "routedTransfer", "user_d", "user_a", "10", "5", "false")
+
+    // 冻结的账户不会作为中间账户
+    testInvoke(t, stub, "", "freezeAccount", "user_c")
+    testInvoke(t, stub, `[]`, "findTransferPath", "user_a", "user_d", "5", "false")
+    testInvoke(t, stub, "", "unfreezeAccount", "user_c")
+
+    testInvoke(t, stub, "", "setRoutingOptIn", "user_b", "false")
+    testInvokeFail(t, stub, "routedTransfer", "user_a", "user_d", "10", "5", "true")
+    testInvoke(t, stub, `["user_a","user_b","user_c","user_d"]`, "routedTransfer", "user_a", "user_d", "10", "3", "false")
+    testGetBalance(t, stub, "user_a", "30")
+    testGetBalance(t, stub, "user_d", "70")
+}
@@ -0,0 +1,568 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+
+    "github.com/shopspring/decimal"
+)
+
+// 定时转账和周期转账
+// 付款人（用户的所有者，见 roles.go）创建转账指令，存储在 st: 下，key 为 [指令 id]。
+// interval 为 0 时只在 next_run 执行一次，否则每隔 interval 秒执行一次，直到 end_time（0 表示没有结束时间）。
+// 任何人都可以调用 executeDue 执行到期的指令，执行时和 transfer 一样检查制裁名单、转账约束、风控限额和余额。
+// 执行失败不会使交易失败，失败原因记录在 st_f: 下，指令照常推进到下一次执行时间；只执行一次的指令执行失败时状态为 failed。
+// 到期索引存储在 st_d: 下，key 为 [补零的 next_run, 指令 id]，按时间顺序遍历；
+// 付款人索引存储在 st_u: 下，key 为 [付款人, 指令 id]。
+
+const (
+    SCHEDULE_ACTIVE    = "active"
+    SCHEDULE_COMPLETED = "completed"
+    SCHEDULE_FAILED    = "failed"
+    SCHEDULE_CANCELLED = "cancelled"
+)
+
+// executeDue 一次最多执行的指令数
+const MAX_EXECUTE_DUE = 100
+
+type ScheduledTransfer struct {
+    Id        string `json:"id"`
+    Payer     string `json:"payer"`
+    Payee     string `json:"payee"`
+    Amount    string `json:"amount"`
+    // 下一次执行的时间，指令结束后为最后一次计划执行的时间
+    NextRun   int64  `json:"next_run"`
+    Interval  int64  `json:"interval"`
+    EndTime   int64  `json:"end_time"`
+    Status    string `json:"status"`
+    CreatedBy string `json:"created_by"`
+    Runs      int64  `json:"runs"`
+    Failures  int64  `json:"failures"`
+    LastError string `json:"last_error"`
+}
+
+type ScheduledTransferFailure struct {
+    Id        string `json:"id"`
+    TxId      string `json:"txid"`
+    RunTime   int64  `json:"run_time"`
+    Reason    string `json:"reason"`
+    Timestamp int64  `json:"timestamp"`
+}
+
+func getScheduledTransfer(stub shim.ChaincodeStubInterface, id string) (*ScheduledTransfer, error) {
+    schedule_key, err := stub.CreateCompositeKey("st:", []string{id})
+    if err != nil {
+        return nil, fmt.Errorf("id is not valid. %s", err.Error())
+    }
+
+    scheduleAsBytes, err := stub.GetState(schedule_key)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if scheduleAsBytes == nil {
+        return nil, nil
+    }
+
+    var schedule ScheduledTransfer
+    err = json.Unmarshal(scheduleAsBytes, &schedule)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to parse scheduled transfer stored. %s", err.Error())
+    }
+
+    return &schedule, nil
+}
+
+func putScheduledTransfer(stub shim.ChaincodeStubInterface, schedule *ScheduledTransfer) error {
+    schedule_key, err := stub.CreateCompositeKey("st:", []string{schedule.Id})
+    if err != nil {
+        return fmt.Errorf("id is not valid. %s", err.Error())
+    }
+
+    scheduleAsBytes, err := json.Marshal(schedule)
+    if err != nil {
+        return fmt.Errorf("Failed to format scheduled transfer. %s", err.Error())
+    }
+
+    err = stub.PutState(schedule_key, scheduleAsBytes)
+    if err != nil {
+        return fmt.Errorf("Failed to put state. %s", err.Error())
+    }
+
+    return nil
+}
+
+// 到期索引的 key，时间补零到 20 位以便按字典序即按时间排序
+func scheduleDueKey(stub shim.ChaincodeStubInterface, next_run int64, id string) (string, error) {
+    due_key, err := stub.CreateCompositeKey("st_d:", []string{fmt.Sprintf("%020d", next_run), id})
+    if err != nil {
+        return "", fmt.Errorf("id is not valid. %s", err.Error())
+    }
+    return due_key, nil
+}
+
+// 解析时间参数，0 表示不设置
+func parseScheduleTime(arg_name, value string) (int64, error) {
+    t, err := strconv.ParseInt(value, 10, 64)
+    if err != nil || t < 0 {
+        return 0, fmt.Errorf("%s got %s, expected a unix timestamp not less than 0", arg_name, value)
+    }
+    return t, nil
+}
+
+// 执行一次指令，返回失败原因，成功时返回 ""
+// 只有 checkTransfer 的检查（制裁名单、转账约束、冻结、风控限额和余额）不通过时记为失败，此时余额没有修改；
+// 检查通过后写入转账时的 error 直接返回，使整个交易失败
+func runScheduledTransfer(stub shim.ChaincodeStubInterface, schedule *ScheduledTransfer) (string, error) {
+    amount, err := decimal.NewFromString(schedule.Amount)
+    if err != nil {
+        return "", fmt.Errorf("Failed to parse scheduled transfer stored. %s", err.Error())
+    }
+
+    check, err := checkTransfer(stub, schedule.Payer, schedule.Payee, amount)
+    if err == nil {
+        return "", commitTransfer(stub, schedule.Payer, schedule.Payee, amount, check)
+    }
+
+    now, err_time := getTxTime(stub)
+    if err_time != nil {
+        return "", err_time
+    }
+
+    failure := ScheduledTransferFailure{
+        Id: schedule.Id,
+        TxId: stub.GetTxID(),
+        RunTime: schedule.NextRun,
+        Reason: err.Error(),
+        Timestamp: now.Unix(),
+    }
+
+    failure_key, err_key := stub.CreateCompositeKey("st_f:", []string{schedule.Id, fmt.Sprintf("%020d", schedule.NextRun)})
+    if err_key != nil {
+        return "", fmt.Errorf("id is not valid. %s", err_key.Error())
+    }
+
+    failureAsBytes, err_json := json.Marshal(failure)
+    if err_json != nil {
+        return "", fmt.Errorf("Failed to format scheduled transfer failure. %s", err_json.Error())
+    }
+
+    err_put := stub.PutState(failure_key, failureAsBytes)
+    if err_put != nil {
+        return "", fmt.Errorf("Failed to put state. %s", err_put.Error())
+    }
+
+    return err.Error(), nil
+}
+
+// 创建定时转账指令，调用者必须是 payer 的所有者
+// start_time 为第一次执行的时间；interval 为执行间隔的秒数，0 表示只执行一次；end_time 为 0 表示没有结束时间
+// 返回值：nil
+func (cc *RestrainedTransferCC) createScheduledTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 7 {
+        return shim.Error(`parameter error. usage: "{fcn: 'createScheduledTransfer', args: ['id', 'payer', 'payee', 'amount', 'start_time', 'interval', 'end_time']}"`)
+    }
+
+    id := strings.TrimSpace(args[0])
+    payer := strings.TrimSpace(args[1])
+    payee := strings.TrimSpace(args[2])
+    amount_str := strings.TrimSpace(args[3])
+
+    if len(id) == 0 {
+        return shim.Error("id should not be empty.")
+    }
+
+    if payer == payee {
+        return shim.Error("payer and payee must not be equal")
+    }
+
+    var err error
+
+    amount, err := validateAmount(stub, DEFAULT_ASSET, "amount", amount_str)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    start_time, err := parseScheduleTime("start_time", strings.TrimSpace(args[4]))
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    interval, err := parseScheduleTime("interval", strings.TrimSpace(args[5]))
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    end_time, err := parseScheduleTime("end_time", strings.TrimSpace(args[6]))
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if end_time > 0 && end_time < start_time {
+        return shim.Error("end_time should not be earlier than start_time.")
+    }
+
+    if err = checkRegistered(stub, "payer", payer); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkRegistered(stub, "payee", payee); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkCallerOwns(stub, payer); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    existing, err := getScheduledTransfer(stub, id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if existing != nil {
+        return shim.Error("scheduled transfer " + id + " already exists.")
+    }
+
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    schedule := &ScheduledTransfer{
+        Id: id,
+        Payer: payer,
+        Payee: payee,
+        Amount: amount.String(),
+        NextRun: start_time,
+        Interval: interval,
+        EndTime: end_time,
+        Status: SCHEDULE_ACTIVE,
+        CreatedBy: caller,
+    }
+
+    err = putScheduledTransfer(stub, schedule)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    due_key, err := scheduleDueKey(stub, start_time, id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    err = stub.PutState(due_key, []byte{0x00})
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    payer_key, err := stub.CreateCompositeKey("st_u:", []string{payer, id})
+    if err != nil {
+        return shim.Error("payer is not valid. " + err.Error())
+    }
+
+    err = stub.PutState(payer_key, []byte{0x00})
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 取消定时转账指令，调用者必须是 payer 的所有者
+// 返回值：nil
+func (cc *RestrainedTransferCC) cancelScheduledTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'cancelScheduledTransfer', args: ['id']}"`)
+    }
+
+    id := strings.TrimSpace(args[0])
+
+    var err error
+
+    schedule, err := getScheduledTransfer(stub, id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if schedule == nil {
+        return shim.Error("scheduled transfer " + id + " does not exist.")
+    }
+
+    if err = checkCallerOwns(stub, schedule.Payer); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if schedule.Status != SCHEDULE_ACTIVE {
+        return shim.Error("scheduled transfer " + id + " is " + schedule.Status + ".")
+    }
+
+    due_key, err := scheduleDueKey(stub, schedule.NextRun, id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    err = stub.DelState(due_key)
+    if err != nil {
+        return shim.Error("Failed to del state. " + err.Error())
+    }
+
+    schedule.Status = SCHEDULE_CANCELLED
+
+    err = putScheduledTransfer(stub, schedule)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 执行所有到期（next_run 不晚于交易时间）的指令，任何人都可以调用
+// max_count 为最多执行的指令数，1 到 100，可以省略，默认为 100
+// 每条指令每次调用最多执行一次，错过多个周期的指令需要多次调用 executeDue 补上
+// 返回值：json字符串
+// {"executed":["rent"],"failed":[{"id":"gym","reason":"Failed transfer, not enough balance."}]}
+func (cc *RestrainedTransferCC) executeDue(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) > 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'executeDue', args: ['max_count'?]}"`)
+    }
+
+    var err error
+
+    max_count := MAX_EXECUTE_DUE
+    if len(args) == 1 {
+        max_count_str := strings.TrimSpace(args[0])
+        max_count, err = strconv.Atoi(max_count_str)
+        if err != nil || max_count < 1 || max_count > MAX_EXECUTE_DUE {
+            return shim.Error(fmt.Sprintf("max_count got %s, expected an integer in [1, %d]", max_count_str, MAX_EXECUTE_DUE))
+        }
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    // 先收集到期的指令，再逐条执行，避免在遍历时修改索引
+    itr, err := stub.GetStateByPartialCompositeKey("st_d:", []string{})
+    if err != nil {
+        return shim.Error("Failed to get state. " + err.Error())
+    }
+
+    due := []string{}
+
+    for itr.HasNext() && len(due) < max_count {
+        kv, err := itr.Next()
+        if err != nil {
+            itr.Close()
+            return shim.Error("Failed to get scheduled transfer stored. " + err.Error())
+        }
+        _, compositeKeyParts, err := stub.SplitCompositeKey(kv.Key)
+        if err != nil {
+            itr.Close()
+            return shim.Error("Failed to parse scheduled transfer stored. " + err.Error())
+        }
+        next_run, err := strconv.ParseInt(compositeKeyParts[0], 10, 64)
+        if err != nil {
+            itr.Close()
+            return shim.Error("Failed to parse scheduled transfer stored. " + err.Error())
+        }
+        if next_run > now.Unix() {
+            break
+        }
+        due = append(due, compositeKeyParts[1])
+    }
+    itr.Close()
+
+    executed := []string{}
+    failed := []MAP{}
+
+    for _, id := range due {
+        schedule, err := getScheduledTransfer(stub, id)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        if schedule == nil || schedule.Status != SCHEDULE_ACTIVE {
+            continue
+        }
+
+        reason, err := runScheduledTransfer(stub, schedule)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+
+        if reason == "" {
+            schedule.Runs++
+            executed = append(executed, id)
+        } else {
+            schedule.Failures++
+            schedule.LastError = reason
+            failed = append(failed, MAP{"id": id, "reason": reason})
+        }
+
+        old_due_key, err := scheduleDueKey(stub, schedule.NextRun, id)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+
+        err = stub.DelState(old_due_key)
+        if err != nil {
+            return shim.Error("Failed to del state. " + err.Error())
+        }
+
+        if schedule.Interval == 0 && reason != "" {
+            schedule.Status = SCHEDULE_FAILED
+        } else if schedule.Interval == 0 || (schedule.EndTime > 0 && schedule.NextRun + schedule.Interval > schedule.EndTime) {
+            schedule.Status = SCHEDULE_COMPLETED
+        } else {
+            schedule.NextRun += schedule.Interval
+
+            new_due_key, err := scheduleDueKey(stub, schedule.NextRun, id)
+            if err != nil {
+                return shim.Error(err.Error())
+            }
+
+            err = stub.PutState(new_due_key, []byte{0x00})
+            if err != nil {
+                return shim.Error("Failed to put state. " + err.Error())
+            }
+        }
+
+        err = putScheduledTransfer(stub, schedule)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+    }
+
+    resultAsBytes, err := json.Marshal(MAP{
+        "executed": executed,
+        "failed": failed,
+    })
+    if err != nil {
+        return shim.Error("Failed to format executeDue result. " + err.Error())
+    }
+
+    return shim.Success(resultAsBytes)
+}
+
+// 查询定时转账指令
+// 返回值：json字符串
+// {
+//     "id":"rent","payer":"user_a","payee":"user_b","amount":"1000",
+//     "next_run":1538352000,"interval":2592000,"end_time":0,"status":"active",
+//     "created_by":"e3b0c442...","runs":1,"failures":0,"last_error":""
+// }
+func (cc *RestrainedTransferCC) getScheduledTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getScheduledTransfer', args: ['id']}"`)
+    }
+
+    id := strings.TrimSpace(args[0])
+
+    schedule, err := getScheduledTransfer(stub, id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if schedule == nil {
+        return shim.Error("scheduled transfer " + id + " does not exist.")
+    }
+
+    scheduleAsBytes, err := json.Marshal(schedule)
+    if err != nil {
+        return shim.Error("Failed to format scheduled transfer. " + err.Error())
+    }
+
+    return shim.Success(scheduleAsBytes)
+}
+
+// 查询付款人的所有定时转账指令，包括已结束和已取消的
+// 返回值：json字符串，格式同 getScheduledTransfer 的数组
+func (cc *RestrainedTransferCC) getScheduledTransfersOfUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getScheduledTransfersOfUser', args: ['username']}"`)
+    }
+
+    username := strings.TrimSpace(args[0])
+
+    var err error
+
+    if err = checkRegistered(stub, "username", username); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    itr, err := stub.GetStateByPartialCompositeKey("st_u:", []string{username})
+    if err != nil {
+        return shim.Error("Failed to get state. " + err.Error())
+    }
+    defer itr.Close()
+
+    schedules := []*ScheduledTransfer{}
+
+    for itr.HasNext() {
+        kv, err := itr.Next()
+        if err != nil {
+            return shim.Error("Failed to get scheduled transfer stored. " + err.Error())
+        }
+        _, compositeKeyParts, err := stub.SplitCompositeKey(kv.Key)
+        if err != nil {
+            return shim.Error("Failed to parse scheduled transfer stored. " + err.Error())
+        }
+        schedule, err := getScheduledTransfer(stub, compositeKeyParts[1])
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        if schedule != nil {
+            schedules = append(schedules, schedule)
+        }
+    }
+
+    schedulesAsBytes, err := json.Marshal(schedules)
+    if err != nil {
+        return shim.Error("Failed to format scheduled transfers. " + err.Error())
+    }
+
+    return shim.Success(schedulesAsBytes)
+}
+
+// 查询定时转账指令的失败记录，按计划执行时间排序
+// 返回值：json字符串
+// [{"id":"rent","txid":"...","run_time":1538352000,"reason":"Failed transfer, not enough balance.","timestamp":1538352060}]
+func (cc *RestrainedTransferCC) getScheduledTransferFailures(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getScheduledTransferFailures', args: ['id']}"`)
+    }
+
+    id := strings.TrimSpace(args[0])
+
+    schedule, err := getScheduledTransfer(stub, id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if schedule == nil {
+        return shim.Error("scheduled transfer " + id + " does not exist.")
+    }
+
+    itr, err := stub.GetStateByPartialCompositeKey("st_f:", []string{id})
+    if err != nil {
+        return shim.Error("Failed to get state. " + err.Error())
+    }
+    defer itr.Close()
+
+    failures := []json.RawMessage{}
+
+    for itr.HasNext() {
+        kv, err := itr.Next()
+        if err != nil {
+            return shim.Error("Failed to get scheduled transfer failure stored. " + err.Error())
+        }
+        failures = append(failures, json.RawMessage(kv.Value))
+    }
+
+    failuresAsBytes, err := json.Marshal(failures)
+    if err != nil {
+        return shim.Error("Failed to format scheduled transfer failures. " + err.Error())
+    }
+
+    return shim.Success(failuresAsBytes)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+    "strings"
+    "testing"
+    "encoding/json"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestScheduledTransfer(t *testing.T) {
+    stub := shim.NewMockStub("TestScheduledTransfer", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "user_a", "")
+    testRegister(t, stub, "user_b", "")
+    testRegister(t, stub, "user_c", "")
+    testSetRestraint(t, stub, "user_a", "user_b", "1")
+    testRecharge(t, stub, "user_a", "25")
+
+    testInvoke(t, stub, mockCallerId, "getUserOwner", "user_a")
+
+    testInvokeFail(t, stub, "createScheduledTransfer", "rent", "user_a", "user_b", "0", "1538352000", "86400", "0")
+    testInvokeFail(t, stub, "createScheduledTransfer", "rent", "user_a", "user_b", "10", "1538352000", "86400", "1538265600")
+    testInvokeFail(t, stub, "createScheduledTransfer", "rent", "user_a", "user_x", "10", "1538352000", "86400", "0")
+    testInvokeAsFail(t, stub, "bob", "createScheduledTransfer", "rent", "user_a", "user_b", "10", "1538352000", "86400", "0")
+    testInvoke(t, stub, "", "createScheduledTransfer", "rent", "user_a", "user_b", "10", "1538352000", "86400", "1538524800")
+    testInvokeFail(t, stub, "createScheduledTransfer", "rent", "user_a", "user_b", "10", "1538352000", "86400", "0")
+    // user_a 不能转给 user_c，执行时记录失败
+    testInvoke(t, stub, "", "createScheduledTransfer", "gift", "user_a", "user_c", "1", "1538352000", "0", "0")
+
+    // 还没有到期
+    testInvokeAt(t, stub, "bob", 1538351999, `{"executed":[],"failed":[]}`, "executeDue")
+    testGetBalance(t, stub, "user_a", "25")
+
+    testInvokeAt(t, stub, "bob", 1538352000, `{"executed":["rent"],"failed":[{"id":"gift","reason":"transfer from user_a to user_c is forbidden."}]}`, "executeDue")
+    testGetBalance(t, stub, "user_a", "15")
+    testGetBalance(t, stub, "user_b", "10")
+    testInvoke(t, stub, `{"id":"gift","payer":"user_a","payee":"user_c","amount":"1","next_run":1538352000,"interval":0,"end_time":0,"status":"failed",` +
+        `"created_by":"` + mockCallerId + `","runs":0,"failures":1,"last_error":"transfer from user_a to user_c is forbidden."}`, "getScheduledTransfer", "gift")
+    testInvoke(t, stub, `[{"id":"gift","txid":"1","run_time":1538352000,"reason":"transfer from user_a to user_c is forbidden.","timestamp":1538352000}]`,
+        "getScheduledTransferFailures", "gift")
+
+    // 错过的周期每次调用补一次
+    testInvokeAt(t, stub, "bob", 1538524800, `{"executed":["rent"],"failed":[]}`, "executeDue", "1")
+    testInvokeAt(t, stub, "bob", 1538524800, `{"executed":[],"failed":[{"id":"rent","reason":"Failed transfer, not enough balance."}]}`, "executeDue")
+    testInvokeAt(t, stub, "bob", 1538524800, `{"executed":[],"failed":[]}`, "executeDue")
+    testGetBalance(t, stub, "user_a", "5")
+    testInvoke(t, stub, `{"id":"rent","payer":"user_a","payee":"user_b","amount":"10","next_run":1538524800,"interval":86400,"end_time":1538524800,"status":"completed",` +
+        `"created_by":"` + mockCallerId + `","runs":2,"failures":1,"last_error":"Failed transfer, not enough balance."}`, "getScheduledTransfer", "rent")
+
+    // 取消
+    testInvoke(t, stub, "", "createScheduledTransfer", "sub", "user_a", "user_b", "1", "1538611200", "3600", "0")
+    testInvokeAsFail(t, stub, "bob", "cancelScheduledTransfer", "sub")
+    testInvoke(t, stub, "", "cancelScheduledTransfer", "sub")
+    testInvokeFail(t, stub, "cancelScheduledTransfer", "sub")
+    testInvokeAt(t, stub, "bob", 1538611200, `{"executed":[],"failed":[]}`, "executeDue")
+    testGetBalance(t, stub, "user_a", "5")
+
+    // admin 更换所有者后由新的所有者创建
+    testInvokeAsFail(t, stub, "bob", "setUserOwner", "user_a", bobId)
+    testInvoke(t, stub, "", "setUserOwner", "user_a", bobId)
+    testInvokeFail(t, stub, "createScheduledTransfer", "sub2", "user_a", "user_b", "1", "1538611200", "0", "0")
+    testInvokeAs(t, stub, "bob", "", "createScheduledTransfer", "sub2", "user_a", "user_b", "1", "1538611200", "0", "0")
+
+    ret := stub.MockInvoke("1", [][]byte{[]byte("getScheduledTransfersOfUser"), []byte("user_a")})
+    if ret.Status != shim.OK {
+        t.Fatal("getScheduledTransfersOfUser failed. " + ret.Message)
+    }
+    var schedules []ScheduledTransfer
+    if err := json.Unmarshal(ret.Payload, &schedules); err != nil {
+        t.Fatal("getScheduledTransfersOfUser return invalid json. " + err.Error())
+    }
+    ids := []string{}
+    for _, schedule := range schedules {
+        ids = append(ids, schedule.Id + ":" + schedule.Status)
+    }
+    if strings.Join(ids, ",") != "gift:failed,rent:completed,sub:cancelled,sub2:active" {
+        t.Fatalf("getScheduledTransfersOfUser return %v", ids)
+    }
+
+    // 同一次 executeDue 中多条指令被筛查拦截时各有一条筛查记录
+    testInvokeAs(t, stub, "bob", "", "createScheduledTransfer", "s1", "user_a", "user_b", "1", "1538697600", "0", "0")
+    testInvokeAs(t, stub, "bob", "", "createScheduledTransfer", "s2", "user_a", "user_b", "1", "1538697600", "0", "0")
+    testInvoke(t, stub, "", "grantRole", "compliance", mockCallerId)
+    testInvoke(t, stub, "", "addScreeningEntry", "user", "user_b", "sanctioned")
+    blocked := `"reason":"user user_b is on the screening list."}`
+    testInvokeAt(t, stub, "carol", 1538697600, `{"executed":[],"failed":[{"id":"sub2",` + blocked + `,{"id":"s1",` + blocked + `,{"id":"s2",` + blocked + `]}`, "executeDue")
+    testGetBalance(t, stub, "user_a", "5")
+
+    ret = stub.MockInvoke("1", [][]byte{[]byte("getScreeningAudit")})
+    if ret.Status != shim.OK {
+        t.Fatal("getScreeningAudit failed. " + ret.Message)
+    }
+    var audits []ScreeningAudit
+    if err := json.Unmarshal(ret.Payload, &audits); err != nil {
+        t.Fatal("getScreeningAudit return invalid json. " + err.Error())
+    }
+    ids = []string{}
+    for _, audit := range audits {
+        ids = append(ids, audit.Id)
+    }
+    if strings.Join(ids, ",") != "1,1.1,1.2" {
+        t.Fatalf("getScreeningAudit return %v", ids)
+    }
+}
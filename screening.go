@@ -0,0 +1,482 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// 制裁名单筛查
+// 名单由 compliance 角色维护，存储在 s_l: 下，key 为 [类型, 值]，类型为 user（用户名）或 identity（身份哈希，见 roles.go）。
+// transfer、recharge、withdraw 涉及名单上的用户（包括父账户在名单上的子账户，见 subaccounts.go）或调用者身份时不会执行，
+// 而是在 s_a: 下记录一条筛查记录，并且交易本身返回成功，返回值说明被拦截的原因，这样拦截记录才能写入账本。
+// 筛查记录的 key 为记录 id，规则同转账 id（见 journal.go），executeDue 等一个交易内被拦截多次时各有一条记录。
+// 筛查规则存储在 s_c: 下，可以配置筛查哪些操作、是否筛查调用者身份。
+
+const (
+    SCREENING_USER     = "user"
+    SCREENING_IDENTITY = "identity"
+)
+
+type ScreeningEntry struct {
+    Kind     string `json:"kind"`
+    Value    string `json:"value"`
+    Reason   string `json:"reason"`
+    ListedBy string `json:"listed_by"`
+    ListedAt int64  `json:"listed_at"`
+}
+
+type ScreeningRules struct {
+    // 需要筛查的操作，transfer、recharge、withdraw 中的若干个
+    Operations   []string `json:"operations"`
+    // 是否筛查调用者的身份哈希
+    ScreenCaller bool     `json:"screen_caller"`
+}
+
+var defaultScreeningRules = ScreeningRules{
+    Operations:   []string{"transfer", "recharge", "withdraw"},
+    ScreenCaller: true,
+}
+
+type ScreeningAudit struct {
+    Id        string   `json:"id"`
+    TxId      string   `json:"txid"`
+    Operation string   `json:"operation"`
+    Parties   []string `json:"parties"`
+    Amount    string   `json:"amount"`
+    Caller    string   `json:"caller"`
+    Kind      string   `json:"kind"`
+    Value     string   `json:"value"`
+    Reason    string   `json:"reason"`
+    Timestamp int64    `json:"timestamp"`
+}
+
+// 被筛查拦截时返回的 error，拦截记录已经写入
+type ScreeningBlocked struct {
+    Audit ScreeningAudit
+}
+
+func (e *ScreeningBlocked) Error() string {
+    return fmt.Sprintf("%s %s is on the screening list.", e.Audit.Kind, e.Audit.Value)
+}
+
+// 交易返回成功，返回值为
+// {"blocked":true,"audit_id":"txid","reason":"..."}
+func (e *ScreeningBlocked) response() pb.Response {
+    blockedAsBytes, err := json.Marshal(MAP{
+        "blocked": true,
+        "audit_id": e.Audit.Id,
+        "reason": e.Error(),
+    })
+    if err != nil {
+        return shim.Error("Failed to format screening result. " + err.Error())
+    }
+
+    return shim.Success(blockedAsBytes)
+}
+
+// err 是 ScreeningBlocked 时返回成功，否则返回 ERROR
+func screeningErrorResponse(err error) pb.Response {
+    if blocked, ok := err.(*ScreeningBlocked); ok {
+        return blocked.response()
+    }
+    return shim.Error(err.Error())
+}
+
+func getScreeningRules(stub shim.ChaincodeStubInterface) (ScreeningRules, error) {
+    rules_key, err := stub.CreateCompositeKey("s_c:", []string{})
+    if err != nil {
+        return ScreeningRules{}, fmt.Errorf("Failed to create key. %s", err.Error())
+    }
+
+    rulesAsBytes, err := stub.GetState(rules_key)
+    if err != nil {
+        return ScreeningRules{}, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if rulesAsBytes == nil {
+        return defaultScreeningRules, nil
+    }
+
+    var rules ScreeningRules
+    err = json.Unmarshal(rulesAsBytes, &rules)
+    if err != nil {
+        return ScreeningRules{}, fmt.Errorf("Failed to parse screening rules stored. %s", err.Error())
+    }
+
+    return rules, nil
+}
+
+func getScreeningEntry(stub shim.ChaincodeStubInterface, kind, value string) (*ScreeningEntry, error) {
+    entry_key, err := stub.CreateCompositeKey("s_l:", []string{kind, value})
+    if err != nil {
+        return nil, fmt.Errorf("screening value is not valid. %s", err.Error())
+    }
+
+    entryAsBytes, err := stub.GetState(entry_key)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if entryAsBytes == nil {
+        return nil, nil
+    }
+
+    var entry ScreeningEntry
+    err = json.Unmarshal(entryAsBytes, &entry)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to parse screening entry stored. %s", err.Error())
+    }
+
+    return &entry, nil
+}
+
+// 筛查 operation 涉及的用户和调用者
+// 命中名单时写入筛查记录并返回 *ScreeningBlocked
+func screen(stub shim.ChaincodeStubInterface, operation, amount string, parties ...string) error {
+    rules, err := getScreeningRules(stub)
+    if err != nil {
+        return err
+    }
+
+    screened := false
+    for _, op := range rules.Operations {
+        if op == operation {
+            screened = true
+        }
+    }
+    if !screened {
+        return nil
+    }
+
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return err
+    }
+
+    var hit *ScreeningEntry
+
+    // 子账户同时按父账户筛查
+    for _, username := range parties {
+        holder, err := getAccountHolder(stub, username)
+        if err != nil {
+            return err
+        }
+
+        hit, err = getScreeningEntry(stub, SCREENING_USER, username)
+        if err != nil {
+            return err
+        }
+        if hit == nil && holder != username {
+            hit, err = getScreeningEntry(stub, SCREENING_USER, holder)
+            if err != nil {
+                return err
+            }
+        }
+        if hit != nil {
+            break
+        }
+    }
+
+    if hit == nil && rules.ScreenCaller {
+        hit, err = getScreeningEntry(stub, SCREENING_IDENTITY, caller)
+        if err != nil {
+            return err
+        }
+    }
+
+    if hit == nil {
+        return nil
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return err
+    }
+
+    id, err := newTxScopedId(stub, "s_a:")
+    if err != nil {
+        return err
+    }
+
+    audit := ScreeningAudit{
+        Id: id,
+        TxId: stub.GetTxID(),
+        Operation: operation,
+        Parties: parties,
+        Amount: amount,
+        Caller: caller,
+        Kind: hit.Kind,
+        Value: hit.Value,
+        Reason: hit.Reason,
+        Timestamp: now.Unix(),
+    }
+
+    audit_key, err := stub.CreateCompositeKey("s_a:", []string{audit.Id})
+    if err != nil {
+        return fmt.Errorf("audit id is not valid. %s", err.Error())
+    }
+
+    auditAsBytes, err := json.Marshal(audit)
+    if err != nil {
+        return fmt.Errorf("Failed to format screening audit. %s", err.Error())
+    }
+
+    err = stub.PutState(audit_key, auditAsBytes)
+    if err != nil {
+        return fmt.Errorf("Failed to put state. %s", err.Error())
+    }
+
+    return &ScreeningBlocked{audit}
+}
+
+// 把用户名或身份哈希加入制裁名单，只有 compliance 角色可以调用
+// kind 为 user 或 identity
+// 返回值：nil
+func (cc *RestrainedTransferCC) addScreeningEntry(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 3 {
+        return shim.Error(`parameter error. usage: "{fcn: 'addScreeningEntry', args: ['user or identity', 'value', 'reason']}"`)
+    }
+
+    kind := strings.TrimSpace(args[0])
+    value := strings.TrimSpace(args[1])
+    reason := args[2]
+
+    if kind != SCREENING_USER && kind != SCREENING_IDENTITY {
+        return shim.Error("kind got " + kind + ", expected user or identity")
+    }
+
+    if len(value) == 0 {
+        return shim.Error("value should not be empty.")
+    }
+
+    var err error
+
+    if err = checkCallerRole(stub, ROLE_COMPLIANCE); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    entry_key, err := stub.CreateCompositeKey("s_l:", []string{kind, value})
+    if err != nil {
+        return shim.Error("value is not valid. " + err.Error())
+    }
+
+    entryAsBytes, err := json.Marshal(ScreeningEntry{kind, value, reason, caller, now.Unix()})
+    if err != nil {
+        return shim.Error("Failed to format screening entry. " + err.Error())
+    }
+
+    err = stub.PutState(entry_key, entryAsBytes)
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 把用户名或身份哈希移出制裁名单，只有 compliance 角色可以调用
+// 返回值：nil
+func (cc *RestrainedTransferCC) removeScreeningEntry(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'removeScreeningEntry', args: ['user or identity', 'value']}"`)
+    }
+
+    kind := strings.TrimSpace(args[0])
+    value := strings.TrimSpace(args[1])
+
+    var err error
+
+    if err = checkCallerRole(stub, ROLE_COMPLIANCE); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    entry, err := getScreeningEntry(stub, kind, value)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if entry == nil {
+        return shim.Error(kind + " " + value + " is not on the screening list.")
+    }
+
+    entry_key, err := stub.CreateCompositeKey("s_l:", []string{kind, value})
+    if err != nil {
+        return shim.Error("value is not valid. " + err.Error())
+    }
+
+    err = stub.DelState(entry_key)
+    if err != nil {
+        return shim.Error("Failed to del state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询制裁名单，只有 compliance 角色可以调用
+// 返回值：json字符串
+// [
+//     {"kind":"user","value":"user_a","reason":"balabala","listed_by":"e3b0c442...","listed_at":1537776000}
+// ]
+func (cc *RestrainedTransferCC) getScreeningList(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 0 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getScreeningList', args: []}"`)
+    }
+
+    var err error
+
+    if err = checkCallerRole(stub, ROLE_COMPLIANCE); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    itr, err := stub.GetStateByPartialCompositeKey("s_l:", []string{})
+    if err != nil {
+        return shim.Error("Failed to get state. " + err.Error())
+    }
+    defer itr.Close()
+
+    entries := []json.RawMessage{}
+
+    for itr.HasNext() {
+        kv, err := itr.Next()
+        if err != nil {
+            return shim.Error("Failed to get screening entry stored. " + err.Error())
+        }
+        entries = append(entries, json.RawMessage(kv.Value))
+    }
+
+    entriesAsBytes, err := json.Marshal(entries)
+    if err != nil {
+        return shim.Error("Failed to format screening list. " + err.Error())
+    }
+
+    return shim.Success(entriesAsBytes)
+}
+
+// 设置筛查规则，只有 compliance 角色可以调用
+// rules 为json字符串，默认规则为
+// {"operations":["transfer","recharge","withdraw"],"screen_caller":true}
+// 返回值：nil
+func (cc *RestrainedTransferCC) setScreeningRules(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'setScreeningRules', args: ['rules']}"`)
+    }
+
+    var err error
+
+    var rules ScreeningRules
+    err = json.Unmarshal([]byte(args[0]), &rules)
+    if err != nil {
+        return shim.Error("Invalid screening rules, expecting json. " + err.Error())
+    }
+    if rules.Operations == nil {
+        rules.Operations = []string{}
+    }
+
+    for _, op := range rules.Operations {
+        if op != "transfer" && op != "recharge" && op != "withdraw" {
+            return shim.Error("operation got " + op + ", expected one of [transfer, recharge, withdraw]")
+        }
+    }
+
+    if err = checkCallerRole(stub, ROLE_COMPLIANCE); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    rules_key, err := stub.CreateCompositeKey("s_c:", []string{})
+    if err != nil {
+        return shim.Error("Failed to create key. " + err.Error())
+    }
+
+    rulesAsBytes, err := json.Marshal(rules)
+    if err != nil {
+        return shim.Error("Failed to format screening rules. " + err.Error())
+    }
+
+    err = stub.PutState(rules_key, rulesAsBytes)
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询筛查规则
+// 返回值：json字符串，格式见 setScreeningRules
+func (cc *RestrainedTransferCC) getScreeningRules(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 0 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getScreeningRules', args: []}"`)
+    }
+
+    rules, err := getScreeningRules(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    rulesAsBytes, err := json.Marshal(rules)
+    if err != nil {
+        return shim.Error("Failed to format screening rules. " + err.Error())
+    }
+
+    return shim.Success(rulesAsBytes)
+}
+
+// 查询所有被拦截的操作，只有 compliance 角色可以调用
+// 返回值：json字符串
+// [
+//     {
+//         "id":"...",
+//         "txid":"...",
+//         "operation":"transfer",
+//         "parties":["user_a","user_b"],
+//         "amount":"100",
+//         "caller":"e3b0c442...",
+//         "kind":"user",
+//         "value":"user_b",
+//         "reason":"balabala",
+//         "timestamp":1537776000
+//     }
+// ]
+func (cc *RestrainedTransferCC) getScreeningAudit(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 0 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getScreeningAudit', args: []}"`)
+    }
+
+    var err error
+
+    if err = checkCallerRole(stub, ROLE_COMPLIANCE); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    itr, err := stub.GetStateByPartialCompositeKey("s_a:", []string{})
+    if err != nil {
+        return shim.Error("Failed to get state. " + err.Error())
+    }
+    defer itr.Close()
+
+    audits := []json.RawMessage{}
+
+    for itr.HasNext() {
+        kv, err := itr.Next()
+        if err != nil {
+            return shim.Error("Failed to get screening audit stored. " + err.Error())
+        }
+        audits = append(audits, json.RawMessage(kv.Value))
+    }
+
+    auditsAsBytes, err := json.Marshal(audits)
+    if err != nil {
+        return shim.Error("Failed to format screening audit. " + err.Error())
+    }
+
+    return shim.Success(auditsAsBytes)
+}
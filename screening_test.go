@@ -0,0 +1,70 @@
+package main
+
+import (
+    "testing"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestScreening(t *testing.T) {
+    stub := shim.NewMockStub("TestScreening", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "user_a", "")
+    testRegister(t, stub, "user_b", "")
+    testSetRestraint(t, stub, "user_a", "user_b", "3")
+    testRecharge(t, stub, "user_a", "100")
+
+    testInvokeFail(t, stub, "addScreeningEntry", "user", "user_b", "sanctioned")
+    testInvoke(t, stub, "", "grantRole", "compliance", mockCallerId)
+
+    testInvokeFail(t, stub, "addScreeningEntry", "company", "user_b", "sanctioned")
+    testInvokeFail(t, stub, "addScreeningEntry", "user", "", "sanctioned")
+    testInvokeAsFail(t, stub, "bob", "addScreeningEntry", "user", "user_b", "sanctioned")
+    testInvokeAt(t, stub, "", 1537776000, "", "addScreeningEntry", "user", "user_b", "sanctioned")
+    testInvokeAt(t, stub, "", 1537776000, "", "addScreeningEntry", "identity", bobId, "stolen key")
+
+    testInvoke(t, stub, `[{"kind":"identity","value":"` + bobId + `","reason":"stolen key","listed_by":"` + mockCallerId + `","listed_at":1537776000},` +
+        `{"kind":"user","value":"user_b","reason":"sanctioned","listed_by":"` + mockCallerId + `","listed_at":1537776000}]`, "getScreeningList")
+    testInvokeAsFail(t, stub, "bob", "getScreeningList")
+
+    testInvokeAt(t, stub, "", 1537776001, `{"audit_id":"1","blocked":true,"reason":"user user_b is on the screening list."}`, "transfer", "user_a", "user_b", "10")
+    testGetBalance(t, stub, "user_a", "100")
+    testGetBalance(t, stub, "user_b", "0")
+
+    testInvoke(t, stub, `[{"id":"1","txid":"1","operation":"transfer","parties":["user_a","user_b"],"amount":"10","caller":"` + mockCallerId + `",` +
+        `"kind":"user","value":"user_b","reason":"sanctioned","timestamp":1537776001}]`, "getScreeningAudit")
+    testInvokeAsFail(t, stub, "bob", "getScreeningAudit")
+
+    testInvoke(t, stub, `{"audit_id":"1.1","blocked":true,"reason":"user user_b is on the screening list."}`, "recharge", "user_b", "10")
+    testInvoke(t, stub, `{"audit_id":"1.2","blocked":true,"reason":"user user_b is on the screening list."}`, "withdraw", "user_b", "10")
+    testGetBalance(t, stub, "user_b", "0")
+
+    // 被拦截的调用者身份
+    testInvokeAs(t, stub, "bob", `{"audit_id":"1.3","blocked":true,"reason":"identity ` + bobId + ` is on the screening list."}`, "withdraw", "user_a", "10")
+    testGetBalance(t, stub, "user_a", "100")
+
+    testInvoke(t, stub, `{"operations":["transfer","recharge","withdraw"],"screen_caller":true}`, "getScreeningRules")
+    testInvokeFail(t, stub, "setScreeningRules", `{"operations":["register"]}`)
+    testInvokeFail(t, stub, "setScreeningRules", `not json`)
+    testInvokeAsFail(t, stub, "bob", "setScreeningRules", `{"operations":["transfer"]}`)
+    testInvoke(t, stub, "", "setScreeningRules", `{"operations":["transfer"]}`)
+    testInvoke(t, stub, `{"operations":["transfer"],"screen_caller":false}`, "getScreeningRules")
+
+    testInvokeAs(t, stub, "bob", "", "withdraw", "user_a", "10")
+    testRecharge(t, stub, "user_b", "10")
+    testGetBalance(t, stub, "user_b", "10")
+
+    testInvokeFail(t, stub, "removeScreeningEntry", "user", "user_a")
+    testInvoke(t, stub, "", "removeScreeningEntry", "user", "user_b")
+    testTransfer(t, stub, "user_a", "user_b", "10")
+    testGetBalance(t, stub, "user_a", "80")
+    testGetBalance(t, stub, "user_b", "20")
+
+    // 多跳转账在执行前筛查整条路径
+    testRegister(t, stub, "user_c", "")
+    testSetRestraint(t, stub, "user_b", "user_c", "1")
+    testInvoke(t, stub, "", "addScreeningEntry", "user", "user_b", "sanctioned")
+    testInvoke(t, stub, `{"audit_id":"1.4","blocked":true,"reason":"user user_b is on the screening list."}`, "routedTransfer", "user_a", "user_c", "10", "2", "false")
+    testGetBalance(t, stub, "user_a", "80")
+    testGetBalance(t, stub, "user_c", "0")
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+    "fmt"
+    "time"
+    "strconv"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// 交易的时间戳，由客户端生成并经过背书节点确认，所有背书节点得到的值相同
+func getTxTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+    ts, err := stub.GetTxTimestamp()
+    if err != nil {
+        return time.Time{}, fmt.Errorf("Failed to get tx timestamp. %s", err.Error())
+    }
+    return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+// Fabric 的同一个交易内，GetState 读不到本交易 PutState/DelState 写入的值。
+// routedTransfer 等函数需要在一个交易内多次修改同一个账户，
+// 所以 Invoke 把 stub 包装成 txStub，GetState 优先返回本交易已写入的值。
+// 注意：GetStateByPartialCompositeKey 等范围查询仍然只能读到已提交的值。
+type txStub struct {
+    shim.ChaincodeStubInterface
+    // 本交易写入的值，nil 表示已删除
+    writes map[string][]byte
+}
+
+func newTxStub(stub shim.ChaincodeStubInterface) shim.ChaincodeStubInterface {
+    if _, ok := stub.(*txStub); ok {
+        return stub
+    }
+    return &txStub{ChaincodeStubInterface: stub, writes: map[string][]byte{}}
+}
+
+func (s *txStub) GetState(key string) ([]byte, error) {
+    if value, ok := s.writes[key]; ok {
+        return value, nil
+    }
+    return s.ChaincodeStubInterface.GetState(key)
+}
+
+func (s *txStub) PutState(key string, value []byte) error {
+    if err := s.ChaincodeStubInterface.PutState(key, value); err != nil {
+        return err
+    }
+    s.writes[key] = value
+    return nil
+}
+
+func (s *txStub) DelState(key string) error {
+    if err := s.ChaincodeStubInterface.DelState(key); err != nil {
+        return err
+    }
+    s.writes[key] = nil
+    return nil
+}
+
+// 为本交易创建的对象生成 id：第一个为交易 id，之后依次为 交易id.1、交易id.2 ……
+// objectType 下已经存在（包括本交易写入）的 id 会被跳过
+func newTxScopedId(stub shim.ChaincodeStubInterface, objectType string) (string, error) {
+    id := stub.GetTxID()
+    for n := 1; ; n++ {
+        key, err := stub.CreateCompositeKey(objectType, []string{id})
+        if err != nil {
+            return "", fmt.Errorf("txid is not valid. %s", err.Error())
+        }
+
+        valueAsBytes, err := stub.GetState(key)
+        if err != nil {
+            return "", fmt.Errorf("Failed to get state. %s", err.Error())
+        }
+        if valueAsBytes == nil {
+            return id, nil
+        }
+
+        id = stub.GetTxID() + "." + strconv.Itoa(n)
+    }
+}
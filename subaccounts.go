@@ -0,0 +1,308 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+
+    "github.com/shopspring/decimal"
+)
+
+// 子账户
+// 用户可以通过 openSubAccount 在自己名下开设多个子账户（如 operating、savings、tax），
+// 子账户是一个完整的账户，账户名为 用户名/子账户名，有自己的 u_i:、u_b:，所有者与父账户相同，
+// 可以像普通用户一样作为 transfer、setRestraint 等函数的参数，从而针对对方的某个子账户设置约束或转账。
+// 子账户的 u_i: 中记录父账户（parent），用户名不能包含 /，所以子账户名不会和普通用户名冲突；子账户下不能再开子账户。
+// 每个用户的子账户列表存储在 u_sa: 下，key 为 [用户名, 子账户名]。
+//
+// 同一个用户和它的子账户之间（包括子账户之间）转账不需要转账约束；
+// 父账户的所有者可以通过 moveBetweenSubAccounts 在它们之间移动资金，不受风控限额和 KYC 限制，但和 transfer 一样检查冻结和制裁名单。
+// 冻结父账户或把父账户加入制裁名单同样作用于它的所有子账户，见 isFrozen 和 screen。
+// 子账户与其他账户之间没有直接的转账约束（包括组约束）时，以双方父账户之间的转账约束为准，见 transferAllowed。
+// KYC 等级以父账户为准，见 getKycLevel。
+
+const SUBACCOUNT_SEPARATOR = "/"
+
+// 查询账户的父账户，不是子账户时返回 ""
+func getParentAccount(stub shim.ChaincodeStubInterface, account string) (string, error) {
+    user_info_key, err := stub.CreateCompositeKey("u_i:", []string{account})
+    if err != nil {
+        return "", fmt.Errorf("username is not valid. %s", err.Error())
+    }
+
+    userAsBytes, err := stub.GetState(user_info_key)
+    if err != nil {
+        return "", fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if userAsBytes == nil {
+        return "", nil
+    }
+
+    var info struct {
+        Parent string `json:"parent"`
+    }
+    err = json.Unmarshal(userAsBytes, &info)
+    if err != nil {
+        return "", fmt.Errorf("Failed to parse user info stored. %s", err.Error())
+    }
+
+    return info.Parent, nil
+}
+
+// 查询账户所属的用户：子账户返回父账户，否则返回账户本身
+func getAccountHolder(stub shim.ChaincodeStubInterface, account string) (string, error) {
+    parent, err := getParentAccount(stub, account)
+    if err != nil {
+        return "", err
+    }
+    if parent == "" {
+        return account, nil
+    }
+
+    return parent, nil
+}
+
+// 开设子账户，只有用户的所有者可以调用
+// 返回值：子账户的账户名，如 user_a/savings
+func (cc *RestrainedTransferCC) openSubAccount(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 3 {
+        return shim.Error(`parameter error. usage: "{fcn: 'openSubAccount', args: ['username', 'name', 'extras']}"`)
+    }
+
+    username := strings.TrimSpace(args[0])
+    name := strings.TrimSpace(args[1])
+    extras := args[2]
+
+    var err error
+
+    if err = checkRegistered(stub, "username", username); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = validateUsername("name", name); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkExtras(stub, extras); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    parent, err := getParentAccount(stub, username)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if parent != "" {
+        return shim.Error("username " + username + " is a sub-account.")
+    }
+
+    if err = checkCallerOwns(stub, username); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    account := username + SUBACCOUNT_SEPARATOR + name
+
+    user_info_key, err := stub.CreateCompositeKey("u_i:", []string{account})
+    if err != nil {
+        return shim.Error("name is not valid. " + err.Error())
+    }
+
+    userAsBytes, err := stub.GetState(user_info_key)
+    if err != nil {
+        return shim.Error("Failed to get state. " + err.Error())
+    }
+    if userAsBytes != nil {
+        return shim.Error("sub-account " + account + " already opened.")
+    }
+
+    userAsBytes, err = json.Marshal(MAP{
+        "name": account,
+        "extras": extras,
+        "parent": username,
+    })
+    if err != nil {
+        return shim.Error("Failed to format user info. " + err.Error())
+    }
+
+    err = stub.PutState(user_info_key, userAsBytes)
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    user_balance_key, err := stub.CreateCompositeKey("u_b:", []string{account})
+    if err != nil {
+        return shim.Error("name is not valid. " + err.Error())
+    }
+
+    err = stub.PutState(user_balance_key, []byte(decimal.Zero.String()))
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    owner, err := getOwnerOf(stub, username)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    err = putUserOwner(stub, account, owner)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    sub_account_key, err := stub.CreateCompositeKey("u_sa:", []string{username, name})
+    if err != nil {
+        return shim.Error("name is not valid. " + err.Error())
+    }
+
+    err = stub.PutState(sub_account_key, []byte(account))
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success([]byte(account))
+}
+
+// 查询用户的所有子账户
+// 返回值：json字符串
+// [{"name":"savings","account":"user_a/savings","balance":"100"}]
+func (cc *RestrainedTransferCC) getSubAccounts(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getSubAccounts', args: ['username']}"`)
+    }
+
+    username := strings.TrimSpace(args[0])
+
+    var err error
+
+    if err = checkRegistered(stub, "username", username); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    it, err := stub.GetStateByPartialCompositeKey("u_sa:", []string{username})
+    if err != nil {
+        return shim.Error("Failed to get state. " + err.Error())
+    }
+    defer it.Close()
+
+    sub_accounts := []MAP{}
+    for it.HasNext() {
+        kv, err := it.Next()
+        if err != nil {
+            return shim.Error("Failed to get state. " + err.Error())
+        }
+
+        _, compositeKeyParts, err := stub.SplitCompositeKey(kv.Key)
+        if err != nil {
+            return shim.Error("Failed to parse sub-account stored. " + err.Error())
+        }
+
+        account := string(kv.Value)
+        balance, err := getStoredBalance(stub, "account", account)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+
+        sub_accounts = append(sub_accounts, MAP{
+            "name": compositeKeyParts[1],
+            "account": account,
+            "balance": balance.String(),
+        })
+    }
+
+    subAccountsAsBytes, err := json.Marshal(sub_accounts)
+    if err != nil {
+        return shim.Error("Failed to format sub-accounts. " + err.Error())
+    }
+
+    return shim.Success(subAccountsAsBytes)
+}
+
+// 在同一个用户和它的子账户之间移动资金，只有父账户的所有者可以调用
+// 不需要转账约束，不受风控限额和 KYC 限制；双方都不能被冻结，经过制裁名单筛查，转出方的可用余额要足够；记录转账记录
+// 返回值：nil
+func (cc *RestrainedTransferCC) moveBetweenSubAccounts(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 3 {
+        return shim.Error(`parameter error. usage: "{fcn: 'moveBetweenSubAccounts', args: ['from_account', 'to_account', 'amount']}"`)
+    }
+
+    from_account := strings.TrimSpace(args[0])
+    to_account := strings.TrimSpace(args[1])
+    amount_str := strings.TrimSpace(args[2])
+
+    if from_account == to_account {
+        return shim.Error("from_account and to_account must not be equal")
+    }
+
+    amount, err := validateAmount(stub, DEFAULT_ASSET, "amount", amount_str)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    balance_from, err := getStoredBalance(stub, "from_account", from_account)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    balance_to, err := getStoredBalance(stub, "to_account", to_account)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    holder_from, err := getAccountHolder(stub, from_account)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    holder_to, err := getAccountHolder(stub, to_account)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if holder_from != holder_to {
+        return shim.Error("from_account and to_account do not belong to the same user.")
+    }
+
+    if err = checkCallerOwns(stub, holder_from); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkNotFrozen(stub, from_account); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkNotFrozen(stub, to_account); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = screen(stub, "transfer", amount.String(), from_account, to_account); err != nil {
+        return screeningErrorResponse(err)
+    }
+
+    spendable, err := getSpendableBalance(stub, from_account, balance_from)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if spendable.LessThan(amount) {
+        return shim.Error("Failed move, not enough balance.")
+    }
+
+    err = setBalance(stub, from_account, balance_from, balance_from.Sub(amount))
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    err = setBalance(stub, to_account, balance_to, balance_to.Add(amount))
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    _, err = recordTransfer(stub, from_account, to_account, amount, "")
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(nil)
+}
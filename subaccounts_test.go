@@ -0,0 +1,124 @@
+package main
+
+import (
+    "testing"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestSubAccounts(t *testing.T) {
+    stub := shim.NewMockStub("TestSubAccounts", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "user_a", "")
+    testRegister(t, stub, "user_b", "")
+    testRegister(t, stub, "user_c", "")
+
+    testInvokeFail(t, stub, "openSubAccount", "user_x", "savings", "")
+    testInvokeFail(t, stub, "openSubAccount", "user_a", "sav/ings", "")
+    testInvokeFail(t, stub, "openSubAccount", "user_a", "", "")
+    testInvoke(t, stub, "user_a/savings", "openSubAccount", "user_a", "savings", "")
+    testInvoke(t, stub, "user_a/tax", "openSubAccount", "user_a", "tax", "for taxes")
+    testInvokeFail(t, stub, "openSubAccount", "user_a", "tax", "")
+    testInvokeFail(t, stub, "openSubAccount", "user_a/tax", "q1", "")
+    testInvoke(t, stub, "user_b/invoices", "openSubAccount", "user_b", "invoices", "")
+    testGetUserInfo(t, stub, "user_a/tax", `{"extras":"for taxes","name":"user_a/tax","parent":"user_a"}`)
+    testInvoke(t, stub, mockCallerId, "getUserOwner", "user_a/tax")
+
+    // 只有所有者可以开设子账户
+    testInvoke(t, stub, "", "setUserOwner", "user_c", bobId)
+    testInvokeAsFail(t, stub, "carol", "openSubAccount", "user_c", "savings", "")
+    testInvokeAs(t, stub, "bob", "user_c/savings", "openSubAccount", "user_c", "savings", "")
+    testInvoke(t, stub, bobId, "getUserOwner", "user_c/savings")
+
+    // 自己的账户之间移动资金不需要转账约束
+    testRecharge(t, stub, "user_a", "1000")
+    testInvoke(t, stub, "", "moveBetweenSubAccounts", "user_a", "user_a/savings", "300")
+    testInvoke(t, stub, "", "moveBetweenSubAccounts", "user_a/savings", "user_a/tax", "100")
+    testInvokeFail(t, stub, "moveBetweenSubAccounts", "user_a/tax", "user_a/savings", "101")
+    testInvokeFail(t, stub, "moveBetweenSubAccounts", "user_a/tax", "user_a/tax", "1")
+    testInvokeFail(t, stub, "moveBetweenSubAccounts", "user_a", "user_b/invoices", "1")
+    testInvokeAsFail(t, stub, "bob", "moveBetweenSubAccounts", "user_a", "user_a/tax", "1")
+    testTransfer(t, stub, "user_a/tax", "user_a", "50")
+    testGetBalance(t, stub, "user_a", "750")
+    testGetBalance(t, stub, "user_a/savings", "200")
+    testGetBalance(t, stub, "user_a/tax", "50")
+    testInvoke(t, stub, `[{"account":"user_a/savings","balance":"200","name":"savings"},{"account":"user_a/tax","balance":"50","name":"tax"}]`,
+        "getSubAccounts", "user_a")
+    testInvoke(t, stub, `[{"account":"user_b/invoices","balance":"0","name":"invoices"}]`, "getSubAccounts", "user_b")
+    testInvokeFail(t, stub, "getSubAccounts", "user_x")
+
+    testInvoke(t, stub, "", "freezeAccount", "user_a/savings")
+    testInvokeFail(t, stub, "moveBetweenSubAccounts", "user_a/savings", "user_a", "1")
+    testInvoke(t, stub, "", "unfreezeAccount", "user_a/savings")
+
+    // 子账户之间没有约束时以父账户之间的约束为准
+    testTransferFail(t, stub, "user_a/savings", "user_b", "10")
+    testSetRestraint(t, stub, "user_a", "user_b", "1")
+    testTransfer(t, stub, "user_a/savings", "user_b", "10")
+    testTransfer(t, stub, "user_a/savings", "user_b/invoices", "10")
+    testTransferFail(t, stub, "user_b/invoices", "user_a", "1")
+
+    // 针对对方的某个子账户设置约束
+    testSetRestraint(t, stub, "user_a", "user_b", "0")
+    testTransferFail(t, stub, "user_a", "user_b", "10")
+    testSetRestraint(t, stub, "user_a", "user_b/invoices", "3")
+    testTransfer(t, stub, "user_a", "user_b/invoices", "10")
+    testTransfer(t, stub, "user_b/invoices", "user_a", "5")
+    testTransferFail(t, stub, "user_a", "user_b", "10")
+    testTransferFail(t, stub, "user_a/tax", "user_b/invoices", "10")
+    testGetBalance(t, stub, "user_b", "10")
+    testGetBalance(t, stub, "user_b/invoices", "15")
+}
+
+func TestSubAccountKyc(t *testing.T) {
+    stub := shim.NewMockStub("TestSubAccountKyc", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "user_a", "")
+    testInvoke(t, stub, "user_a/savings", "openSubAccount", "user_a", "savings", "")
+    testRecharge(t, stub, "user_a", "1000")
+
+    testInvoke(t, stub, "", "grantRole", "kyc_officer", mockCallerId)
+    testInvoke(t, stub, "", "setKycPolicy", "1", `{"operations":{"recharge":{},"receive":{},"transfer":{},"withdraw":{}}}`)
+    testInvokeFail(t, stub, "setKycLevel", "user_a/savings", "1")
+
+    // 子账户的等级以父账户为准，内部移动不受 KYC 限制
+    testInvoke(t, stub, "", "moveBetweenSubAccounts", "user_a", "user_a/savings", "100")
+    testInvokeFail(t, stub, "withdraw", "user_a/savings", "10")
+    testInvoke(t, stub, "", "setKycLevel", "user_a", "1")
+    testWithdraw(t, stub, "user_a/savings", "10")
+    testGetBalance(t, stub, "user_a/savings", "90")
+}
+
+func TestSubAccountFreezeAndScreening(t *testing.T) {
+    stub := shim.NewMockStub("TestSubAccountFreezeAndScreening", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "user_a", "")
+    testRegister(t, stub, "user_b", "")
+    testInvoke(t, stub, "user_a/savings", "openSubAccount", "user_a", "savings", "")
+    testSetRestraint(t, stub, "user_a", "user_b", "1")
+    testRecharge(t, stub, "user_a", "100")
+    testInvoke(t, stub, "", "moveBetweenSubAccounts", "user_a", "user_a/savings", "50")
+
+    // 冻结父账户时子账户也不能转出
+    testInvoke(t, stub, "", "freezeAccount", "user_a")
+    testInvoke(t, stub, "true", "isAccountFrozen", "user_a/savings")
+    testTransferFail(t, stub, "user_a/savings", "user_b", "10")
+    testWithdrawFail(t, stub, "user_a/savings", "10")
+    testInvokeFail(t, stub, "moveBetweenSubAccounts", "user_a/savings", "user_a", "10")
+    testInvoke(t, stub, "", "unfreezeAccount", "user_a")
+    testInvoke(t, stub, "false", "isAccountFrozen", "user_a/savings")
+
+    // 父账户在制裁名单上时子账户的转账和内部移动都被拦截
+    testInvoke(t, stub, "", "grantRole", "compliance", mockCallerId)
+    testInvoke(t, stub, "", "addScreeningEntry", "user", "user_a", "sanctioned")
+    testInvoke(t, stub, `{"audit_id":"1","blocked":true,"reason":"user user_a is on the screening list."}`, "transfer", "user_a/savings", "user_b", "10")
+    testInvoke(t, stub, `{"audit_id":"1.1","blocked":true,"reason":"user user_a is on the screening list."}`, "moveBetweenSubAccounts", "user_a/savings", "user_a", "10")
+    testGetBalance(t, stub, "user_a/savings", "50")
+    testGetBalance(t, stub, "user_b", "0")
+
+    testInvoke(t, stub, "", "removeScreeningEntry", "user", "user_a")
+    testTransfer(t, stub, "user_a/savings", "user_b", "10")
+    testGetBalance(t, stub, "user_b", "10")
+}
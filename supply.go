@@ -0,0 +1,252 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+
+    "github.com/shopspring/decimal"
+)
+
+// 总发行量
+// 每种资产的总发行量存储在 ts: 下，key 为 [资产]，由铸造和销毁操作维护：
+// 铸造：recharge、approveDeposit 计入余额，grantVesting 发放锁定额度，rechargeAsset 充值其他资产（见 assets.go）；
+// 销毁：confirmWithdrawal 确认提款已经付出；
+// transferWithConversion 销毁扣除的资产，铸造兑换得到的资产（见 conversion.go）。
+// 其他操作只在账户之间移动余额，或者把余额暂存在托管中（锁定的 HTLC、待处理的提款申请），不改变总发行量。
+// 因此应当满足：所有 u_b: 余额之和 + 托管中的金额 = 总发行量，auditInvariants 用于检查这一点。
+// 在总发行量记录之前已经存在的余额不计入总发行量，auditInvariants 会报告差额。
+
+// 没有指定资产时使用的资产
+const DEFAULT_ASSET = "default"
+
+// auditInvariants 每页最多的账户数
+const MAX_AUDIT_PAGE_SIZE = 1000
+
+func getTotalSupply(stub shim.ChaincodeStubInterface, asset string) (decimal.Decimal, error) {
+    supply_key, err := stub.CreateCompositeKey("ts:", []string{asset})
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("asset is not valid. %s", err.Error())
+    }
+
+    supplyAsBytes, err := stub.GetState(supply_key)
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if supplyAsBytes == nil {
+        return decimal.Zero, nil
+    }
+
+    supply, err := decimal.NewFromString(string(supplyAsBytes))
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("Failed to parse total supply stored. %s", err.Error())
+    }
+
+    return supply, nil
+}
+
+// 铸造（delta 为正）或销毁（delta 为负）后修改总发行量
+func adjustTotalSupply(stub shim.ChaincodeStubInterface, asset string, delta decimal.Decimal) error {
+    supply, err := getTotalSupply(stub, asset)
+    if err != nil {
+        return err
+    }
+
+    supply_key, err := stub.CreateCompositeKey("ts:", []string{asset})
+    if err != nil {
+        return fmt.Errorf("asset is not valid. %s", err.Error())
+    }
+
+    err = stub.PutState(supply_key, []byte(supply.Add(delta).String()))
+    if err != nil {
+        return fmt.Errorf("Failed to put state. %s", err.Error())
+    }
+
+    return nil
+}
+
+// 托管中的金额：锁定的 HTLC 和待处理的提款申请
+func getEscrowedAmount(stub shim.ChaincodeStubInterface) (decimal.Decimal, error) {
+    total := decimal.Zero
+
+    itr, err := stub.GetStateByPartialCompositeKey("h_l:", []string{})
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    defer itr.Close()
+
+    for itr.HasNext() {
+        kv, err := itr.Next()
+        if err != nil {
+            return decimal.Zero, fmt.Errorf("Failed to get hashed timelock stored. %s", err.Error())
+        }
+
+        var lock HashedTimelock
+        err = json.Unmarshal(kv.Value, &lock)
+        if err != nil {
+            return decimal.Zero, fmt.Errorf("Failed to parse hashed timelock stored. %s", err.Error())
+        }
+        if lock.Status != HTLC_LOCKED {
+            continue
+        }
+
+        amount, err := decimal.NewFromString(lock.Amount)
+        if err != nil {
+            return decimal.Zero, fmt.Errorf("Failed to parse hashed timelock stored. %s", err.Error())
+        }
+        total = total.Add(amount)
+    }
+
+    pending_itr, err := stub.GetStateByPartialCompositeKey("w_p:", []string{})
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    defer pending_itr.Close()
+
+    for pending_itr.HasNext() {
+        kv, err := pending_itr.Next()
+        if err != nil {
+            return decimal.Zero, fmt.Errorf("Failed to get withdrawal request stored. %s", err.Error())
+        }
+        _, compositeKeyParts, err := stub.SplitCompositeKey(kv.Key)
+        if err != nil {
+            return decimal.Zero, fmt.Errorf("Failed to parse withdrawal request stored. %s", err.Error())
+        }
+
+        request, err := getWithdrawalRequest(stub, compositeKeyParts[1])
+        if err != nil {
+            return decimal.Zero, err
+        }
+        if request == nil {
+            continue
+        }
+
+        amount, err := decimal.NewFromString(request.Amount)
+        if err != nil {
+            return decimal.Zero, fmt.Errorf("Failed to parse withdrawal request stored. %s", err.Error())
+        }
+        total = total.Add(amount)
+    }
+
+    return total, nil
+}
+
+// 查询总发行量，asset 可以省略
+// 返回值：十进制数 字符串，如 123.456
+func (cc *RestrainedTransferCC) getTotalSupply(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) > 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getTotalSupply', args: ['asset'?]}"`)
+    }
+
+    asset := DEFAULT_ASSET
+    if len(args) == 1 {
+        asset = strings.TrimSpace(args[0])
+    }
+
+    supply, err := getTotalSupply(stub, asset)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success([]byte(supply.String()))
+}
+
+// 分页检查余额之和与总发行量是否一致
+// 按用户名顺序每次读取最多 page_size 个账户的余额。第一页 bookmark 和 running_total 为 ""；
+// 之后每页传入上一页返回的 bookmark（账本分页查询的 bookmark，原样传回即可）和 running_total。最后一页（done 为 true）加上托管中的金额后和总发行量比较。
+// 返回值：json字符串
+// 没有读完时：{"accounts":1000,"bookmark":"...","done":false,"running_total":"123456"}
+// 最后一页：
+// {
+//     "accounts":12,"bookmark":"","done":true,"running_total":"123500",
+//     "escrowed":"500","total_supply":"124000","difference":"0","ok":true
+// }
+// difference 为 余额之和 + 托管中的金额 - 总发行量
+func (cc *RestrainedTransferCC) auditInvariants(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 3 {
+        return shim.Error(`parameter error. usage: "{fcn: 'auditInvariants', args: ['page_size', 'bookmark', 'running_total']}"`)
+    }
+
+    page_size_str := strings.TrimSpace(args[0])
+    bookmark := strings.TrimSpace(args[1])
+    running_total_str := strings.TrimSpace(args[2])
+
+    var err error
+
+    page_size, err := strconv.Atoi(page_size_str)
+    if err != nil || page_size < 1 || page_size > MAX_AUDIT_PAGE_SIZE {
+        return shim.Error(fmt.Sprintf("page_size got %s, expected an integer in [1, %d]", page_size_str, MAX_AUDIT_PAGE_SIZE))
+    }
+
+    running_total := decimal.Zero
+    if running_total_str != "" {
+        running_total, err = decimal.NewFromString(running_total_str)
+        if err != nil {
+            return shim.Error("running_total got " + running_total_str + ", expected a number")
+        }
+    }
+
+    itr, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination("u_b:", []string{}, int32(page_size), bookmark)
+    if err != nil {
+        return shim.Error("Failed to get state. " + err.Error())
+    }
+    defer itr.Close()
+
+    accounts := 0
+
+    for itr.HasNext() {
+        kv, err := itr.Next()
+        if err != nil {
+            return shim.Error("Failed to get balance stored. " + err.Error())
+        }
+
+        balance, err := decimal.NewFromString(string(kv.Value))
+        if err != nil {
+            return shim.Error("Failed to parse balance stored. " + err.Error())
+        }
+
+        running_total = running_total.Add(balance)
+        accounts++
+    }
+
+    done := metadata.Bookmark == "" || accounts < page_size
+
+    result := MAP{
+        "accounts": accounts,
+        "done": done,
+        "running_total": running_total.String(),
+    }
+
+    if !done {
+        result["bookmark"] = metadata.Bookmark
+    } else {
+        escrowed, err := getEscrowedAmount(stub)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+
+        supply, err := getTotalSupply(stub, DEFAULT_ASSET)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+
+        difference := running_total.Add(escrowed).Sub(supply)
+
+        result["bookmark"] = ""
+        result["escrowed"] = escrowed.String()
+        result["total_supply"] = supply.String()
+        result["difference"] = difference.String()
+        result["ok"] = difference.IsZero()
+    }
+
+    resultAsBytes, err := json.Marshal(result)
+    if err != nil {
+        return shim.Error("Failed to format audit result. " + err.Error())
+    }
+
+    return shim.Success(resultAsBytes)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+    "testing"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestTotalSupply(t *testing.T) {
+    stub := shim.NewMockStub("TestTotalSupply", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "user_a", "")
+    testRegister(t, stub, "user_b", "")
+    testRegister(t, stub, "user_c", "")
+    testSetRestraint(t, stub, "user_a", "user_b", "3")
+
+    testInvoke(t, stub, "0", "getTotalSupply")
+    testRecharge(t, stub, "user_a", "100")
+    testRecharge(t, stub, "user_b", "50")
+    testTransfer(t, stub, "user_a", "user_b", "10")
+    testInvoke(t, stub, "150", "getTotalSupply")
+    testInvoke(t, stub, "150", "getTotalSupply", "default")
+    testInvoke(t, stub, "0", "getTotalSupply", "gold")
+
+    // 待处理的提款和锁定的 HTLC 在托管中
+    testInvoke(t, stub, "", "withdraw", "user_b", "30")
+    sum := sha256.Sum256([]byte("secret"))
+    testInvoke(t, stub, "", "lockTransfer", "user_a", "user_b", "20", hex.EncodeToString(sum[:]), "4102444800")
+    testInvoke(t, stub, "150", "getTotalSupply")
+
+    testInvokeFail(t, stub, "auditInvariants", "0", "", "")
+    testInvokeFail(t, stub, "auditInvariants", "10", "", "abc")
+    testInvoke(t, stub, `{"accounts":3,"bookmark":"","difference":"0","done":true,"escrowed":"50","ok":true,"running_total":"100","total_supply":"150"}`,
+        "auditInvariants", "10", "", "")
+
+    // 分页，bookmark 为下一页第一个账户的 key
+    next_key, _ := stub.CreateCompositeKey("u_b:", []string{"user_c"})
+    next_bookmark, _ := json.Marshal(next_key)
+    testInvoke(t, stub, `{"accounts":2,"bookmark":` + string(next_bookmark) + `,"done":false,"running_total":"100"}`, "auditInvariants", "2", "", "")
+    testInvoke(t, stub, `{"accounts":1,"bookmark":"","difference":"0","done":true,"escrowed":"50","ok":true,"running_total":"100","total_supply":"150"}`,
+        "auditInvariants", "2", next_key, "100")
+
+    testInvoke(t, stub, "", "grantRole", "settlement", mockCallerId)
+    testInvoke(t, stub, "", "confirmWithdrawal", "1", "BANK-001")
+    testInvoke(t, stub, "120", "getTotalSupply")
+    testInvoke(t, stub, `{"accounts":3,"bookmark":"","difference":"0","done":true,"escrowed":"20","ok":true,"running_total":"100","total_supply":"120"}`,
+        "auditInvariants", "10", "", "")
+
+    // 直接修改余额会被发现
+    stub.MockTransactionStart("1")
+    balance_key, _ := stub.CreateCompositeKey("u_b:", []string{"user_c"})
+    stub.PutState(balance_key, []byte("7"))
+    stub.MockTransactionEnd("1")
+    testInvoke(t, stub, `{"accounts":3,"bookmark":"","difference":"7","done":true,"escrowed":"20","ok":false,"running_total":"107","total_supply":"120"}`,
+        "auditInvariants", "10", "", "")
+}
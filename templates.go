@@ -0,0 +1,347 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// 转账约束模板
+// admin 通过 setRestraintTemplate 定义命名的约束模板，存储在 rt: 下，key 为 [模板名]，
+// 模板中的 restraint 为从 applyRestraintTemplate 的 username 到每个目标用户的约束，枚举值的含义见 setRestraint。
+// 例如商户入驻时使用 restraint 为 2 的模板（客户可以单向付款给商户），把商户和一批客户或一个组的所有成员之间的约束一次设置好。
+//
+// applyRestraintTemplate 先检查所有目标用户（已注册、不重复、调用者有权限设置，权限见 organizations.go），
+// 全部通过后才写入，任何一个目标不满足时整个调用失败，不修改任何约束。
+// dry_run 为 true 时只返回将要发生的变化，不修改状态。
+
+// 一次 applyRestraintTemplate 最多的目标用户数
+const MAX_TEMPLATE_TARGETS = 1000
+
+type RestraintTemplate struct {
+    Name        string `json:"name"`
+    Restraint   string `json:"restraint"`
+    Description string `json:"description"`
+}
+
+// applyRestraintTemplate 对每个目标用户的结果
+// old 为 "" 表示之前没有 u_r: 约束；status 为 unchanged（约束已经是模板的值）、applied（直接生效）或 pending（跨组织的约束等待对方确认）
+type RestraintChange struct {
+    Username string `json:"username"`
+    Old      string `json:"old"`
+    New      string `json:"new"`
+    Status   string `json:"status"`
+}
+
+// 查询约束模板，不存在时返回 nil
+func getRestraintTemplate(stub shim.ChaincodeStubInterface, name string) (*RestraintTemplate, error) {
+    template_key, err := stub.CreateCompositeKey("rt:", []string{name})
+    if err != nil {
+        return nil, fmt.Errorf("template is not valid. %s", err.Error())
+    }
+
+    templateAsBytes, err := stub.GetState(template_key)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if templateAsBytes == nil {
+        return nil, nil
+    }
+
+    var template RestraintTemplate
+    err = json.Unmarshal(templateAsBytes, &template)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to parse restraint template stored. %s", err.Error())
+    }
+
+    return &template, nil
+}
+
+// 定义或修改约束模板，只有 admin 可以调用
+// 返回值：nil
+func (cc *RestrainedTransferCC) setRestraintTemplate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 3 {
+        return shim.Error(`parameter error. usage: "{fcn: 'setRestraintTemplate', args: ['name', 'restraint_type', 'description']}"`)
+    }
+
+    name := strings.TrimSpace(args[0])
+    restraint_str := strings.TrimSpace(args[1])
+    description := args[2]
+
+    if len(name) == 0 {
+        return shim.Error("name should not be empty.")
+    }
+
+    if len(restraint_str) != 1 || !RestraintType(restraint_str[0]).isValid() {
+        return shim.Error("transfer restraint type got " + restraint_str + ", expected one of [0, 1, 2, 3], respectively [forbid transfer, allow a to b, allow b to a, allow two-way]")
+    }
+
+    var err error
+
+    if err = checkCallerRole(stub, ROLE_ADMIN); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = validateExtras(description); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    template_key, err := stub.CreateCompositeKey("rt:", []string{name})
+    if err != nil {
+        return shim.Error("name is not valid. " + err.Error())
+    }
+
+    templateAsBytes, err := json.Marshal(&RestraintTemplate{
+        Name: name,
+        Restraint: restraint_str,
+        Description: description,
+    })
+    if err != nil {
+        return shim.Error("Failed to format restraint template. " + err.Error())
+    }
+
+    err = stub.PutState(template_key, templateAsBytes)
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 删除约束模板，只有 admin 可以调用；已经设置的约束不受影响
+// 返回值：nil
+func (cc *RestrainedTransferCC) removeRestraintTemplate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'removeRestraintTemplate', args: ['name']}"`)
+    }
+
+    name := strings.TrimSpace(args[0])
+
+    var err error
+
+    if err = checkCallerRole(stub, ROLE_ADMIN); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    template, err := getRestraintTemplate(stub, name)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if template == nil {
+        return shim.Error("template " + name + " is not defined.")
+    }
+
+    template_key, err := stub.CreateCompositeKey("rt:", []string{name})
+    if err != nil {
+        return shim.Error("name is not valid. " + err.Error())
+    }
+
+    err = stub.DelState(template_key)
+    if err != nil {
+        return shim.Error("Failed to del state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询所有约束模板
+// 返回值：json字符串
+// [{"name":"customer_pays_merchant","restraint":"2","description":"customer can pay merchant one-way"}]
+func (cc *RestrainedTransferCC) getRestraintTemplates(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 0 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getRestraintTemplates', args: []}"`)
+    }
+
+    itr, err := stub.GetStateByPartialCompositeKey("rt:", []string{})
+    if err != nil {
+        return shim.Error("Failed to get state. " + err.Error())
+    }
+    defer itr.Close()
+
+    templates := []*RestraintTemplate{}
+
+    for itr.HasNext() {
+        kv, err := itr.Next()
+        if err != nil {
+            return shim.Error("Failed to get restraint template stored. " + err.Error())
+        }
+
+        var template RestraintTemplate
+        err = json.Unmarshal(kv.Value, &template)
+        if err != nil {
+            return shim.Error("Failed to parse restraint template stored. " + err.Error())
+        }
+        templates = append(templates, &template)
+    }
+
+    templatesAsBytes, err := json.Marshal(templates)
+    if err != nil {
+        return shim.Error("Failed to format restraint templates. " + err.Error())
+    }
+
+    return shim.Success(templatesAsBytes)
+}
+
+// 按模板设置 username 与一批用户之间的转账约束
+// target_kind 为 users 时 targets 为用户名的 json 数组，如 ["user_b","user_c"]；为 group 时 targets 为组名，目标为组的所有成员
+// dry_run 为 true 时只返回将要发生的变化；reason 为修改原因，可以省略，见 restraint_audit.go
+// 返回值：json字符串
+// {
+//     "template":"customer_pays_merchant",
+//     "username":"merchant",
+//     "dry_run":false,
+//     "changes":[{"username":"user_b","old":"","new":"2","status":"applied"}]
+// }
+func (cc *RestrainedTransferCC) applyRestraintTemplate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 5 && len(args) != 6 {
+        return shim.Error(`parameter error. usage: "{fcn: 'applyRestraintTemplate', args: ['template', 'username', 'users|group', 'targets', 'dry_run', 'reason'?]}"`)
+    }
+
+    name := strings.TrimSpace(args[0])
+    username := strings.TrimSpace(args[1])
+    target_kind := strings.TrimSpace(args[2])
+    targets_str := strings.TrimSpace(args[3])
+    dry_run_str := strings.TrimSpace(args[4])
+    reason := ""
+    if len(args) == 6 {
+        reason = args[5]
+    }
+
+    dry_run, err := strconv.ParseBool(dry_run_str)
+    if err != nil {
+        return shim.Error("dry_run got " + dry_run_str + ", expected true or false")
+    }
+
+    if err = validateRestraintReason(reason); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    template, err := getRestraintTemplate(stub, name)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if template == nil {
+        return shim.Error("template " + name + " is not defined.")
+    }
+
+    if err = checkRegistered(stub, "username", username); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    var targets []string
+    switch target_kind {
+    case "users":
+        err = json.Unmarshal([]byte(targets_str), &targets)
+        if err != nil {
+            return shim.Error("targets should be a json array of usernames. " + err.Error())
+        }
+    case "group":
+        if err = checkGroupCreated(stub, "targets", targets_str); err != nil {
+            return shim.Error(err.Error())
+        }
+        targets, err = listGroupMembers(stub, targets_str)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+    default:
+        return shim.Error("target_kind got " + target_kind + ", expected users or group")
+    }
+
+    if len(targets) > MAX_TEMPLATE_TARGETS {
+        return shim.Error(fmt.Sprintf("too many targets, got %d, expected at most %d", len(targets), MAX_TEMPLATE_TARGETS))
+    }
+
+    restraint := RestraintType(template.Restraint[0])
+
+    changes := []*RestraintChange{}
+    plans := []*RestraintPlan{}
+    seen := map[string]bool{}
+
+    for _, target := range targets {
+        target = strings.TrimSpace(target)
+
+        // 组的成员包含 username 时跳过它自己
+        if target == username && target_kind == "group" {
+            continue
+        }
+        if target == username {
+            return shim.Error("targets must not contain username " + username)
+        }
+        if seen[target] {
+            return shim.Error("target " + target + " is duplicated.")
+        }
+        seen[target] = true
+
+        if err = checkRegistered(stub, "target", target); err != nil {
+            return shim.Error(err.Error())
+        }
+
+        old, explicit, err := getExplicitRestraint(stub, username, target)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+
+        change := &RestraintChange{
+            Username: target,
+            Old: "",
+            New: string(restraint),
+            Status: "unchanged",
+        }
+        if explicit {
+            change.Old = string(old)
+        }
+
+        if !explicit || old != restraint {
+            plan, err := planRestraint(stub, username, target, restraint)
+            if err != nil {
+                return shim.Error(err.Error())
+            }
+
+            change.Status = "pending"
+            if plan.Apply {
+                change.Status = "applied"
+            }
+            plans = append(plans, plan)
+        } else {
+            plans = append(plans, nil)
+        }
+
+        changes = append(changes, change)
+    }
+
+    if !dry_run {
+        for i, plan := range plans {
+            if plan == nil {
+                continue
+            }
+
+            err = plan.commit(stub)
+            if err != nil {
+                return shim.Error(err.Error())
+            }
+
+            if plan.Apply {
+                err = putRestraint(stub, username, changes[i].Username, restraint, reason)
+                if err != nil {
+                    return shim.Error(err.Error())
+                }
+            }
+        }
+    }
+
+    resultAsBytes, err := json.Marshal(MAP{
+        "template": name,
+        "username": username,
+        "dry_run": dry_run,
+        "changes": changes,
+    })
+    if err != nil {
+        return shim.Error("Failed to format restraint changes. " + err.Error())
+    }
+
+    return shim.Success(resultAsBytes)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+    "testing"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestRestraintTemplates(t *testing.T) {
+    stub := shim.NewMockStub("TestRestraintTemplates", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testInvokeAsFail(t, stub, "bob", "setRestraintTemplate", "customer_pays_merchant", "2", "")
+    testInvokeFail(t, stub, "setRestraintTemplate", "customer_pays_merchant", "4", "")
+    testInvokeFail(t, stub, "setRestraintTemplate", "", "2", "")
+    testInvoke(t, stub, "", "setRestraintTemplate", "customer_pays_merchant", "2", "customer can pay merchant one-way")
+    testInvoke(t, stub, "", "setRestraintTemplate", "partners", "3", "")
+    testInvoke(t, stub, `[{"name":"customer_pays_merchant","restraint":"2","description":"customer can pay merchant one-way"},` +
+        `{"name":"partners","restraint":"3","description":""}]`, "getRestraintTemplates")
+    testInvoke(t, stub, "", "removeRestraintTemplate", "partners")
+    testInvokeFail(t, stub, "removeRestraintTemplate", "partners")
+
+    testRegister(t, stub, "merchant", "")
+    testRegister(t, stub, "user_a", "")
+    testRegister(t, stub, "user_b", "")
+    testRegister(t, stub, "user_c", "")
+    testSetRestraint(t, stub, "merchant", "user_b", "2")
+
+    // 参数错误时不修改任何约束
+    testInvokeFail(t, stub, "applyRestraintTemplate", "partners", "merchant", "users", `["user_a"]`, "false")
+    testInvokeFail(t, stub, "applyRestraintTemplate", "customer_pays_merchant", "merchant", "users", `["user_a","user_x"]`, "false")
+    testInvokeFail(t, stub, "applyRestraintTemplate", "customer_pays_merchant", "merchant", "users", `["user_a","user_a"]`, "false")
+    testInvokeFail(t, stub, "applyRestraintTemplate", "customer_pays_merchant", "merchant", "users", `["user_a","merchant"]`, "false")
+    testInvokeFail(t, stub, "applyRestraintTemplate", "customer_pays_merchant", "merchant", "users", `user_a`, "false")
+    testInvokeFail(t, stub, "applyRestraintTemplate", "customer_pays_merchant", "merchant", "roles", `["user_a"]`, "false")
+    testInvokeFail(t, stub, "applyRestraintTemplate", "customer_pays_merchant", "merchant", "users", `["user_a"]`, "maybe")
+    testInvoke(t, stub, "0", "getRestraintBetweenUsers", "merchant", "user_a")
+
+    // dry_run 只返回变化
+    changes := `"changes":[{"username":"user_a","old":"","new":"2","status":"applied"},{"username":"user_b","old":"2","new":"2","status":"unchanged"}]`
+    testInvoke(t, stub, `{` + changes + `,"dry_run":true,"template":"customer_pays_merchant","username":"merchant"}`,
+        "applyRestraintTemplate", "customer_pays_merchant", "merchant", "users", `["user_a","user_b"]`, "true")
+    testInvoke(t, stub, "0", "getRestraintBetweenUsers", "merchant", "user_a")
+
+    testInvoke(t, stub, `{` + changes + `,"dry_run":false,"template":"customer_pays_merchant","username":"merchant"}`,
+        "applyRestraintTemplate", "customer_pays_merchant", "merchant", "users", `["user_a","user_b"]`, "false")
+    testInvoke(t, stub, "2", "getRestraintBetweenUsers", "merchant", "user_a")
+    testInvoke(t, stub, "1", "getRestraintBetweenUsers", "user_a", "merchant")
+
+    // 按组设置，组成员包含 username 时跳过它自己
+    testInvoke(t, stub, "", "createGroup", "customers", "")
+    testInvoke(t, stub, "", "addGroupMember", "customers", "user_c")
+    testInvoke(t, stub, "", "addGroupMember", "customers", "merchant")
+    testInvokeFail(t, stub, "applyRestraintTemplate", "customer_pays_merchant", "merchant", "group", "suppliers", "false")
+    testInvoke(t, stub, `{"changes":[{"username":"user_c","old":"","new":"2","status":"applied"}],"dry_run":false,"template":"customer_pays_merchant","username":"merchant"}`,
+        "applyRestraintTemplate", "customer_pays_merchant", "merchant", "group", "customers", "false")
+    testRecharge(t, stub, "user_c", "10")
+    testTransfer(t, stub, "user_c", "merchant", "10")
+}
+
+func TestRestraintTemplatesInOrganizations(t *testing.T) {
+    stub := shim.NewMockStub("TestRestraintTemplatesInOrganizations", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testInvoke(t, stub, "", "setRestraintTemplate", "partners", "3", "")
+    testInvoke(t, stub, "", "createOrganization", "org_a", "")
+    testInvoke(t, stub, "", "createOrganization", "org_b", "")
+    testInvoke(t, stub, "", "setOrganizationAdmin", "org_a", bobId, "add")
+
+    for _, username := range []string{"user_a1", "user_a2", "user_b1", "user_x"} {
+        testRegister(t, stub, username, "")
+    }
+    testInvoke(t, stub, "", "addOrganizationMember", "org_a", "user_a1")
+    testInvoke(t, stub, "", "addOrganizationMember", "org_a", "user_a2")
+    testInvoke(t, stub, "", "addOrganizationMember", "org_b", "user_b1")
+
+    // 任何一个目标没有权限时整个调用失败
+    testInvokeAsFail(t, stub, "bob", "applyRestraintTemplate", "partners", "user_a1", "users", `["user_a2","user_x"]`, "false")
+    testInvoke(t, stub, "0", "getRestraintBetweenUsers", "user_a1", "user_a2")
+
+    // 跨组织的约束记录为待确认
+    testInvokeAs(t, stub, "bob", `{"changes":[{"username":"user_a2","old":"","new":"3","status":"applied"},{"username":"user_b1","old":"","new":"3","status":"pending"}],` +
+        `"dry_run":false,"template":"partners","username":"user_a1"}`,
+        "applyRestraintTemplate", "partners", "user_a1", "users", `["user_a2","user_b1"]`, "false")
+    testInvoke(t, stub, "3", "getRestraintBetweenUsers", "user_a1", "user_a2")
+    testInvoke(t, stub, "0", "getRestraintBetweenUsers", "user_a1", "user_b1")
+    testInvoke(t, stub, `[{"username_a":"user_a1","username_b":"user_b1","restraint":"3","org_a":"org_a","org_b":"org_b","approvals":{"org_a":"` + bobId + `"}}]`,
+        "getPendingRestraints", "org_b")
+}
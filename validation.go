@@ -0,0 +1,143 @@
+package main
+
+import (
+    "fmt"
+    "regexp"
+    "strings"
+    "unicode/utf8"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+
+    "github.com/shopspring/decimal"
+)
+
+// 参数检查
+// 用户名、金额和 extras 参数都通过这里的函数检查，检查失败时返回 *ValidationError，
+// 链码返回的错误信息以错误码开头，如 "INVALID_AMOUNT: amount got -1, expected a number greater than 0"，客户端可以按错误码处理。
+// 用户名：1 到 MAX_USERNAME_LENGTH 个字符，只能包含字母、数字和 _ - . @，不能包含空白和组合键的分隔符；
+// 只在 register 时检查，之前注册的用户名仍然可以使用。
+// 金额：所有转移资金的函数（充值、提款、转账、兑换、锁定、定时转账、发放等）的金额必须大于 0 且小于 10^MAX_AMOUNT_EXPONENT，
+// 并且满足资产的金额规则（见 precision.go）；透支额度和储备金额可以为 0，同样必须小于 10^MAX_AMOUNT_EXPONENT。
+// extras：最多 MAX_EXTRAS_SIZE 字节；以 { 或 [ 开头时必须是合法的 json，嵌套不超过 MAX_EXTRAS_DEPTH 层。
+
+// 错误码
+const (
+    ERR_INVALID_USERNAME    = "INVALID_USERNAME"
+    ERR_INVALID_AMOUNT      = "INVALID_AMOUNT"
+    ERR_AMOUNT_PRECISION    = "AMOUNT_PRECISION"
+    ERR_AMOUNT_OUT_OF_RANGE = "AMOUNT_OUT_OF_RANGE"
+    ERR_INVALID_EXTRAS      = "INVALID_EXTRAS"
+)
+
+const MAX_USERNAME_LENGTH = 64
+
+// 金额必须小于 10^MAX_AMOUNT_EXPONENT
+const MAX_AMOUNT_EXPONENT = 18
+
+const MAX_EXTRAS_SIZE = 4096
+
+const MAX_EXTRAS_DEPTH = 8
+
+var usernamePattern = regexp.MustCompile(`^[\p{L}\p{N}_.@-]+$`)
+
+type ValidationError struct {
+    Code    string
+    Message string
+}
+
+func (e *ValidationError) Error() string {
+    return e.Code + ": " + e.Message
+}
+
+func validationError(code string, format string, a ...interface{}) *ValidationError {
+    return &ValidationError{code, fmt.Sprintf(format, a...)}
+}
+
+// 检查新的用户名
+func validateUsername(arg_name, username string) error {
+    length := utf8.RuneCountInString(username)
+    if length == 0 || length > MAX_USERNAME_LENGTH {
+        return validationError(ERR_INVALID_USERNAME, "%s got %q, expected 1 to %d characters", arg_name, username, MAX_USERNAME_LENGTH)
+    }
+    if !usernamePattern.MatchString(username) {
+        return validationError(ERR_INVALID_USERNAME, "%s got %q, expected letters, digits, '_', '-', '.' or '@'", arg_name, username)
+    }
+    return nil
+}
+
+// 解析并检查 asset 的金额，返回按金额规则舍入后的金额
+func validateAmount(stub shim.ChaincodeStubInterface, asset, arg_name, amount_str string) (decimal.Decimal, error) {
+    amount, err := decimal.NewFromString(amount_str)
+    if err != nil {
+        return decimal.Zero, validationError(ERR_INVALID_AMOUNT, "%s got %s, expected a number", arg_name, amount_str)
+    }
+    if amount.LessThanOrEqual(decimal.Zero) {
+        return decimal.Zero, validationError(ERR_INVALID_AMOUNT, "%s got %s, expected a number greater than 0", arg_name, amount_str)
+    }
+    if amount.GreaterThanOrEqual(decimal.New(1, MAX_AMOUNT_EXPONENT)) {
+        return decimal.Zero, validationError(ERR_AMOUNT_OUT_OF_RANGE, "%s got %s, expected a number less than 1e%d", arg_name, amount_str, MAX_AMOUNT_EXPONENT)
+    }
+
+    return applyAmountPolicy(stub, asset, amount)
+}
+
+// 解析并检查可以为 0 的金额，如透支额度、储备金额，和 validateAmount 一样不能超过 10^MAX_AMOUNT_EXPONENT，
+// 这些金额不是一次转移的资金，不按资产的金额规则检查
+func validateNonNegativeAmount(arg_name, amount_str string) (decimal.Decimal, error) {
+    amount, err := decimal.NewFromString(amount_str)
+    if err != nil {
+        return decimal.Zero, validationError(ERR_INVALID_AMOUNT, "%s got %s, expected a number", arg_name, amount_str)
+    }
+    if amount.LessThan(decimal.Zero) {
+        return decimal.Zero, validationError(ERR_INVALID_AMOUNT, "%s got %s, expected a number not less than 0", arg_name, amount_str)
+    }
+    if amount.GreaterThanOrEqual(decimal.New(1, MAX_AMOUNT_EXPONENT)) {
+        return decimal.Zero, validationError(ERR_AMOUNT_OUT_OF_RANGE, "%s got %s, expected a number less than 1e%d", arg_name, amount_str, MAX_AMOUNT_EXPONENT)
+    }
+
+    return amount, nil
+}
+
+// 检查用户的 extras
+func validateExtras(extras string) error {
+    if len(extras) > MAX_EXTRAS_SIZE {
+        return validationError(ERR_INVALID_EXTRAS, "extras has %d bytes, expected at most %d", len(extras), MAX_EXTRAS_SIZE)
+    }
+
+    trimmed := strings.TrimSpace(extras)
+    if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+        return nil
+    }
+
+    var value interface{}
+    if err := json.Unmarshal([]byte(trimmed), &value); err != nil {
+        return validationError(ERR_INVALID_EXTRAS, "extras is not valid json. %s", err.Error())
+    }
+    if depth := jsonDepth(value); depth > MAX_EXTRAS_DEPTH {
+        return validationError(ERR_INVALID_EXTRAS, "extras is nested %d levels, expected at most %d", depth, MAX_EXTRAS_DEPTH)
+    }
+
+    return nil
+}
+
+func jsonDepth(value interface{}) int {
+    depth := 0
+    switch v := value.(type) {
+    case map[string]interface{}:
+        for _, item := range v {
+            if d := jsonDepth(item); d > depth {
+                depth = d
+            }
+        }
+    case []interface{}:
+        for _, item := range v {
+            if d := jsonDepth(item); d > depth {
+                depth = d
+            }
+        }
+    default:
+        return 0
+    }
+    return depth + 1
+}
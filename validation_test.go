@@ -0,0 +1,100 @@
+package main
+
+import (
+    "strings"
+    "testing"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// 调用 fcn 应当失败，并且错误信息以错误码 code 开头
+func testInvokeError(t *testing.T, stub *shim.MockStub, code string, fcn string, args ...string) {
+    ret := mockInvokeWith(stub, "", 0, fcn, args...)
+    if ret.Status != shim.ERROR {
+        t.Fatalf("Invoke %s %v should failed.", fcn, args)
+    }
+    if !strings.HasPrefix(ret.Message, code + ": ") {
+        t.Fatalf("Invoke %s %v failed with %s, expected error code %s", fcn, args, ret.Message, code)
+    }
+}
+
+func TestValidateUsername(t *testing.T) {
+    stub := shim.NewMockStub("TestValidateUsername", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testInvokeError(t, stub, ERR_INVALID_USERNAME, "register", "", "")
+    testInvokeError(t, stub, ERR_INVALID_USERNAME, "register", strings.Repeat("a", MAX_USERNAME_LENGTH + 1), "")
+    testInvokeError(t, stub, ERR_INVALID_USERNAME, "register", "user a", "")
+    testInvokeError(t, stub, ERR_INVALID_USERNAME, "register", "user\x00a", "")
+    testInvokeError(t, stub, ERR_INVALID_USERNAME, "register", "user\U0010FFFFa", "")
+    testInvokeError(t, stub, ERR_INVALID_USERNAME, "register", `user"a`, "")
+
+    testRegister(t, stub, strings.Repeat("a", MAX_USERNAME_LENGTH), "")
+    testRegister(t, stub, "user.a-b@example_1", "")
+    testRegister(t, stub, "用户甲", "")
+}
+
+func TestValidateExtras(t *testing.T) {
+    stub := shim.NewMockStub("TestValidateExtras", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testInvokeError(t, stub, ERR_INVALID_EXTRAS, "register", "user_a", strings.Repeat("x", MAX_EXTRAS_SIZE + 1))
+    testInvokeError(t, stub, ERR_INVALID_EXTRAS, "register", "user_a", `{"company":`)
+    testInvokeError(t, stub, ERR_INVALID_EXTRAS, "register", "user_a", `[[[[[[[[[1]]]]]]]]]`)
+
+    testRegister(t, stub, "user_a", strings.Repeat("x", MAX_EXTRAS_SIZE))
+    testRegister(t, stub, "user_b", `[[[[[[[[1]]]]]]]]`)
+    testRegister(t, stub, "user_c", `{"company":"company C"}`)
+    testRegister(t, stub, "user_d", "company D")
+}
+
+func TestValidateAmount(t *testing.T) {
+    stub := shim.NewMockStub("TestValidateAmount", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "user_a", "")
+    testRegister(t, stub, "user_b", "")
+    testSetRestraint(t, stub, "user_a", "user_b", "1")
+    testRecharge(t, stub, "user_a", "100")
+    testRecharge(t, stub, "user_b", "100")
+
+    // 负数金额不能反向转账绕过约束
+    testInvokeError(t, stub, ERR_INVALID_AMOUNT, "transfer", "user_a", "user_b", "-10")
+    testTransferFail(t, stub, "user_b", "user_a", "10")
+    testGetBalance(t, stub, "user_a", "100")
+    testGetBalance(t, stub, "user_b", "100")
+
+    for _, fcn := range []string{"recharge", "withdraw"} {
+        testInvokeError(t, stub, ERR_INVALID_AMOUNT, fcn, "user_a", "abc")
+        testInvokeError(t, stub, ERR_INVALID_AMOUNT, fcn, "user_a", "0")
+        testInvokeError(t, stub, ERR_INVALID_AMOUNT, fcn, "user_a", "-1")
+        testInvokeError(t, stub, ERR_AMOUNT_OUT_OF_RANGE, fcn, "user_a", "1e18")
+        testInvokeError(t, stub, ERR_AMOUNT_PRECISION, fcn, "user_a", "0.0000000000000000001")
+    }
+    testInvokeError(t, stub, ERR_INVALID_AMOUNT, "transfer", "user_a", "user_b", "abc")
+    testInvokeError(t, stub, ERR_INVALID_AMOUNT, "transfer", "user_a", "user_b", "0")
+    testInvokeError(t, stub, ERR_AMOUNT_OUT_OF_RANGE, "transfer", "user_a", "user_b", "1e30")
+
+    testInvoke(t, stub, "", "setAssetPolicy", "default", "2", "50", "reject")
+    testInvokeError(t, stub, ERR_AMOUNT_OUT_OF_RANGE, "transfer", "user_a", "user_b", "51")
+    testInvokeError(t, stub, ERR_AMOUNT_PRECISION, "transfer", "user_a", "user_b", "0.001")
+
+    // 其他转移资金的函数
+    testInvokeError(t, stub, ERR_INVALID_AMOUNT, "routedTransfer", "user_a", "user_b", "-1", "3", "false")
+    testInvokeError(t, stub, ERR_INVALID_AMOUNT, "lockTransfer", "user_a", "user_b", "-1",
+        "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", "4102444800")
+    testInvokeError(t, stub, ERR_INVALID_AMOUNT, "createScheduledTransfer", "st_1", "user_a", "user_b", "-1", "4102444800", "0", "")
+    testInvokeError(t, stub, ERR_INVALID_AMOUNT, "grantVesting", "user_a", "grant_1", "-1", "1537776000", "100", "1000")
+    testInvokeError(t, stub, ERR_INVALID_AMOUNT, "rechargeAsset", "user_a", "usd", "-1")
+    testInvokeError(t, stub, ERR_INVALID_AMOUNT, "transferWithConversion", "user_a", "user_b", "usd", "default", "-1")
+
+    // 透支额度和储备金额可以为 0，不按资产的金额规则检查
+    testInvokeError(t, stub, ERR_INVALID_AMOUNT, "setCreditLimit", "user_a", "abc")
+    testInvokeError(t, stub, ERR_INVALID_AMOUNT, "setCreditLimit", "user_a", "-1")
+    testInvokeError(t, stub, ERR_AMOUNT_OUT_OF_RANGE, "setCreditLimit", "user_a", "1e18")
+    testInvokeError(t, stub, ERR_AMOUNT_OUT_OF_RANGE, "setCreditLimit", "user_a", "1e30")
+    testInvoke(t, stub, "", "setCreditLimit", "user_a", "100")
+    testInvoke(t, stub, "", "setCreditLimit", "user_a", "0")
+    testInvokeError(t, stub, ERR_INVALID_AMOUNT, "attestReserve", "abc", "AUDIT-1")
+    testInvokeError(t, stub, ERR_INVALID_AMOUNT, "attestReserve", "-1", "AUDIT-1")
+    testInvokeError(t, stub, ERR_AMOUNT_OUT_OF_RANGE, "attestReserve", "1e30", "AUDIT-1")
+}
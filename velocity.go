@@ -0,0 +1,302 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+
+    "github.com/shopspring/decimal"
+)
+
+// 账户风控限额
+// 限额由 admin 设置，存储在 v_l: 下：
+// max_daily_outgoing    每天（UTC）转出和提款的总额，"" 表示不限制
+// max_hourly_transfers  每小时转出的次数，0 表示不限制
+// max_single_withdrawal 单笔提款金额，"" 表示不限制
+// 用量计数器存储在 v_c: 下，key 为 [用户名, 计数器名]，记录计数器所属的时间窗口（按交易时间戳计算的第几天、第几小时），
+// 窗口变化后计数器从 0 开始。
+
+const (
+    SECONDS_PER_HOUR = 3600
+    SECONDS_PER_DAY  = 86400
+)
+
+type VelocityLimits struct {
+    MaxDailyOutgoing    string `json:"max_daily_outgoing"`
+    MaxHourlyTransfers  int64  `json:"max_hourly_transfers"`
+    MaxSingleWithdrawal string `json:"max_single_withdrawal"`
+}
+
+type VelocityCounter struct {
+    Window int64  `json:"window"`
+    Value  string `json:"value"`
+}
+
+// 检查通过后需要写入的计数器，在修改余额时一起写入
+type VelocityUpdate struct {
+    username string
+    counters map[string]VelocityCounter
+}
+
+func getVelocityLimits(stub shim.ChaincodeStubInterface, username string) (VelocityLimits, error) {
+    limits_key, err := stub.CreateCompositeKey("v_l:", []string{username})
+    if err != nil {
+        return VelocityLimits{}, fmt.Errorf("username is not valid. %s", err.Error())
+    }
+
+    limitsAsBytes, err := stub.GetState(limits_key)
+    if err != nil {
+        return VelocityLimits{}, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+
+    var limits VelocityLimits
+    if limitsAsBytes == nil {
+        return limits, nil
+    }
+
+    err = json.Unmarshal(limitsAsBytes, &limits)
+    if err != nil {
+        return VelocityLimits{}, fmt.Errorf("Failed to parse velocity limits stored. %s", err.Error())
+    }
+
+    return limits, nil
+}
+
+// 读取计数器在 window 窗口内的值，窗口不同时返回 0
+func getVelocityCounter(stub shim.ChaincodeStubInterface, username, name string, window int64) (decimal.Decimal, error) {
+    counter_key, err := stub.CreateCompositeKey("v_c:", []string{username, name})
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("username is not valid. %s", err.Error())
+    }
+
+    counterAsBytes, err := stub.GetState(counter_key)
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if counterAsBytes == nil {
+        return decimal.Zero, nil
+    }
+
+    var counter VelocityCounter
+    err = json.Unmarshal(counterAsBytes, &counter)
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("Failed to parse velocity counter stored. %s", err.Error())
+    }
+    if counter.Window != window {
+        return decimal.Zero, nil
+    }
+
+    value, err := decimal.NewFromString(counter.Value)
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("Failed to parse velocity counter stored. %s", err.Error())
+    }
+
+    return value, nil
+}
+
+// 检查 username 转出（operation 为 transfer）或提款（operation 为 withdraw）amount 是否超过限额
+// 返回需要写入的计数器
+func checkVelocity(stub shim.ChaincodeStubInterface, username, operation string, amount decimal.Decimal) (*VelocityUpdate, error) {
+    limits, err := getVelocityLimits(stub, username)
+    if err != nil {
+        return nil, err
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return nil, err
+    }
+
+    day := now.Unix() / SECONDS_PER_DAY
+    hour := now.Unix() / SECONDS_PER_HOUR
+
+    update := &VelocityUpdate{username: username, counters: map[string]VelocityCounter{}}
+
+    if operation == "withdraw" && limits.MaxSingleWithdrawal != "" {
+        max_single_withdrawal, err := decimal.NewFromString(limits.MaxSingleWithdrawal)
+        if err != nil {
+            return nil, fmt.Errorf("Failed to parse velocity limits stored. %s", err.Error())
+        }
+        if amount.GreaterThan(max_single_withdrawal) {
+            return nil, fmt.Errorf("withdraw %s exceeds single withdrawal limit %s of %s.", amount.String(), limits.MaxSingleWithdrawal, username)
+        }
+    }
+
+    daily_outgoing, err := getVelocityCounter(stub, username, "daily_outgoing", day)
+    if err != nil {
+        return nil, err
+    }
+    daily_outgoing = daily_outgoing.Add(amount)
+
+    if limits.MaxDailyOutgoing != "" {
+        max_daily_outgoing, err := decimal.NewFromString(limits.MaxDailyOutgoing)
+        if err != nil {
+            return nil, fmt.Errorf("Failed to parse velocity limits stored. %s", err.Error())
+        }
+        if daily_outgoing.GreaterThan(max_daily_outgoing) {
+            return nil, fmt.Errorf("outgoing %s exceeds daily outgoing limit %s of %s.", amount.String(), limits.MaxDailyOutgoing, username)
+        }
+    }
+
+    update.counters["daily_outgoing"] = VelocityCounter{day, daily_outgoing.String()}
+
+    if operation == "transfer" {
+        hourly_transfers, err := getVelocityCounter(stub, username, "hourly_transfers", hour)
+        if err != nil {
+            return nil, err
+        }
+        hourly_transfers = hourly_transfers.Add(decimal.New(1, 0))
+
+        if limits.MaxHourlyTransfers > 0 && hourly_transfers.GreaterThan(decimal.New(limits.MaxHourlyTransfers, 0)) {
+            return nil, fmt.Errorf("transfer exceeds hourly transfer count limit %d of %s.", limits.MaxHourlyTransfers, username)
+        }
+
+        update.counters["hourly_transfers"] = VelocityCounter{hour, hourly_transfers.String()}
+    }
+
+    return update, nil
+}
+
+// 写入计数器
+func (u *VelocityUpdate) commit(stub shim.ChaincodeStubInterface) error {
+    for _, name := range []string{"daily_outgoing", "hourly_transfers"} {
+        counter, ok := u.counters[name]
+        if !ok {
+            continue
+        }
+
+        counter_key, err := stub.CreateCompositeKey("v_c:", []string{u.username, name})
+        if err != nil {
+            return fmt.Errorf("username is not valid. %s", err.Error())
+        }
+
+        counterAsBytes, err := json.Marshal(counter)
+        if err != nil {
+            return fmt.Errorf("Failed to format velocity counter. %s", err.Error())
+        }
+
+        err = stub.PutState(counter_key, counterAsBytes)
+        if err != nil {
+            return fmt.Errorf("Failed to put state. %s", err.Error())
+        }
+    }
+
+    return nil
+}
+
+// 设置账户的风控限额，只有 admin 可以调用
+// limits 为json字符串，字段含义见 velocity.go
+// {"max_daily_outgoing":"10000","max_hourly_transfers":10,"max_single_withdrawal":"5000"}
+// 返回值：nil
+func (cc *RestrainedTransferCC) setVelocityLimits(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'setVelocityLimits', args: ['username', 'limits']}"`)
+    }
+
+    username := strings.TrimSpace(args[0])
+
+    var err error
+
+    var limits VelocityLimits
+    err = json.Unmarshal([]byte(args[1]), &limits)
+    if err != nil {
+        return shim.Error("Invalid velocity limits, expecting json. " + err.Error())
+    }
+
+    for _, limit := range []string{limits.MaxDailyOutgoing, limits.MaxSingleWithdrawal} {
+        if limit == "" {
+            continue
+        }
+        value, err := decimal.NewFromString(limit)
+        if err != nil || value.LessThan(decimal.Zero) {
+            return shim.Error("Invalid velocity limit " + limit + ", expecting a number not less than 0.")
+        }
+    }
+    if limits.MaxHourlyTransfers < 0 {
+        return shim.Error("Invalid velocity limit, max_hourly_transfers should not be less than 0.")
+    }
+
+    if err = checkCallerRole(stub, ROLE_ADMIN); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if err = checkRegistered(stub, "username", username); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    limits_key, err := stub.CreateCompositeKey("v_l:", []string{username})
+    if err != nil {
+        return shim.Error("username is not valid. " + err.Error())
+    }
+
+    limitsAsBytes, err := json.Marshal(limits)
+    if err != nil {
+        return shim.Error("Failed to format velocity limits. " + err.Error())
+    }
+
+    err = stub.PutState(limits_key, limitsAsBytes)
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询账户的风控限额和当前时间窗口内的用量
+// 返回值：json字符串
+// {
+//     "max_daily_outgoing":"10000",
+//     "daily_outgoing":"1200",
+//     "max_hourly_transfers":10,
+//     "hourly_transfers":3,
+//     "max_single_withdrawal":"5000"
+// }
+func (cc *RestrainedTransferCC) getVelocityUsage(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getVelocityUsage', args: ['username']}"`)
+    }
+
+    username := strings.TrimSpace(args[0])
+
+    var err error
+
+    if err = checkRegistered(stub, "username", username); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    limits, err := getVelocityLimits(stub, username)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    daily_outgoing, err := getVelocityCounter(stub, username, "daily_outgoing", now.Unix() / SECONDS_PER_DAY)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    hourly_transfers, err := getVelocityCounter(stub, username, "hourly_transfers", now.Unix() / SECONDS_PER_HOUR)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    usageAsBytes, err := json.Marshal(MAP{
+        "max_daily_outgoing": limits.MaxDailyOutgoing,
+        "daily_outgoing": daily_outgoing.String(),
+        "max_hourly_transfers": limits.MaxHourlyTransfers,
+        "hourly_transfers": hourly_transfers.IntPart(),
+        "max_single_withdrawal": limits.MaxSingleWithdrawal,
+    })
+    if err != nil {
+        return shim.Error("Failed to format velocity usage. " + err.Error())
+    }
+
+    return shim.Success(usageAsBytes)
+}
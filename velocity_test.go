@@ -0,0 +1,52 @@
+package main
+
+import (
+    "testing"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestVelocityLimits(t *testing.T) {
+    stub := shim.NewMockStub("TestVelocityLimits", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "user_a", "")
+    testRegister(t, stub, "user_b", "")
+    testSetRestraint(t, stub, "user_a", "user_b", "3")
+    testRecharge(t, stub, "user_a", "10000")
+
+    // 2018-09-24 08:00:00 UTC
+    day := int64(1537776000)
+
+    testInvokeAt(t, stub, "", day, `{"daily_outgoing":"0","hourly_transfers":0,"max_daily_outgoing":"","max_hourly_transfers":0,"max_single_withdrawal":""}`, "getVelocityUsage", "user_a")
+
+    testInvokeFail(t, stub, "setVelocityLimits", "user_a", `{"max_daily_outgoing":"abc"}`)
+    testInvokeFail(t, stub, "setVelocityLimits", "user_a", `{"max_single_withdrawal":"-1"}`)
+    testInvokeFail(t, stub, "setVelocityLimits", "user_a", `{"max_hourly_transfers":-1}`)
+    testInvokeFail(t, stub, "setVelocityLimits", "user_x", `{}`)
+    testInvokeAsFail(t, stub, "bob", "setVelocityLimits", "user_a", `{"max_hourly_transfers":2}`)
+    testInvoke(t, stub, "", "setVelocityLimits", "user_a", `{"max_daily_outgoing":"1000","max_hourly_transfers":2,"max_single_withdrawal":"300"}`)
+
+    testInvokeAt(t, stub, "", day, "", "transfer", "user_a", "user_b", "100")
+    testInvokeAt(t, stub, "", day + 60, "", "transfer", "user_a", "user_b", "100")
+    testInvokeAtFail(t, stub, "", day + 120, "transfer", "user_a", "user_b", "100")
+    testInvokeAt(t, stub, "", day + 120, `{"daily_outgoing":"200","hourly_transfers":2,"max_daily_outgoing":"1000","max_hourly_transfers":2,"max_single_withdrawal":"300"}`, "getVelocityUsage", "user_a")
+
+    // 下一个小时
+    testInvokeAt(t, stub, "", day + 3600, "", "transfer", "user_a", "user_b", "500")
+    testInvokeAtFail(t, stub, "", day + 3600, "transfer", "user_a", "user_b", "300.01")
+
+    testInvokeAtFail(t, stub, "", day + 3600, "withdraw", "user_a", "301")
+    testInvokeAt(t, stub, "", day + 3600, "", "withdraw", "user_a", "300")
+    testInvokeAtFail(t, stub, "", day + 3600, "withdraw", "user_a", "1")
+    testInvokeAt(t, stub, "", day + 3600, `{"daily_outgoing":"1000","hourly_transfers":1,"max_daily_outgoing":"1000","max_hourly_transfers":2,"max_single_withdrawal":"300"}`, "getVelocityUsage", "user_a")
+
+    // 失败的转账不计入用量
+    testInvokeAtFail(t, stub, "", day + 86400, "transfer", "user_a", "user_b", "100000")
+    testInvokeAt(t, stub, "", day + 86400, `{"daily_outgoing":"0","hourly_transfers":0,"max_daily_outgoing":"1000","max_hourly_transfers":2,"max_single_withdrawal":"300"}`, "getVelocityUsage", "user_a")
+    testInvokeAt(t, stub, "", day + 86400, "", "transfer", "user_a", "user_b", "1000")
+    testGetBalance(t, stub, "user_a", "8000")
+
+    // 没有限额的账户只记录用量
+    testInvokeAt(t, stub, "", day, "", "transfer", "user_b", "user_a", "100")
+    testInvokeAt(t, stub, "", day, `{"daily_outgoing":"100","hourly_transfers":1,"max_daily_outgoing":"","max_hourly_transfers":0,"max_single_withdrawal":""}`, "getVelocityUsage", "user_b")
+}
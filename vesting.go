@@ -0,0 +1,276 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+
+    "github.com/shopspring/decimal"
+)
+
+// 锁定余额
+// admin 通过 grantVesting 给账户发放锁定的额度，存储在 vt: 下，key 为 [用户名, 额度 id]。
+// 发放的金额直接计入余额，但在释放之前不能转出或提款。
+// 释放规则：start 到 start + cliff 之间全部锁定；之后按 start 到 start + duration 线性释放，
+// 即时间 t 时已释放 amount * (t - start) / duration；start + duration 之后全部释放。
+// 已锁定的金额按交易时间戳计算，不需要单独的交易来释放。
+
+// 已释放金额保留的小数位数，向下取整
+const VESTING_PRECISION = 18
+
+type VestingTranche struct {
+    Id        string `json:"id"`
+    Amount    string `json:"amount"`
+    Start     int64  `json:"start"`
+    Cliff     int64  `json:"cliff"`
+    Duration  int64  `json:"duration"`
+    GrantedBy string `json:"granted_by"`
+}
+
+// 时间 now 时仍然锁定的金额
+func (v VestingTranche) locked(now int64) (decimal.Decimal, error) {
+    amount, err := decimal.NewFromString(v.Amount)
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("Failed to parse vesting tranche stored. %s", err.Error())
+    }
+
+    if now < v.Start + v.Cliff {
+        return amount, nil
+    }
+    if now >= v.Start + v.Duration {
+        return decimal.Zero, nil
+    }
+
+    elapsed := decimal.New(now - v.Start, 0)
+    vested, _ := amount.Mul(elapsed).QuoRem(decimal.New(v.Duration, 0), VESTING_PRECISION)
+
+    return amount.Sub(vested), nil
+}
+
+func listVestingTranches(stub shim.ChaincodeStubInterface, username string) ([]VestingTranche, error) {
+    itr, err := stub.GetStateByPartialCompositeKey("vt:", []string{username})
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    defer itr.Close()
+
+    tranches := []VestingTranche{}
+
+    for itr.HasNext() {
+        kv, err := itr.Next()
+        if err != nil {
+            return nil, fmt.Errorf("Failed to get vesting tranche stored. %s", err.Error())
+        }
+
+        var tranche VestingTranche
+        err = json.Unmarshal(kv.Value, &tranche)
+        if err != nil {
+            return nil, fmt.Errorf("Failed to parse vesting tranche stored. %s", err.Error())
+        }
+        tranches = append(tranches, tranche)
+    }
+
+    return tranches, nil
+}
+
+// 查询账户在交易时间仍然锁定的金额
+func getLockedBalance(stub shim.ChaincodeStubInterface, username string) (decimal.Decimal, error) {
+    tranches, err := listVestingTranches(stub, username)
+    if err != nil {
+        return decimal.Zero, err
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return decimal.Zero, err
+    }
+
+    total := decimal.Zero
+
+    for _, tranche := range tranches {
+        locked, err := tranche.locked(now.Unix())
+        if err != nil {
+            return decimal.Zero, err
+        }
+        total = total.Add(locked)
+    }
+
+    return total, nil
+}
+
+// 账户可以转出或提款的金额：余额减去锁定的金额，再加上透支额度
+func getSpendableBalance(stub shim.ChaincodeStubInterface, username string, balance decimal.Decimal) (decimal.Decimal, error) {
+    locked, err := getLockedBalance(stub, username)
+    if err != nil {
+        return decimal.Zero, err
+    }
+
+    credit_limit, err := getCreditLimit(stub, username)
+    if err != nil {
+        return decimal.Zero, err
+    }
+
+    spendable := balance.Sub(locked).Add(credit_limit)
+    if spendable.LessThan(decimal.Zero) {
+        spendable = decimal.Zero
+    }
+
+    return spendable, nil
+}
+
+// 给账户发放锁定的额度，只有 admin 可以调用
+// start 为开始时间（unix 时间戳），cliff 和 duration 为秒数，cliff 不能大于 duration，duration 大于 0
+// 返回值：nil
+func (cc *RestrainedTransferCC) grantVesting(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 6 {
+        return shim.Error(`parameter error. usage: "{fcn: 'grantVesting', args: ['username', 'id', 'amount', 'start', 'cliff', 'duration']}"`)
+    }
+
+    username := strings.TrimSpace(args[0])
+    id := strings.TrimSpace(args[1])
+    amount_str := strings.TrimSpace(args[2])
+
+    if len(id) == 0 {
+        return shim.Error("id should not be empty.")
+    }
+
+    var err error
+
+    amount, err := validateAmount(stub, DEFAULT_ASSET, "amount", amount_str)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    start, err := parseScheduleTime("start", strings.TrimSpace(args[3]))
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    cliff, err := parseScheduleTime("cliff", strings.TrimSpace(args[4]))
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    duration, err := parseScheduleTime("duration", strings.TrimSpace(args[5]))
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    if duration == 0 {
+        return shim.Error("duration should be greater than 0.")
+    }
+    if cliff > duration {
+        return shim.Error("cliff should not be greater than duration.")
+    }
+
+    if err = checkCallerRole(stub, ROLE_ADMIN); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    balance, err := getStoredBalance(stub, "username", username)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    tranche_key, err := stub.CreateCompositeKey("vt:", []string{username, id})
+    if err != nil {
+        return shim.Error("id is not valid. " + err.Error())
+    }
+
+    trancheAsBytes, err := stub.GetState(tranche_key)
+    if err != nil {
+        return shim.Error("Failed to get state. " + err.Error())
+    }
+    if trancheAsBytes != nil {
+        return shim.Error("vesting tranche " + id + " of " + username + " already exists.")
+    }
+
+    issuance, err := checkIssuance(stub, amount)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    trancheAsBytes, err = json.Marshal(VestingTranche{
+        Id: id,
+        Amount: amount.String(),
+        Start: start,
+        Cliff: cliff,
+        Duration: duration,
+        GrantedBy: caller,
+    })
+    if err != nil {
+        return shim.Error("Failed to format vesting tranche. " + err.Error())
+    }
+
+    err = mint(stub, username, balance, amount, issuance)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    err = stub.PutState(tranche_key, trancheAsBytes)
+    if err != nil {
+        return shim.Error("Failed to put state. " + err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询账户的所有锁定额度，以及每个额度在交易时间仍然锁定的金额
+// 返回值：json字符串
+// [{"id":"grant_1","amount":"1000","start":1537776000,"cliff":2592000,"duration":31536000,"granted_by":"e3b0c442...","locked":"1000"}]
+func (cc *RestrainedTransferCC) getVestingTranches(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getVestingTranches', args: ['username']}"`)
+    }
+
+    username := strings.TrimSpace(args[0])
+
+    var err error
+
+    if err = checkRegistered(stub, "username", username); err != nil {
+        return shim.Error(err.Error())
+    }
+
+    tranches, err := listVestingTranches(stub, username)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    result := []MAP{}
+
+    for _, tranche := range tranches {
+        locked, err := tranche.locked(now.Unix())
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        result = append(result, MAP{
+            "id": tranche.Id,
+            "amount": tranche.Amount,
+            "start": tranche.Start,
+            "cliff": tranche.Cliff,
+            "duration": tranche.Duration,
+            "granted_by": tranche.GrantedBy,
+            "locked": locked.String(),
+        })
+    }
+
+    resultAsBytes, err := json.Marshal(result)
+    if err != nil {
+        return shim.Error("Failed to format vesting tranches. " + err.Error())
+    }
+
+    return shim.Success(resultAsBytes)
+}
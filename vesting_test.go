@@ -0,0 +1,51 @@
+package main
+
+import (
+    "testing"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestVesting(t *testing.T) {
+    stub := shim.NewMockStub("TestVesting", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "user_a", "")
+    testRegister(t, stub, "user_b", "")
+    testSetRestraint(t, stub, "user_a", "user_b", "3")
+    testRecharge(t, stub, "user_a", "100")
+
+    day := int64(1537776000)
+
+    testInvokeFail(t, stub, "grantVesting", "user_a", "grant_1", "0", "1537776000", "100", "1000")
+    testInvokeFail(t, stub, "grantVesting", "user_a", "grant_1", "1000", "1537776000", "100", "0")
+    testInvokeFail(t, stub, "grantVesting", "user_a", "grant_1", "1000", "1537776000", "1001", "1000")
+    testInvokeFail(t, stub, "grantVesting", "user_x", "grant_1", "1000", "1537776000", "100", "1000")
+    testInvokeAsFail(t, stub, "bob", "grantVesting", "user_a", "grant_1", "1000", "1537776000", "100", "1000")
+    // 1000 秒内线性释放，前 100 秒全部锁定
+    testInvoke(t, stub, "", "grantVesting", "user_a", "grant_1", "1000", "1537776000", "100", "1000")
+    testInvokeFail(t, stub, "grantVesting", "user_a", "grant_1", "1000", "1537776000", "100", "1000")
+    testGetBalance(t, stub, "user_a", "1100")
+
+    testInvokeAt(t, stub, "", day + 99, `{"balance":"1100","credit_available":"0","credit_limit":"0","credit_used":"0","locked":"1000","overdrawn_seconds":0,"overdrawn_since":0,"spendable":"100"}`,
+        "getBalance", "user_a", "detail")
+    testInvokeAtFail(t, stub, "", day + 99, "transfer", "user_a", "user_b", "100.01")
+    testInvokeAtFail(t, stub, "", day + 99, "withdraw", "user_a", "100.01")
+
+    testInvokeAt(t, stub, "", day + 100, `[{"amount":"1000","cliff":100,"duration":1000,"granted_by":"` + mockCallerId + `","id":"grant_1","locked":"900","start":1537776000}]`,
+        "getVestingTranches", "user_a")
+    testInvokeAt(t, stub, "", day + 100, "", "transfer", "user_a", "user_b", "150")
+    testInvokeAtFail(t, stub, "", day + 100, "withdraw", "user_a", "50.01")
+    testInvokeAt(t, stub, "", day + 100, "", "withdraw", "user_a", "50")
+
+    testInvokeAt(t, stub, "", day + 500, `{"balance":"900","credit_available":"0","credit_limit":"0","credit_used":"0","locked":"500","overdrawn_seconds":0,"overdrawn_since":0,"spendable":"400"}`,
+        "getBalance", "user_a", "detail")
+
+    // 向下取整已释放的金额
+    testInvoke(t, stub, "", "grantVesting", "user_b", "grant_1", "1", "1537776000", "0", "3")
+    testInvokeAt(t, stub, "", day + 1, `[{"amount":"1","cliff":0,"duration":3,"granted_by":"` + mockCallerId + `","id":"grant_1","locked":"0.666666666666666667","start":1537776000}]`,
+        "getVestingTranches", "user_b")
+
+    // 全部释放后没有限制
+    testInvokeAt(t, stub, "", day + 1000, "", "withdraw", "user_a", "900")
+    testGetBalance(t, stub, "user_a", "0")
+}
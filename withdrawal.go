@@ -0,0 +1,323 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "encoding/json"
+
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+    pb "github.com/hyperledger/fabric/protos/peer"
+
+    "github.com/shopspring/decimal"
+)
+
+// 提款申请
+// withdraw 从余额中扣除提款金额，同时创建一条 pending 状态的提款申请，存储在 w_r: 下，key 为申请 id（规则同转账 id，见 newTxScopedId）。
+// 银行完成付款后，settlement 角色通过 confirmWithdrawal 记录银行流水号；付款失败时通过 failWithdrawal 把金额退回账户。
+// 待处理的申请同时索引在 w_p: 下，key 为 [用户名, 申请 id]，处理后删除。
+// 退回的金额不会恢复已经计入的风控用量（见 velocity.go）。
+
+const (
+    WITHDRAWAL_PENDING   = "pending"
+    WITHDRAWAL_CONFIRMED = "confirmed"
+    WITHDRAWAL_FAILED    = "failed"
+)
+
+type WithdrawalRequest struct {
+    Id            string `json:"id"`
+    Username      string `json:"username"`
+    Amount        string `json:"amount"`
+    Status        string `json:"status"`
+    RequestedAt   int64  `json:"requested_at"`
+    SettledBy     string `json:"settled_by"`
+    SettledAt     int64  `json:"settled_at"`
+    BankReference string `json:"bank_reference"`
+    Reason        string `json:"reason"`
+}
+
+func getWithdrawalRequest(stub shim.ChaincodeStubInterface, id string) (*WithdrawalRequest, error) {
+    request_key, err := stub.CreateCompositeKey("w_r:", []string{id})
+    if err != nil {
+        return nil, fmt.Errorf("withdrawal id is not valid. %s", err.Error())
+    }
+
+    requestAsBytes, err := stub.GetState(request_key)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get state. %s", err.Error())
+    }
+    if requestAsBytes == nil {
+        return nil, nil
+    }
+
+    var request WithdrawalRequest
+    err = json.Unmarshal(requestAsBytes, &request)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to parse withdrawal request stored. %s", err.Error())
+    }
+
+    return &request, nil
+}
+
+func putWithdrawalRequest(stub shim.ChaincodeStubInterface, request *WithdrawalRequest) error {
+    request_key, err := stub.CreateCompositeKey("w_r:", []string{request.Id})
+    if err != nil {
+        return fmt.Errorf("withdrawal id is not valid. %s", err.Error())
+    }
+
+    requestAsBytes, err := json.Marshal(request)
+    if err != nil {
+        return fmt.Errorf("Failed to format withdrawal request. %s", err.Error())
+    }
+
+    err = stub.PutState(request_key, requestAsBytes)
+    if err != nil {
+        return fmt.Errorf("Failed to put state. %s", err.Error())
+    }
+
+    return nil
+}
+
+// 创建 pending 状态的提款申请，返回申请 id
+func createWithdrawalRequest(stub shim.ChaincodeStubInterface, username string, amount decimal.Decimal) (string, error) {
+    now, err := getTxTime(stub)
+    if err != nil {
+        return "", err
+    }
+
+    id, err := newTxScopedId(stub, "w_r:")
+    if err != nil {
+        return "", err
+    }
+
+    err = putWithdrawalRequest(stub, &WithdrawalRequest{
+        Id: id,
+        Username: username,
+        Amount: amount.String(),
+        Status: WITHDRAWAL_PENDING,
+        RequestedAt: now.Unix(),
+    })
+    if err != nil {
+        return "", err
+    }
+
+    pending_key, err := stub.CreateCompositeKey("w_p:", []string{username, id})
+    if err != nil {
+        return "", fmt.Errorf("username is not valid. %s", err.Error())
+    }
+
+    err = stub.PutState(pending_key, []byte{0x00})
+    if err != nil {
+        return "", fmt.Errorf("Failed to put state. %s", err.Error())
+    }
+
+    return id, nil
+}
+
+// 读取 pending 状态的提款申请，检查调用者是 settlement 角色
+func getPendingWithdrawal(stub shim.ChaincodeStubInterface, id string) (*WithdrawalRequest, error) {
+    if err := checkCallerRole(stub, ROLE_SETTLEMENT); err != nil {
+        return nil, err
+    }
+
+    request, err := getWithdrawalRequest(stub, id)
+    if err != nil {
+        return nil, err
+    }
+    if request == nil {
+        return nil, fmt.Errorf("withdrawal %s does not exist.", id)
+    }
+    if request.Status != WITHDRAWAL_PENDING {
+        return nil, fmt.Errorf("withdrawal %s is %s.", id, request.Status)
+    }
+
+    return request, nil
+}
+
+// 结束提款申请：记录处理人和时间，从待处理索引中删除
+func settleWithdrawal(stub shim.ChaincodeStubInterface, request *WithdrawalRequest, status string) error {
+    caller, err := getCallerId(stub)
+    if err != nil {
+        return err
+    }
+
+    now, err := getTxTime(stub)
+    if err != nil {
+        return err
+    }
+
+    request.Status = status
+    request.SettledBy = caller
+    request.SettledAt = now.Unix()
+
+    pending_key, err := stub.CreateCompositeKey("w_p:", []string{request.Username, request.Id})
+    if err != nil {
+        return fmt.Errorf("username is not valid. %s", err.Error())
+    }
+
+    err = stub.DelState(pending_key)
+    if err != nil {
+        return fmt.Errorf("Failed to del state. %s", err.Error())
+    }
+
+    return putWithdrawalRequest(stub, request)
+}
+
+// 确认提款已经由银行付款，只有 settlement 角色可以调用，提款金额从总发行量中扣除（见 supply.go）
+// 返回值：nil
+func (cc *RestrainedTransferCC) confirmWithdrawal(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'confirmWithdrawal', args: ['withdrawal_id', 'bank_reference']}"`)
+    }
+
+    id := strings.TrimSpace(args[0])
+    bank_reference := strings.TrimSpace(args[1])
+
+    if len(bank_reference) == 0 {
+        return shim.Error("bank_reference should not be empty.")
+    }
+
+    request, err := getPendingWithdrawal(stub, id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    amount, err := decimal.NewFromString(request.Amount)
+    if err != nil {
+        return shim.Error("Failed to parse withdrawal request stored. " + err.Error())
+    }
+
+    request.BankReference = bank_reference
+
+    err = settleWithdrawal(stub, request, WITHDRAWAL_CONFIRMED)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    err = adjustTotalSupply(stub, DEFAULT_ASSET, amount.Neg())
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 银行付款失败，把提款金额退回账户，只有 settlement 角色可以调用
+// 返回值：nil
+func (cc *RestrainedTransferCC) failWithdrawal(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 2 {
+        return shim.Error(`parameter error. usage: "{fcn: 'failWithdrawal', args: ['withdrawal_id', 'reason']}"`)
+    }
+
+    id := strings.TrimSpace(args[0])
+    reason := strings.TrimSpace(args[1])
+
+    request, err := getPendingWithdrawal(stub, id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    amount, err := decimal.NewFromString(request.Amount)
+    if err != nil {
+        return shim.Error("Failed to parse withdrawal request stored. " + err.Error())
+    }
+
+    balance, err := getStoredBalance(stub, "username", request.Username)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    err = setBalance(stub, request.Username, balance, balance.Add(amount))
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    request.Reason = reason
+
+    err = settleWithdrawal(stub, request, WITHDRAWAL_FAILED)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    return shim.Success(nil)
+}
+
+// 查询提款申请
+// 返回值：json字符串
+// {
+//     "id":"txid","username":"user_a","amount":"100","status":"confirmed","requested_at":1537776000,
+//     "settled_by":"81b637d8...","settled_at":1537779600,"bank_reference":"BANK-001","reason":""
+// }
+func (cc *RestrainedTransferCC) getWithdrawal(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) != 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getWithdrawal', args: ['withdrawal_id']}"`)
+    }
+
+    id := strings.TrimSpace(args[0])
+
+    request, err := getWithdrawalRequest(stub, id)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if request == nil {
+        return shim.Error("withdrawal " + id + " does not exist.")
+    }
+
+    requestAsBytes, err := json.Marshal(request)
+    if err != nil {
+        return shim.Error("Failed to format withdrawal request. " + err.Error())
+    }
+
+    return shim.Success(requestAsBytes)
+}
+
+// 查询待处理的提款申请，省略 username 时查询所有用户的
+// 返回值：json字符串，格式同 getWithdrawal 的数组，按用户名和申请 id 排序
+func (cc *RestrainedTransferCC) getPendingWithdrawals(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+    if len(args) > 1 {
+        return shim.Error(`parameter error. usage: "{fcn: 'getPendingWithdrawals', args: ['username'?]}"`)
+    }
+
+    var err error
+
+    keys := []string{}
+    if len(args) == 1 {
+        username := strings.TrimSpace(args[0])
+        if err = checkRegistered(stub, "username", username); err != nil {
+            return shim.Error(err.Error())
+        }
+        keys = append(keys, username)
+    }
+
+    itr, err := stub.GetStateByPartialCompositeKey("w_p:", keys)
+    if err != nil {
+        return shim.Error("Failed to get state. " + err.Error())
+    }
+    defer itr.Close()
+
+    requests := []*WithdrawalRequest{}
+
+    for itr.HasNext() {
+        kv, err := itr.Next()
+        if err != nil {
+            return shim.Error("Failed to get withdrawal request stored. " + err.Error())
+        }
+        _, compositeKeyParts, err := stub.SplitCompositeKey(kv.Key)
+        if err != nil {
+            return shim.Error("Failed to parse withdrawal request stored. " + err.Error())
+        }
+        request, err := getWithdrawalRequest(stub, compositeKeyParts[1])
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        if request != nil {
+            requests = append(requests, request)
+        }
+    }
+
+    requestsAsBytes, err := json.Marshal(requests)
+    if err != nil {
+        return shim.Error("Failed to format withdrawal requests. " + err.Error())
+    }
+
+    return shim.Success(requestsAsBytes)
+}
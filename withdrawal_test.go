@@ -0,0 +1,53 @@
+package main
+
+import (
+    "testing"
+    "github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestWithdrawalRequest(t *testing.T) {
+    stub := shim.NewMockStub("TestWithdrawalRequest", new(RestrainedTransferCC))
+    testInit(t, stub)
+
+    testRegister(t, stub, "user_a", "")
+    testRegister(t, stub, "user_b", "")
+    testRecharge(t, stub, "user_a", "100")
+    testRecharge(t, stub, "user_b", "100")
+
+    day := int64(1537776000)
+
+    // 同一个 MockStub 的交易 id 都是 "1"，之后的申请 id 依次加后缀
+    testInvokeAt(t, stub, "", day, "", "withdraw", "user_a", "30")
+    testInvokeAt(t, stub, "", day, "", "withdraw", "user_b", "20")
+    testInvokeAt(t, stub, "", day, "", "withdraw", "user_a", "10")
+    testGetBalance(t, stub, "user_a", "60")
+    testGetBalance(t, stub, "user_b", "80")
+
+    pending_a_1 := `{"id":"1","username":"user_a","amount":"30","status":"pending","requested_at":1537776000,"settled_by":"","settled_at":0,"bank_reference":"","reason":""}`
+    pending_b := `{"id":"1.1","username":"user_b","amount":"20","status":"pending","requested_at":1537776000,"settled_by":"","settled_at":0,"bank_reference":"","reason":""}`
+    pending_a_2 := `{"id":"1.2","username":"user_a","amount":"10","status":"pending","requested_at":1537776000,"settled_by":"","settled_at":0,"bank_reference":"","reason":""}`
+    testInvoke(t, stub, "[" + pending_a_1 + "," + pending_a_2 + "," + pending_b + "]", "getPendingWithdrawals")
+    testInvoke(t, stub, "[" + pending_a_1 + "," + pending_a_2 + "]", "getPendingWithdrawals", "user_a")
+    testInvokeFail(t, stub, "getPendingWithdrawals", "user_x")
+
+    testInvokeFail(t, stub, "confirmWithdrawal", "1", "BANK-001")
+    testInvoke(t, stub, "", "grantRole", "settlement", bobId)
+    testInvokeAsFail(t, stub, "bob", "confirmWithdrawal", "1", "")
+    testInvokeAsFail(t, stub, "bob", "confirmWithdrawal", "9", "BANK-001")
+    testInvokeAt(t, stub, "bob", day + 60, "", "confirmWithdrawal", "1", "BANK-001")
+    testInvokeAsFail(t, stub, "bob", "confirmWithdrawal", "1", "BANK-001")
+    testInvokeAsFail(t, stub, "bob", "failWithdrawal", "1", "rejected")
+    testInvoke(t, stub, `{"id":"1","username":"user_a","amount":"30","status":"confirmed","requested_at":1537776000,` +
+        `"settled_by":"` + bobId + `","settled_at":1537776060,"bank_reference":"BANK-001","reason":""}`, "getWithdrawal", "1")
+
+    // 付款失败退回
+    testInvokeFail(t, stub, "failWithdrawal", "1.2", "account closed")
+    testInvokeAt(t, stub, "bob", day + 120, "", "failWithdrawal", "1.2", "account closed")
+    testGetBalance(t, stub, "user_a", "70")
+    testInvoke(t, stub, `{"id":"1.2","username":"user_a","amount":"10","status":"failed","requested_at":1537776000,` +
+        `"settled_by":"` + bobId + `","settled_at":1537776120,"bank_reference":"","reason":"account closed"}`, "getWithdrawal", "1.2")
+
+    testInvoke(t, stub, "[]", "getPendingWithdrawals", "user_a")
+    testInvoke(t, stub, "[" + pending_b + "]", "getPendingWithdrawals")
+    testInvokeFail(t, stub, "getWithdrawal", "9")
+}